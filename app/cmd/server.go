@@ -1,21 +1,28 @@
 package cmd
 
 import (
+	"math"
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
+	lhtypes "github.com/longhorn/go-common-libs/types"
 	"github.com/longhorn/types/pkg/generated/smrpc"
 	"google.golang.org/grpc"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 
+	"github.com/longhorn/longhorn-share-manager/pkg/crypto"
 	"github.com/longhorn/longhorn-share-manager/pkg/rpc"
 	"github.com/longhorn/longhorn-share-manager/pkg/server"
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
 	"github.com/longhorn/longhorn-share-manager/pkg/util"
 	"github.com/longhorn/longhorn-share-manager/pkg/volume"
 )
@@ -33,6 +40,12 @@ func ServerCmd() cli.Command {
 				Usage:    "The volume to export via the nfs server",
 				Required: true,
 			},
+			cli.StringFlag{
+				Name:     "volume-uid",
+				Usage:    "the unique id of the volume, used to derive a stable nfs filesystem id",
+				EnvVar:   "VOLUME_UID",
+				Required: false,
+			},
 			cli.BoolFlag{
 				Name:     "encrypted",
 				Usage:    "signals that a volume is encrypted",
@@ -69,6 +82,13 @@ func ServerCmd() cli.Command {
 				EnvVar:   "CRYPTOPBKDF",
 				Required: false,
 			},
+			cli.StringFlag{
+				Name:     "data-engine",
+				Usage:    "the longhorn data engine of the volume (v1, v2)",
+				Value:    "v1",
+				EnvVar:   "DATA_ENGINE",
+				Required: false,
+			},
 			cli.StringFlag{
 				Name:     "fs",
 				Usage:    "the filesystem to use for the volume",
@@ -80,37 +100,670 @@ func ServerCmd() cli.Command {
 				Usage:    "allows for specifying additional mount options",
 				Required: false,
 			},
+			cli.StringFlag{
+				Name:     "compression",
+				Usage:    "the transparent compression of btrfs volumes in the form algorithm[:level], e.g. zstd:3",
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "export-retry-timeout",
+				Usage:    "how long creating the export is retried while the nfs server fails transiently, e.g. during its startup, zero does not retry",
+				Value:    10 * time.Second,
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "mount-timeout",
+				Usage:    "the maximum time a mount request may take before it is aborted",
+				Value:    types.GRPCServiceTimeout,
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "unmount-timeout",
+				Usage:    "the maximum time an unmount may take before it is aborted, zero does not bound it",
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "trim-timeout",
+				Usage:    "the maximum time a filesystem trim may take before it is aborted",
+				Value:    lhtypes.ExecuteDefaultTimeout,
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "resize-timeout",
+				Usage:    "the maximum time a filesystem resize may take before it is aborted, zero does not bound it",
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "passphrase-min-length",
+				Usage:    "the minimum passphrase length enforced before a new encrypted volume is formatted, zero does not enforce it",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "passphrase-required-classes",
+				Usage:    "comma separated character classes (lower, upper, digit, symbol) a passphrase must contain before a new encrypted volume is formatted",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "crypto-header",
+				Usage:    "a file on the host holding the detached LUKS header of the encrypted volume, empty keeps the header on the device",
+				Required: false,
+			},
+			cli.BoolFlag{
+				Name:     "crypto-name-with-uid",
+				Usage:    "appends the volume UID to the crypto device mapper name to avoid name collisions",
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "crypto-timeout",
+				Usage:    "the maximum time a cryptsetup call may take before it is aborted",
+				Value:    lhtypes.LuksTimeout,
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "grpc-keepalive-time",
+				Usage:    "the idle time after which the gRPC server pings the client",
+				Value:    rpc.DefaultKeepaliveTime,
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "grpc-keepalive-timeout",
+				Usage:    "how long the gRPC server waits for a ping ack before closing the connection",
+				Value:    rpc.DefaultKeepaliveTimeout,
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "grpc-max-recv-msg-size",
+				Usage:    "the maximum size in bytes of a message received by the gRPC server",
+				Value:    rpc.DefaultMaxMsgSize,
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "grpc-max-send-msg-size",
+				Usage:    "the maximum size in bytes of a message sent by the gRPC server",
+				Value:    rpc.DefaultMaxMsgSize,
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "device-wait-timeout",
+				Usage:    "how long a mount request waits for the volume device to become valid after an attach",
+				Value:    10 * time.Second,
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "mount-attempts",
+				Usage:    "how often mounting the volume is attempted with backoff while the device is not ready, e.g. during an attach race",
+				Value:    1,
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "mount-context",
+				Usage:    "the SELinux context the filesystem is mounted with, e.g. system_u:object_r:container_file_t:s0, empty uses the policy default",
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "format-block-size",
+				Usage:    "the block size in bytes the volume is formatted with on first mount, a power of two between 1024 and 4096, zero keeps the mkfs default",
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "format-inode-size",
+				Usage:    "the inode size in bytes the volume is formatted with on first mount, zero keeps the mkfs default",
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "format-bytes-per-inode",
+				Usage:    "the bytes per inode of ext volumes formatted on first mount, a lower ratio creates more inodes for many small files, zero keeps the mkfs default",
+				Required: false,
+			},
+			cli.BoolFlag{
+				Name:     "discard",
+				Usage:    "mounts the volume with online discard instead of relying on fstrim",
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "reserved-blocks-percentage",
+				Usage:    "the percentage of ext filesystem blocks reserved for root when the volume is formatted (0-50)",
+				Required: false,
+			},
+			cli.BoolFlag{
+				Name:     "verify-fingerprint",
+				Usage:    "stores the volume UID on the filesystem and refuses to export a filesystem created for another volume, requires the volume UID",
+				Required: false,
+			},
+			cli.Int64Flag{
+				Name:     "reserved-space",
+				Usage:    "the bytes preallocated on the filesystem when the volume is formatted on first mount, zero disables it",
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "commit-interval",
+				Usage:    "how often the journal of ext3/ext4 volumes is committed, a longer interval batches journal writes but loses more writes on a crash, zero keeps the default",
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "xfs-logbufs",
+				Usage:    "the number of in-memory log buffers of xfs volumes between 2 and 8, zero keeps the default",
+				Required: false,
+			},
+			cli.Int64Flag{
+				Name:     "xfs-logbsize",
+				Usage:    "the size in bytes of each in-memory log buffer of xfs volumes, a power of two between 16KiB and 256KiB, zero keeps the default",
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "export-reconcile-interval",
+				Usage:    "the interval the export is checked against the config and the running nfs server and restored if it was lost, zero disables it",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "status-address",
+				Usage:    "the address of the HTTP server serving the volume status as JSON at /status and the Prometheus metrics at /metrics, e.g. :9601, empty disables it",
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "ganesha-stats-interval",
+				Usage:    "the interval the cpu and memory usage of the nfs server process is sampled at, zero samples on request",
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "trim-interval",
+				Usage:    "the interval of the background filesystem trim, zero disables it",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "trim-window",
+				Usage:    "restricts the background filesystem trim to a daily time window in the form HH:MM-HH:MM",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "trim-window-timezone",
+				Usage:    "the timezone of the trim window",
+				Value:    "UTC",
+				Required: false,
+			},
+			cli.Float64Flag{
+				Name:     "trim-max-utilization",
+				Usage:    "the device utilization percentage above which a trim is refused, zero disables the check",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "post-mount-hook",
+				Usage:    "a command run after the volume is mounted and exported, {{.MountPath}} and {{.Volume}} are substituted",
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "post-mount-hook-timeout",
+				Usage:    "the maximum run time of the post mount hook",
+				Value:    time.Minute,
+				Required: false,
+			},
+			cli.BoolFlag{
+				Name:     "post-mount-hook-fatal",
+				Usage:    "fails the mount when the post mount hook fails instead of logging a warning",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "pre-unmount-hook",
+				Usage:    "a command run before the volume is unexported and unmounted, {{.MountPath}} and {{.Volume}} are substituted",
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "pre-unmount-hook-timeout",
+				Usage:    "the maximum run time of the pre unmount hook",
+				Value:    time.Minute,
+				Required: false,
+			},
+			cli.BoolFlag{
+				Name:     "pre-unmount-hook-fatal",
+				Usage:    "aborts the unmount when the pre unmount hook fails instead of logging a warning",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "trim-device-check",
+				Usage:    "how a trim verifies the device of the mount point: strict, relaxed to accept devices layered on the volume device like multipath or device mapper, or skip. Relaxing the check risks trimming a filesystem that is not the volume",
+				Value:    rpc.TrimDeviceCheckStrict,
+				Required: false,
+			},
+			cli.Float64Flag{
+				Name:     "inode-usage-threshold",
+				Usage:    "the inode usage percentage above which the volume is reported as degraded, zero disables the check",
+				Required: false,
+			},
+			cli.BoolFlag{
+				Name:     "cleanup-stale-mounts",
+				Usage:    "unmounts a stale mount and removes a stale export of the volume left behind by a previous instance on startup, a healthy mount is reused",
+				Required: false,
+			},
+			cli.BoolFlag{
+				Name:     "mount-without-nfs-server",
+				Usage:    "mounts the volume without exporting it while the nfs server is not running, instead of skipping the mount",
+				Required: false,
+			},
+			cli.BoolFlag{
+				Name:     "verify-export",
+				Usage:    "enables the export verification, which briefly mounts the export from within the pod",
+				Required: false,
+			},
+			cli.BoolFlag{
+				Name:     "nfs-acl",
+				Usage:    "enables NFSv4 ACL support for the export",
+				EnvVar:   "NFS_ACL",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "krb5-keytab",
+				Usage:    "the keytab used by the nfs server for Kerberos security flavors",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "krb5-principal",
+				Usage:    "the service principal name of the nfs server in the keytab",
+				Value:    "nfs",
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "nfs-lease-lifetime",
+				Usage:    "the NFSv4 lease lifetime in seconds",
+				Value:    60,
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "nfs-grace-period",
+				Usage:    "the NFSv4 grace period in seconds, must not be shorter than the lease lifetime",
+				Value:    90,
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "nfs-max-connections",
+				Usage:    "caps the concurrent client connections of the nfs server, zero keeps the ganesha default",
+				Required: false,
+			},
+			cli.DurationFlag{
+				Name:     "nfs-reload-timeout",
+				Usage:    "how long to wait for the nfs server to apply a reload of its config, calls changing the exports are blocked meanwhile",
+				Value:    5 * time.Second,
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "nfs-dbus-address",
+				Usage:    "the DBus bus the nfs server registers on and is managed through, e.g. unix:path=/run/dbus/system_bus_socket, empty uses the system bus",
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "nfs-minor-version-floor",
+				Usage:    "the oldest NFSv4 minor version accepted (0 to 2), the default of 1 rejects NFSv4.0 clients",
+				Value:    1,
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "nfs-max-requests",
+				Usage:    "caps the requests queued or in progress in the nfs server (Dispatch_Max_Reqs, up to 10000), zero keeps the ganesha default",
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "nfs-max-requests-per-connection",
+				Usage:    "caps the requests queued or in progress of a client connection (Dispatch_Max_Reqs_Xprt, up to 2048), zero keeps the ganesha default",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "nfs-recovery-backend",
+				Usage:    "the store of the NFSv4 client recovery state (longhorn, rados_kv, rados_ng, rados_cluster)",
+				Value:    "longhorn",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "rados-pool",
+				Usage:    "the RADOS pool of the rados recovery backends",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "rados-namespace",
+				Usage:    "the RADOS namespace of the rados recovery backends",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "rados-userid",
+				Usage:    "the ceph user of the rados recovery backends",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "rados-ceph-conf",
+				Usage:    "the ceph config of the rados recovery backends",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "rados-nodeid",
+				Usage:    "the node id of this server in a rados_cluster",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "nfs-bind-address",
+				Usage:    "the local address the nfs server listens on, empty listens on all interfaces",
+				Required: false,
+			},
+			cli.StringSliceFlag{
+				Name:     "nfs-log-component",
+				Usage:    "overrides the log level of a ganesha component in the form COMPONENT=LEVEL, e.g. NFS_V4=FULL_DEBUG",
+				Required: false,
+			},
+			cli.UintFlag{
+				Name:     "nfs-export-id",
+				Usage:    "pins the export id of the volume, zero allocates a free id",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "nfs-squash",
+				Usage:    "the uid/gid mapping of the nfs clients (none, root_squash, all_squash)",
+				Value:    "none",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "nfs-delegations",
+				Usage:    "the NFSv4 delegations granted to the nfs clients (none, read, write), empty keeps the ganesha default",
+				Required: false,
+			},
+			cli.Int64Flag{
+				Name:     "nfs-anon-uid",
+				Usage:    "the uid squashed clients are mapped to, required for all_squash",
+				Value:    -1,
+				Required: false,
+			},
+			cli.Int64Flag{
+				Name:     "nfs-anon-gid",
+				Usage:    "the gid squashed clients are mapped to, required for all_squash",
+				Value:    -1,
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "nfs-pseudo-root",
+				Usage:    "exports the volumes under this NFSv4 pseudo filesystem directory with an explicit read only pseudo root, so clients can mount / and see all shares",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "nfs-log-path",
+				Usage:    "the log file of the nfs server, empty logs to /tmp/ganesha.log, which is also copied to the container output when running as pid 1",
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "nfs-mount-port",
+				Usage:    "pins the port of the NFSv3 mount service, zero uses a dynamic port",
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "nfs-nlm-port",
+				Usage:    "pins the port of the NFSv3 lock manager service, zero uses a dynamic port",
+				Required: false,
+			},
+			cli.IntFlag{
+				Name:     "nfs-rquota-port",
+				Usage:    "pins the port of the NFSv3 rquota service, zero uses a dynamic port",
+				Required: false,
+			},
+			cli.StringSliceFlag{
+				Name:     "nfs-access-rule",
+				Usage:    "restricts the export to clients in the form client=access with access RW, RO or None, e.g. 10.0.0.0/24=RO",
+				Required: false,
+			},
+			cli.Uint64Flag{
+				Name:     "nfs-max-read",
+				Usage:    "the largest read size in bytes per operation of the export (512 to 67108864), zero keeps the nfs server default",
+				Required: false,
+			},
+			cli.Uint64Flag{
+				Name:     "nfs-max-write",
+				Usage:    "the largest write size in bytes per operation of the export (512 to 67108864), zero keeps the nfs server default",
+				Required: false,
+			},
+			cli.StringFlag{
+				Name:     "nfs-fsal",
+				Usage:    "the ganesha FSAL backing the export, e.g. VFS or GLUSTER",
+				Value:    nfs.FSALVFS,
+				Required: false,
+			},
+			cli.StringSliceFlag{
+				Name:     "nfs-fsal-option",
+				Usage:    "adds a parameter to the FSAL block of the export in the form key=value",
+				Required: false,
+			},
+			cli.StringSliceFlag{
+				Name:     "nfs-sec",
+				Usage:    "the security flavors of the export (sys, krb5, krb5i, krb5p), defaults to sys",
+				Required: false,
+			},
 		},
 		Action: func(c *cli.Context) {
 			vol := volume.Volume{
-				Name:            c.String("volume"),
-				Passphrase:      c.String("passphrase"),
-				CryptoKeyCipher: c.String("crytpokeycipher"),
-				CryptoKeyHash:   c.String("crytpokeyhash"),
-				CryptoKeySize:   c.String("crytpokeysize"),
-				CryptoPBKDF:     c.String("crytpopbkdf"),
-				FsType:          c.String("fs"),
-				MountOptions:    c.StringSlice("mount"),
+				Name:              c.String("volume"),
+				UID:               c.String("volume-uid"),
+				Passphrase:        c.String("passphrase"),
+				CryptoKeyCipher:   c.String("crytpokeycipher"),
+				CryptoKeyHash:     c.String("crytpokeyhash"),
+				CryptoKeySize:     c.String("crytpokeysize"),
+				CryptoPBKDF:       c.String("crytpopbkdf"),
+				CryptoTimeout:     c.Duration("crypto-timeout"),
+				CryptoNameWithUID: c.Bool("crypto-name-with-uid"),
+				CryptoHeaderPath:  c.String("crypto-header"),
+				FsType:            c.String("fs"),
+				MountOptions:      c.StringSlice("mount"),
+				EnableACL:         c.Bool("nfs-acl"),
+				NFSSecTypes:       c.StringSlice("nfs-sec"),
+				Discard:           c.Bool("discard"),
+				MountContext:      c.String("mount-context"),
+				MountAttempts:     c.Int("mount-attempts"),
+				ReservedSpace:     c.Int64("reserved-space"),
+				VerifyFingerprint: c.Bool("verify-fingerprint"),
+				Compression:       c.String("compression"),
+				DataEngine:        volume.DataEngine(c.String("data-engine")),
+
+				ReservedBlocksPercentage: c.Int("reserved-blocks-percentage"),
+				CommitInterval:           c.Duration("commit-interval"),
+				XFSLogBuffers:            c.Int("xfs-logbufs"),
+				XFSLogBufferSize:         c.Int64("xfs-logbsize"),
+				FormatBlockSize:          c.Int("format-block-size"),
+				FormatInodeSize:          c.Int("format-inode-size"),
+				FormatBytesPerInode:      c.Int("format-bytes-per-inode"),
 			}
 
 			if c.Bool("encrypted") && len(vol.Passphrase) == 0 {
 				logrus.Fatalf("Error starting share-manager missing passphrase for encrypted volume %v", vol.Name)
 			}
 
-			if err := start(vol); err != nil {
+			exportID := c.Uint("nfs-export-id")
+			if exportID > math.MaxUint16 {
+				logrus.Fatalf("Error starting share-manager invalid export id %v", exportID)
+			}
+			vol.NFSExportID = uint16(exportID)
+
+			vol.NFSSquash = c.String("nfs-squash")
+			vol.NFSDelegations = c.String("nfs-delegations")
+			if uid := c.Int64("nfs-anon-uid"); uid >= 0 {
+				if uid > math.MaxUint32 {
+					logrus.Fatalf("Error starting share-manager invalid anonymous uid %v", uid)
+				}
+				anonymousUID := uint32(uid)
+				vol.NFSAnonymousUID = &anonymousUID
+			}
+			if gid := c.Int64("nfs-anon-gid"); gid >= 0 {
+				if gid > math.MaxUint32 {
+					logrus.Fatalf("Error starting share-manager invalid anonymous gid %v", gid)
+				}
+				anonymousGID := uint32(gid)
+				vol.NFSAnonymousGID = &anonymousGID
+			}
+
+			for _, accessRule := range c.StringSlice("nfs-access-rule") {
+				rule, err := nfs.ParseAccessRule(accessRule)
+				if err != nil {
+					logrus.Fatalf("Error starting share-manager invalid access rule: %v", err)
+				}
+				vol.NFSAccessRules = append(vol.NFSAccessRules, rule)
+			}
+
+			vol.NFSMaxRead = c.Uint64("nfs-max-read")
+			vol.NFSMaxWrite = c.Uint64("nfs-max-write")
+
+			vol.NFSFSALName = c.String("nfs-fsal")
+			for _, fsalOption := range c.StringSlice("nfs-fsal-option") {
+				option, err := nfs.ParseFSALOption(fsalOption)
+				if err != nil {
+					logrus.Fatalf("Error starting share-manager invalid fsal option: %v", err)
+				}
+				vol.NFSFSALOptions = append(vol.NFSFSALOptions, option)
+			}
+
+			requiredClasses, err := crypto.ParseCharacterClasses(c.String("passphrase-required-classes"))
+			if err != nil {
+				logrus.Fatalf("Error starting share-manager invalid passphrase policy: %v", err)
+			}
+			vol.PassphrasePolicy = crypto.PassphrasePolicy{
+				MinLength:       c.Int("passphrase-min-length"),
+				RequiredClasses: requiredClasses,
+			}
+
+			if err := vol.Validate(); err != nil {
+				logrus.Fatalf("Error starting share-manager invalid settings for volume %v: %v", vol.Name, err)
+			}
+
+			options := rpc.ServerOptions{
+				MountTimeout:          c.Duration("mount-timeout"),
+				UnmountTimeout:        c.Duration("unmount-timeout"),
+				TrimTimeout:           c.Duration("trim-timeout"),
+				ResizeTimeout:         c.Duration("resize-timeout"),
+				DeviceWaitTimeout:     c.Duration("device-wait-timeout"),
+				ExportRetryTimeout:    c.Duration("export-retry-timeout"),
+				VerifyExport:          c.Bool("verify-export"),
+				MountWithoutNFSServer: c.Bool("mount-without-nfs-server"),
+				CleanupStaleMounts:    c.Bool("cleanup-stale-mounts"),
+				// the nfs server only listens on the bind address if one is set
+				VerifyExportAddress:     c.String("nfs-bind-address"),
+				TrimInterval:            c.Duration("trim-interval"),
+				GaneshaStatsInterval:    c.Duration("ganesha-stats-interval"),
+				StatusAddress:           c.String("status-address"),
+				ExportReconcileInterval: c.Duration("export-reconcile-interval"),
+				TrimMaxUtilization:      c.Float64("trim-max-utilization"),
+				InodeUsageThreshold:     c.Float64("inode-usage-threshold"),
+				TrimDeviceCheck:         c.String("trim-device-check"),
+			}
+
+			if err := rpc.ValidateTrimDeviceCheck(options.TrimDeviceCheck); err != nil {
+				logrus.Fatalf("Error starting share-manager: %v", err)
+			}
+			if options.TrimDeviceCheck != "" && options.TrimDeviceCheck != rpc.TrimDeviceCheckStrict {
+				logrus.Warnf("The device check of the filesystem trim is %v, a trim may run on a filesystem that is not the volume", options.TrimDeviceCheck)
+			}
+
+			if threshold := options.InodeUsageThreshold; threshold < 0 || threshold > 100 {
+				logrus.Fatalf("Error starting share-manager invalid inode usage threshold %v, it must be between 0 and 100", threshold)
+			}
+			if utilization := options.TrimMaxUtilization; utilization < 0 || utilization > 100 {
+				logrus.Fatalf("Error starting share-manager invalid trim max utilization %v, it must be between 0 and 100", utilization)
+			}
+
+			if window := c.String("trim-window"); window != "" {
+				trimWindow, err := util.ParseTimeWindow(window, c.String("trim-window-timezone"))
+				if err != nil {
+					logrus.Fatalf("Error starting share-manager invalid trim window: %v", err)
+				}
+				options.TrimWindow = trimWindow
+			}
+
+			if command := c.String("post-mount-hook"); command != "" {
+				options.PostMountHook = &rpc.Hook{
+					Command: command,
+					Timeout: c.Duration("post-mount-hook-timeout"),
+					Fatal:   c.Bool("post-mount-hook-fatal"),
+				}
+				if err := options.PostMountHook.Validate(); err != nil {
+					logrus.Fatalf("Error starting share-manager invalid post mount hook: %v", err)
+				}
+			}
+
+			if command := c.String("pre-unmount-hook"); command != "" {
+				options.PreUnmountHook = &rpc.Hook{
+					Command: command,
+					Timeout: c.Duration("pre-unmount-hook-timeout"),
+					Fatal:   c.Bool("pre-unmount-hook-fatal"),
+				}
+				if err := options.PreUnmountHook.Validate(); err != nil {
+					logrus.Fatalf("Error starting share-manager invalid pre unmount hook: %v", err)
+				}
+			}
+
+			nfsOptions := nfs.ServerOptions{
+				LeaseLifetime:            c.Int("nfs-lease-lifetime"),
+				GracePeriod:              c.Int("nfs-grace-period"),
+				MaxConnections:           c.Int("nfs-max-connections"),
+				MaxRequests:              c.Int("nfs-max-requests"),
+				MaxRequestsPerConnection: c.Int("nfs-max-requests-per-connection"),
+				BindAddress:              c.String("nfs-bind-address"),
+				LogPath:                  c.String("nfs-log-path"),
+				PseudoRoot:               c.String("nfs-pseudo-root"),
+				MountPort:                c.Int("nfs-mount-port"),
+				NLMPort:                  c.Int("nfs-nlm-port"),
+				RquotaPort:               c.Int("nfs-rquota-port"),
+
+				RecoveryBackend: c.String("nfs-recovery-backend"),
+				DBusAddress:     c.String("nfs-dbus-address"),
+				ReloadTimeout:   c.Duration("nfs-reload-timeout"),
+				Delegations:     vol.NFSDelegations == nfs.DelegationsRead || vol.NFSDelegations == nfs.DelegationsWrite,
+			}
+			minorVersionFloor := c.Int("nfs-minor-version-floor")
+			nfsOptions.MinorVersionFloor = &minorVersionFloor
+			if pool := c.String("rados-pool"); pool != "" {
+				nfsOptions.Rados = &nfs.RadosOptions{
+					Pool:      pool,
+					Namespace: c.String("rados-namespace"),
+					UserID:    c.String("rados-userid"),
+					CephConf:  c.String("rados-ceph-conf"),
+					NodeID:    c.String("rados-nodeid"),
+				}
+			}
+			for _, component := range c.StringSlice("nfs-log-component") {
+				name, level, found := strings.Cut(component, "=")
+				if !found {
+					logrus.Fatalf("Error starting share-manager invalid log component %v", component)
+				}
+				if nfsOptions.LogComponents == nil {
+					nfsOptions.LogComponents = map[string]string{}
+				}
+				nfsOptions.LogComponents[strings.TrimSpace(name)] = strings.TrimSpace(level)
+			}
+			if keytab := c.String("krb5-keytab"); keytab != "" {
+				nfsOptions.KerberosKeytab = keytab
+				nfsOptions.KerberosPrincipal = c.String("krb5-principal")
+			}
+
+			grpcOptions := rpc.GRPCOptions{
+				KeepaliveTime:    c.Duration("grpc-keepalive-time"),
+				KeepaliveTimeout: c.Duration("grpc-keepalive-timeout"),
+				MaxRecvMsgSize:   c.Int("grpc-max-recv-msg-size"),
+				MaxSendMsgSize:   c.Int("grpc-max-send-msg-size"),
+			}
+			if err := grpcOptions.Validate(); err != nil {
+				logrus.Fatalf("Error starting share-manager invalid grpc options: %v", err)
+			}
+
+			options.GaneshaLogPath = nfsOptions.GetLogPath()
+
+			if err := start(vol, options, nfsOptions, grpcOptions); err != nil {
 				logrus.Fatalf("Error running start command: %v.", err)
 			}
 		},
 	}
 }
 
-func start(vol volume.Volume) error {
+func start(vol volume.Volume, options rpc.ServerOptions, nfsOptions nfs.ServerOptions, grpcOptions rpc.GRPCOptions) error {
 	logger := util.NewLogger()
-	manager, err := server.NewShareManager(logger, vol)
+	manager, err := server.NewShareManager(logger, vol, nfsOptions)
 	if err != nil {
 		return err
 	}
 
+	srv := rpc.NewShareManagerServer(manager, options)
+	if err := srv.Init(); err != nil {
+		return err
+	}
+
 	shutdownCh := make(chan error)
 	defer close(shutdownCh)
 	go func() {
@@ -126,10 +779,14 @@ func start(vol volume.Volume) error {
 			return
 		}
 
-		s := grpc.NewServer()
-		srv := rpc.NewShareManagerServer(manager)
+		s := grpc.NewServer(grpcOptions.ServerOptions()...)
 		smrpc.RegisterShareManagerServiceServer(s, srv)
+		rpc.RegisterShareManagerAPIServer(s, srv)
 		healthpb.RegisterHealthServer(s, rpc.NewShareManagerHealthCheckServer(srv))
+		go srv.RunTrimScheduler(manager.Context())
+		go srv.RunGaneshaStatsSampler(manager.Context())
+		go srv.RunStatusServer(manager.Context())
+		go srv.RunExportSelfHeal(manager.Context())
 		reflection.Register(s)
 
 		logrus.Infof("Listening on share manager gRPC server %s", listenPort)
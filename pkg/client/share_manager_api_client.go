@@ -0,0 +1,288 @@
+package client
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/rpc"
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+// invokeAPI calls a unary method of the ShareManagerAPIService, which serves the share manager
+// operations that are not part of the smrpc ShareManagerService
+func (c *ShareManagerClient) invokeAPI(method string, req, resp interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), types.GRPCServiceTimeout)
+	defer cancel()
+
+	return rpc.InvokeShareManagerAPI(ctx, c.conn, method, req, resp)
+}
+
+// WatchStatus calls the handler with every status update of the volume until the stream ends,
+// the context is done or the handler returns an error
+func (c *ShareManagerClient) WatchStatus(ctx context.Context, handler func(*rpc.StatusUpdate) error) error {
+	stream, err := rpc.WatchShareManagerStatus(ctx, c.conn)
+	if err != nil {
+		return err
+	}
+
+	for {
+		update := &rpc.StatusUpdate{}
+		if err := stream.RecvMsg(update); err != nil {
+			return err
+		}
+		if err := handler(update); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *ShareManagerClient) GetStatus() (*rpc.Status, error) {
+	resp := &rpc.Status{}
+	if err := c.invokeAPI("GetStatus", &emptypb.Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) FilesystemResize() error {
+	return c.invokeAPI("FilesystemResize", &emptypb.Empty{}, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) ListExports() ([]nfs.Export, error) {
+	var resp []nfs.Export
+	if err := c.invokeAPI("ListExports", &emptypb.Empty{}, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) RefreshSize() (bool, error) {
+	var resp bool
+	if err := c.invokeAPI("RefreshSize", &emptypb.Empty{}, &resp); err != nil {
+		return false, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) CreateReadOnlyBindMount(req *rpc.BindMountRequest) error {
+	return c.invokeAPI("CreateReadOnlyBindMount", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) RemoveReadOnlyBindMount(req *rpc.BindMountRequest) error {
+	return c.invokeAPI("RemoveReadOnlyBindMount", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) TrimAll() ([]rpc.TrimResult, error) {
+	var resp []rpc.TrimResult
+	if err := c.invokeAPI("TrimAll", &emptypb.Empty{}, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) GetMaxConnections() (*rpc.MaxConnections, error) {
+	resp := &rpc.MaxConnections{}
+	if err := c.invokeAPI("GetMaxConnections", &emptypb.Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) SetMaxConnections(req *rpc.SetMaxConnectionsRequest) (*rpc.MaxConnections, error) {
+	resp := &rpc.MaxConnections{}
+	if err := c.invokeAPI("SetMaxConnections", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) SetMaintenanceMode(req *rpc.SetMaintenanceModeRequest) error {
+	return c.invokeAPI("SetMaintenanceMode", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) GetIOStats() (*rpc.IOStats, error) {
+	resp := &rpc.IOStats{}
+	if err := c.invokeAPI("GetIOStats", &emptypb.Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) PrepareMigration(req *rpc.MigrationRequest) error {
+	return c.invokeAPI("PrepareMigration", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) CompleteMigration(req *rpc.MigrationRequest) error {
+	return c.invokeAPI("CompleteMigration", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) ListBlockedClients() ([]string, error) {
+	var resp []string
+	if err := c.invokeAPI("ListBlockedClients", &emptypb.Empty{}, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) BlockClient(req *rpc.ClientRequest) error {
+	return c.invokeAPI("BlockClient", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) UnblockClient(req *rpc.ClientRequest) error {
+	return c.invokeAPI("UnblockClient", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) Provision(req *rpc.ProvisionRequest) error {
+	return c.invokeAPI("Provision", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) GetRecentErrors(req *rpc.GetRecentErrorsRequest) ([]rpc.OperationError, error) {
+	var resp []rpc.OperationError
+	if err := c.invokeAPI("GetRecentErrors", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) GetDeviceInfo() (*rpc.DeviceInfo, error) {
+	resp := &rpc.DeviceInfo{}
+	if err := c.invokeAPI("GetDeviceInfo", &emptypb.Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) ShrinkFilesystem(req *rpc.ShrinkRequest) error {
+	return c.invokeAPI("ShrinkFilesystem", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) VerifyExport() error {
+	return c.invokeAPI("VerifyExport", &emptypb.Empty{}, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) FilesystemTrimRange(req *rpc.TrimRangeRequest) (uint64, error) {
+	var resp uint64
+	if err := c.invokeAPI("FilesystemTrimRange", req, &resp); err != nil {
+		return 0, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) ReconcileExport(req *rpc.DesiredExportState) (*rpc.ReconcileExportResponse, error) {
+	resp := &rpc.ReconcileExportResponse{}
+	if err := c.invokeAPI("ReconcileExport", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) DumpGaneshaState() (*rpc.GaneshaStateDump, error) {
+	resp := &rpc.GaneshaStateDump{}
+	if err := c.invokeAPI("DumpGaneshaState", &emptypb.Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) GetFilesystemStats() (*volume.FilesystemStats, error) {
+	resp := &volume.FilesystemStats{}
+	if err := c.invokeAPI("GetFilesystemStats", &emptypb.Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) GetGaneshaLogs(req *rpc.GetGaneshaLogsRequest) (*rpc.GetGaneshaLogsResponse, error) {
+	resp := &rpc.GetGaneshaLogsResponse{}
+	if err := c.invokeAPI("GetGaneshaLogs", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) FilesystemResizeWithOptions(req *rpc.FilesystemResizeRequest) error {
+	return c.invokeAPI("FilesystemResizeWithOptions", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) GetCapabilities() (*rpc.Capabilities, error) {
+	resp := &rpc.Capabilities{}
+	if err := c.invokeAPI("GetCapabilities", &emptypb.Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) SetScheduledTrimPaused(req *rpc.SetScheduledTrimPausedRequest) error {
+	return c.invokeAPI("SetScheduledTrimPaused", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) GetGaneshaProcessStats() (*rpc.GaneshaProcessStats, error) {
+	resp := &rpc.GaneshaProcessStats{}
+	if err := c.invokeAPI("GetGaneshaProcessStats", &emptypb.Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) Wipe(req *rpc.WipeRequest) error {
+	return c.invokeAPI("Wipe", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) GetMountOptions() (*rpc.MountOptions, error) {
+	resp := &rpc.MountOptions{}
+	if err := c.invokeAPI("GetMountOptions", &emptypb.Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) ExportSnapshot(req *rpc.ExportSnapshotRequest) (*rpc.SnapshotExport, error) {
+	resp := &rpc.SnapshotExport{}
+	if err := c.invokeAPI("ExportSnapshot", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) UnexportSnapshot(req *rpc.UnexportSnapshotRequest) error {
+	return c.invokeAPI("UnexportSnapshot", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) ListSnapshotExports() ([]rpc.SnapshotExport, error) {
+	var resp []rpc.SnapshotExport
+	if err := c.invokeAPI("ListSnapshotExports", &emptypb.Empty{}, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) DisconnectClient(req *rpc.DisconnectClientRequest) error {
+	return c.invokeAPI("DisconnectClient", req, &emptypb.Empty{})
+}
+
+func (c *ShareManagerClient) PreviewExport(req *rpc.DesiredExportState) (*rpc.PreviewExportResponse, error) {
+	resp := &rpc.PreviewExportResponse{}
+	if err := c.invokeAPI("PreviewExport", req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) GetPassphraseFingerprint() (*rpc.PassphraseFingerprint, error) {
+	resp := &rpc.PassphraseFingerprint{}
+	if err := c.invokeAPI("GetPassphraseFingerprint", &emptypb.Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *ShareManagerClient) GetExportStatistics() (*rpc.ExportStatistics, error) {
+	resp := &rpc.ExportStatistics{}
+	if err := c.invokeAPI("GetExportStatistics", &emptypb.Empty{}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
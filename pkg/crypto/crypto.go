@@ -2,7 +2,11 @@ package crypto
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -11,10 +15,22 @@ import (
 	lhtypes "github.com/longhorn/go-common-libs/types"
 
 	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/util"
 )
 
+const sectorSize = 512
+
+// luksTimeout returns the timeout of a cryptsetup call, zero uses the default
+func luksTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return lhtypes.LuksTimeout
+	}
+	return timeout
+}
+
 // EncryptVolume encrypts provided device with LUKS.
-func EncryptVolume(devicePath, passphrase, keyCipher, keyHash, keySize, pbkdf string) error {
+// A non empty header path stores the LUKS header in that file instead of on the device.
+func EncryptVolume(devicePath, passphrase, keyCipher, keyHash, keySize, pbkdf, headerPath string, timeout time.Duration) error {
 	namespaces := []lhtypes.Namespace{lhtypes.NamespaceMnt, lhtypes.NamespaceIpc}
 	nsexec, err := lhns.NewNamespaceExecutor(lhtypes.ProcessNone, lhtypes.HostProcDirectory, namespaces)
 	if err != nil {
@@ -22,14 +38,66 @@ func EncryptVolume(devicePath, passphrase, keyCipher, keyHash, keySize, pbkdf st
 	}
 
 	logrus.Debugf("Encrypting device %s with LUKS", devicePath)
-	if _, err := nsexec.LuksFormat(devicePath, passphrase, keyCipher, keyHash, keySize, pbkdf, lhtypes.LuksTimeout); err != nil {
+	if headerPath == "" {
+		_, err = nsexec.LuksFormat(devicePath, passphrase, keyCipher, keyHash, keySize, pbkdf, luksTimeout(timeout))
+	} else {
+		args := []string{
+			"-q", "luksFormat",
+			"--type", "luks2",
+			"--header", headerPath,
+			"--cipher", keyCipher,
+			"--hash", keyHash,
+			"--key-size", keySize,
+			"--pbkdf", pbkdf,
+			devicePath, "-d", "/dev/stdin",
+		}
+		_, err = nsexec.CryptsetupWithPassphrase(passphrase, args, luksTimeout(timeout))
+	}
+	if err != nil {
 		return errors.Wrapf(err, "failed to encrypt device %s with LUKS", devicePath)
 	}
 	return nil
 }
 
+// CheckHeaderFile verifies that the detached LUKS header file exists and is readable. The path is
+// resolved in the host mount namespace, where cryptsetup runs.
+func CheckHeaderFile(headerPath string) error {
+	hostPath := filepath.Join(lhtypes.HostProcDirectory, "1", "root", headerPath)
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return errors.Wrapf(err, "LUKS header %s is not readable", headerPath)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "LUKS header %s is not readable", headerPath)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("LUKS header %s is not a regular file", headerPath)
+	}
+	return nil
+}
+
+// IsLuksHeader returns true if the file or device holds a LUKS header
+func IsLuksHeader(path string) (bool, error) {
+	namespaces := []lhtypes.Namespace{lhtypes.NamespaceMnt, lhtypes.NamespaceIpc}
+	nsexec, err := lhns.NewNamespaceExecutor(lhtypes.ProcessNone, lhtypes.HostProcDirectory, namespaces)
+	if err != nil {
+		return false, err
+	}
+
+	// cryptsetup isLuks only reports through its exit code
+	if _, err := nsexec.Cryptsetup([]string{"isLuks", path}, lhtypes.LuksTimeout); err != nil {
+		logrus.WithError(err).Debugf("%s has no LUKS header", path)
+		return false, nil
+	}
+	return true, nil
+}
+
 // OpenVolume opens volume so that it can be used by the client.
-func OpenVolume(volume, devicePath, passphrase string) error {
+// A non empty header path reads the LUKS header from that file instead of the device.
+func OpenVolume(volume, devicePath, passphrase, headerPath string, timeout time.Duration) error {
 	devPath := types.GetVolumeDevicePath(volume, true)
 	if isOpen, _ := IsDeviceOpen(devPath); isOpen {
 		logrus.Debugf("Device %s is already opened at %s", devicePath, devPath)
@@ -43,7 +111,12 @@ func OpenVolume(volume, devicePath, passphrase string) error {
 	}
 
 	logrus.Debugf("Opening device %s with LUKS on %s", devicePath, volume)
-	_, err = nsexec.LuksOpen(volume, devicePath, passphrase, lhtypes.LuksTimeout)
+	if headerPath == "" {
+		_, err = nsexec.LuksOpen(volume, devicePath, passphrase, luksTimeout(timeout))
+	} else {
+		args := []string{"luksOpen", "--header", headerPath, devicePath, volume, "-d", "/dev/stdin"}
+		_, err = nsexec.CryptsetupWithPassphrase(passphrase, args, luksTimeout(timeout))
+	}
 	if err != nil {
 		logrus.WithError(err).Warnf("Failed to open LUKS device %s", devicePath)
 	}
@@ -51,7 +124,7 @@ func OpenVolume(volume, devicePath, passphrase string) error {
 }
 
 // CloseVolume closes encrypted volume so it can be detached.
-func CloseVolume(volume string) error {
+func CloseVolume(volume string, timeout time.Duration) error {
 	namespaces := []lhtypes.Namespace{lhtypes.NamespaceMnt, lhtypes.NamespaceIpc}
 	nsexec, err := lhns.NewNamespaceExecutor(lhtypes.ProcessNone, lhtypes.HostProcDirectory, namespaces)
 	if err != nil {
@@ -59,10 +132,115 @@ func CloseVolume(volume string) error {
 	}
 
 	logrus.Debugf("Closing LUKS device %s", volume)
-	_, err = nsexec.LuksClose(volume, lhtypes.LuksTimeout)
+	_, err = nsexec.LuksClose(volume, luksTimeout(timeout))
 	return err
 }
 
+// ResizeEncryptoDevice grows the crypto device to fill the underlying device.
+// It is a no-op if the crypto device already spans the whole underlying device,
+// so it is safe to re-run after an interrupted resize.
+func ResizeEncryptoDevice(volume, passphrase, headerPath string, timeout time.Duration) error {
+	devPath := types.GetVolumeDevicePath(volume, true)
+	if isOpen, err := IsDeviceOpen(devPath); err != nil {
+		return err
+	} else if !isOpen {
+		return fmt.Errorf("crypto device %s is not open", devPath)
+	}
+
+	needsResize, err := cryptoDeviceNeedsResize(volume)
+	if err != nil {
+		return err
+	}
+	if !needsResize {
+		logrus.Debugf("Crypto device %s already spans the underlying device", devPath)
+		return nil
+	}
+
+	namespaces := []lhtypes.Namespace{lhtypes.NamespaceMnt, lhtypes.NamespaceIpc}
+	nsexec, err := lhns.NewNamespaceExecutor(lhtypes.ProcessNone, lhtypes.HostProcDirectory, namespaces)
+	if err != nil {
+		return err
+	}
+
+	logrus.Debugf("Resizing LUKS device %s", devPath)
+	if headerPath == "" {
+		_, err = nsexec.LuksResize(volume, passphrase, luksTimeout(timeout))
+	} else {
+		_, err = nsexec.CryptsetupWithPassphrase(passphrase, []string{"resize", "--header", headerPath, volume}, luksTimeout(timeout))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to resize LUKS device %s", devPath)
+	}
+	return nil
+}
+
+// cryptoDeviceNeedsResize compares the mapped size plus the LUKS header offset
+// against the size of the underlying device.
+func cryptoDeviceNeedsResize(volume string) (bool, error) {
+	status, err := GetDeviceStatus(volume)
+	if err != nil {
+		return false, err
+	}
+	return statusNeedsResize(volume, status)
+}
+
+// statusNeedsResize reports whether the mapping of the cryptsetup status ends before the
+// underlying device does
+func statusNeedsResize(volume string, status map[string]string) (bool, error) {
+	offset, err := parseSectors(status["offset"])
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to parse offset of LUKS device %s", volume)
+	}
+	size, err := parseSectors(status["size"])
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to parse size of LUKS device %s", volume)
+	}
+
+	deviceSize, err := util.GetDeviceSize(status["device"])
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get size of device %s", status["device"])
+	}
+
+	return (offset+size)*sectorSize < deviceSize, nil
+}
+
+// GetDeviceStatus returns the key value pairs reported by cryptsetup status for an active LUKS device.
+func GetDeviceStatus(volume string) (map[string]string, error) {
+	namespaces := []lhtypes.Namespace{lhtypes.NamespaceMnt, lhtypes.NamespaceIpc}
+	nsexec, err := lhns.NewNamespaceExecutor(lhtypes.ProcessNone, lhtypes.HostProcDirectory, namespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := nsexec.LuksStatus(volume, lhtypes.LuksTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get status of LUKS device %s", volume)
+	}
+	return parseDeviceStatus(volume, stdout)
+}
+
+// parseDeviceStatus returns the key value pairs of the cryptsetup status output
+func parseDeviceStatus(volume, stdout string) (map[string]string, error) {
+	lines := strings.Split(stdout, "\n")
+	if !strings.Contains(lines[0], " is active") {
+		return nil, fmt.Errorf("LUKS device %s is not active", volume)
+	}
+
+	status := map[string]string{}
+	for _, line := range lines[1:] {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		status[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return status, nil
+}
+
+func parseSectors(value string) (int64, error) {
+	return strconv.ParseInt(strings.TrimSuffix(value, " sectors"), 10, 64)
+}
+
 // IsDeviceOpen determines if encrypted device is already open.
 func IsDeviceOpen(device string) (bool, error) {
 	_, mappedFile, err := DeviceEncryptionStatus(device)
@@ -110,3 +288,22 @@ func DeviceEncryptionStatus(devicePath string) (mappedDevice, mapper string, err
 	// Identified as LUKS, but failed to identify a mapped device
 	return "", "", fmt.Errorf("mapped device not found in path %s", devicePath)
 }
+
+// EraseHeader wipes all key slots of the LUKS header on the device, or in the header file if a
+// header path is set. The data on the device cannot be decrypted afterwards, even with the passphrase.
+func EraseHeader(devicePath, headerPath string, timeout time.Duration) error {
+	namespaces := []lhtypes.Namespace{lhtypes.NamespaceMnt, lhtypes.NamespaceIpc}
+	nsexec, err := lhns.NewNamespaceExecutor(lhtypes.ProcessNone, lhtypes.HostProcDirectory, namespaces)
+	if err != nil {
+		return err
+	}
+
+	path := devicePath
+	if headerPath != "" {
+		path = headerPath
+	}
+
+	logrus.Infof("Erasing LUKS header on %s", path)
+	_, err = nsexec.Cryptsetup([]string{"erase", "-q", path}, luksTimeout(timeout))
+	return err
+}
@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestParseDeviceStatus(t *testing.T) {
+	status, err := parseDeviceStatus("pvc-1", "/dev/mapper/pvc-1 is active and is in use.\n  type:    LUKS2\n  key location: keyring\n  offset:  32768 sectors\n")
+	if err != nil {
+		t.Fatalf("failed to parse status: %v", err)
+	}
+	for key, expected := range map[string]string{"type": "LUKS2", "key location": "keyring", "offset": "32768 sectors"} {
+		if status[key] != expected {
+			t.Errorf("expected %v %q, got %q", key, expected, status[key])
+		}
+	}
+
+	if _, err := parseDeviceStatus("pvc-1", "/dev/mapper/pvc-1 is inactive.\n"); err == nil {
+		t.Fatal("expected an error for an inactive device")
+	}
+}
+
+func TestStatusNeedsResize(t *testing.T) {
+	const deviceSectors = 4 * 1024 * 1024
+
+	// a regular file stands in for the underlying device
+	devicePath := filepath.Join(t.TempDir(), "device")
+	if err := os.WriteFile(devicePath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(devicePath, deviceSectors*sectorSize); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		size        string
+		needsResize bool
+		invalid     bool
+	}{
+		{name: "spans the device", size: strconv.Itoa(deviceSectors - 32768)},
+		{name: "interrupted resize", size: strconv.Itoa(deviceSectors/2 - 32768), needsResize: true},
+		{name: "invalid size", size: "unknown", invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := map[string]string{"device": devicePath, "offset": "32768 sectors", "size": tt.size + " sectors"}
+			needsResize, err := statusNeedsResize("pvc-1", status)
+			if invalid := err != nil; invalid != tt.invalid {
+				t.Fatalf("expected invalid %v, got %v", tt.invalid, err)
+			}
+			if needsResize != tt.needsResize {
+				t.Fatalf("expected needs resize %v, got %v", tt.needsResize, needsResize)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	CharacterClassLower  = "lower"
+	CharacterClassUpper  = "upper"
+	CharacterClassDigit  = "digit"
+	CharacterClassSymbol = "symbol"
+)
+
+var characterClasses = []string{CharacterClassLower, CharacterClassUpper, CharacterClassDigit, CharacterClassSymbol}
+
+// ErrWeakPassphrase is returned when a passphrase does not satisfy the passphrase policy
+var ErrWeakPassphrase = errors.New("passphrase does not satisfy the passphrase policy")
+
+// PassphrasePolicy is the strength a passphrase must have before a device is encrypted with it.
+// The zero value enforces nothing.
+type PassphrasePolicy struct {
+	// MinLength is the minimum number of characters
+	MinLength int
+	// RequiredClasses are the character classes (lower, upper, digit, symbol) the passphrase must contain
+	RequiredClasses []string
+}
+
+// ParseCharacterClasses parses a comma separated list of character classes
+func ParseCharacterClasses(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	classes := []string{}
+	for _, class := range strings.Split(value, ",") {
+		class = strings.TrimSpace(class)
+		if !slices.Contains(characterClasses, class) {
+			return nil, fmt.Errorf("invalid character class %v, must be one of %v", class, characterClasses)
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+// Validate checks that the policy itself is usable
+func (p PassphrasePolicy) Validate() error {
+	if p.MinLength < 0 {
+		return fmt.Errorf("invalid passphrase minimum length %v", p.MinLength)
+	}
+	for _, class := range p.RequiredClasses {
+		if !slices.Contains(characterClasses, class) {
+			return fmt.Errorf("invalid character class %v, must be one of %v", class, characterClasses)
+		}
+	}
+	return nil
+}
+
+// Check returns ErrWeakPassphrase if the passphrase is too short or misses a required character class.
+// The error does not contain the passphrase.
+func (p PassphrasePolicy) Check(passphrase string) error {
+	if length := len([]rune(passphrase)); length < p.MinLength {
+		return errors.Wrapf(ErrWeakPassphrase, "passphrase has %v characters, at least %v are required", length, p.MinLength)
+	}
+
+	for _, class := range p.RequiredClasses {
+		if !strings.ContainsFunc(passphrase, characterClassFunc(class)) {
+			return errors.Wrapf(ErrWeakPassphrase, "passphrase contains no %v character", class)
+		}
+	}
+	return nil
+}
+
+func characterClassFunc(class string) func(rune) bool {
+	switch class {
+	case CharacterClassLower:
+		return unicode.IsLower
+	case CharacterClassUpper:
+		return unicode.IsUpper
+	case CharacterClassDigit:
+		return unicode.IsDigit
+	}
+	return func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r)
+	}
+}
+
+const (
+	// PassphraseFingerprintScheme identifies how PassphraseFingerprint derives the fingerprint
+	PassphraseFingerprintScheme = "pbkdf2-sha256"
+	// passphraseFingerprintIterations slows down guessing a weak passphrase from its fingerprint
+	passphraseFingerprintIterations = 100000
+	passphraseFingerprintSaltPrefix = "longhorn-share-manager:"
+)
+
+// PassphraseFingerprint returns a non reversible fingerprint of the passphrase of a volume, so the
+// passphrase in use can be compared without transmitting it. The fingerprint is the hex encoded
+// PBKDF2-HMAC-SHA256 key of 32 bytes, derived with 100000 iterations and the salt
+// "longhorn-share-manager:<volume>", which keeps it stable across restarts while differing
+// between volumes sharing a passphrase.
+func PassphraseFingerprint(passphrase, volume string) string {
+	mac := hmac.New(sha256.New, []byte(passphrase))
+
+	// a single PBKDF2 block, since the key is as long as the digest
+	mac.Write([]byte(passphraseFingerprintSaltPrefix + volume))
+	mac.Write(binary.BigEndian.AppendUint32(nil, 1))
+	u := mac.Sum(nil)
+	key := slices.Clone(u)
+	for i := 1; i < passphraseFingerprintIterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return hex.EncodeToString(key)
+}
@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseCharacterClasses(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []string
+		invalid  bool
+	}{
+		{value: "", expected: nil},
+		{value: "lower", expected: []string{CharacterClassLower}},
+		{value: "upper, digit,symbol", expected: []string{CharacterClassUpper, CharacterClassDigit, CharacterClassSymbol}},
+		{value: "lower,space", invalid: true},
+	}
+
+	for _, tt := range tests {
+		classes, err := ParseCharacterClasses(tt.value)
+		if invalid := err != nil; invalid != tt.invalid {
+			t.Errorf("%q: expected invalid %v, got %v", tt.value, tt.invalid, err)
+			continue
+		}
+		if !tt.invalid && !reflect.DeepEqual(classes, tt.expected) {
+			t.Errorf("%q: expected %v, got %v", tt.value, tt.expected, classes)
+		}
+	}
+}
+
+func TestPassphrasePolicyCheck(t *testing.T) {
+	policy := PassphrasePolicy{
+		MinLength:       12,
+		RequiredClasses: []string{CharacterClassLower, CharacterClassUpper, CharacterClassDigit, CharacterClassSymbol},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("expected a valid policy, got %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		weak       bool
+	}{
+		{name: "strong", passphrase: "Correct-Horse-7"},
+		{name: "multibyte characters count once", passphrase: "Äöü-Ab1xyz", weak: true},
+		{name: "too short", passphrase: "Short-1a", weak: true},
+		{name: "no upper case", passphrase: "correct-horse-7", weak: true},
+		{name: "no digit", passphrase: "Correct-Horse-Battery", weak: true},
+		{name: "no symbol", passphrase: "CorrectHorse7Battery", weak: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.passphrase)
+			if weak := errors.Is(err, ErrWeakPassphrase); weak != tt.weak {
+				t.Fatalf("expected weak %v, got %v", tt.weak, err)
+			}
+			if err != nil && strings.Contains(err.Error(), tt.passphrase) {
+				t.Fatalf("error %q contains the passphrase", err)
+			}
+		})
+	}
+
+	if err := (PassphrasePolicy{}).Check(""); err != nil {
+		t.Fatalf("expected the zero policy to accept any passphrase, got %v", err)
+	}
+	if err := (PassphrasePolicy{MinLength: -1}).Validate(); err == nil {
+		t.Fatal("expected a negative minimum length to be invalid")
+	}
+}
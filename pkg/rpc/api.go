@@ -0,0 +1,204 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+const (
+	// ShareManagerAPIServiceName is the gRPC service of the share manager operations that are not
+	// part of the smrpc ShareManagerService
+	ShareManagerAPIServiceName = "longhorn.sharemanager.ShareManagerAPIService"
+
+	// JSONCodecName is the content subtype of the ShareManagerAPIService messages, which are the
+	// JSON encoded request and response types of this package
+	JSONCodecName = "json"
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec encodes the messages of the ShareManagerAPIService as JSON, clients select it with
+// grpc.CallContentSubtype(JSONCodecName)
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	// an empty message is sent for a request without fields
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return JSONCodecName
+}
+
+// ShareManagerAPIServer is the server of the ShareManagerAPIService, as implemented by the ShareManagerServer
+type ShareManagerAPIServer interface {
+	GetStatus(context.Context, *emptypb.Empty) (*Status, error)
+	FilesystemResize(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	ListExports(context.Context, *emptypb.Empty) ([]nfs.Export, error)
+	RefreshSize(context.Context, *emptypb.Empty) (bool, error)
+	CreateReadOnlyBindMount(context.Context, *BindMountRequest) (*emptypb.Empty, error)
+	RemoveReadOnlyBindMount(context.Context, *BindMountRequest) (*emptypb.Empty, error)
+	TrimAll(context.Context, *emptypb.Empty) ([]TrimResult, error)
+	GetMaxConnections(context.Context, *emptypb.Empty) (*MaxConnections, error)
+	SetMaxConnections(context.Context, *SetMaxConnectionsRequest) (*MaxConnections, error)
+	SetMaintenanceMode(context.Context, *SetMaintenanceModeRequest) (*emptypb.Empty, error)
+	GetIOStats(context.Context, *emptypb.Empty) (*IOStats, error)
+	PrepareMigration(context.Context, *MigrationRequest) (*emptypb.Empty, error)
+	CompleteMigration(context.Context, *MigrationRequest) (*emptypb.Empty, error)
+	ListBlockedClients(context.Context, *emptypb.Empty) ([]string, error)
+	BlockClient(context.Context, *ClientRequest) (*emptypb.Empty, error)
+	UnblockClient(context.Context, *ClientRequest) (*emptypb.Empty, error)
+	Provision(context.Context, *ProvisionRequest) (*emptypb.Empty, error)
+	GetRecentErrors(context.Context, *GetRecentErrorsRequest) ([]OperationError, error)
+	GetDeviceInfo(context.Context, *emptypb.Empty) (*DeviceInfo, error)
+	ShrinkFilesystem(context.Context, *ShrinkRequest) (*emptypb.Empty, error)
+	VerifyExport(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	WatchStatus(*emptypb.Empty, StatusStream) error
+	FilesystemTrimRange(context.Context, *TrimRangeRequest) (uint64, error)
+	ReconcileExport(context.Context, *DesiredExportState) (*ReconcileExportResponse, error)
+	DumpGaneshaState(context.Context, *emptypb.Empty) (*GaneshaStateDump, error)
+	GetFilesystemStats(context.Context, *emptypb.Empty) (*volume.FilesystemStats, error)
+	GetGaneshaLogs(context.Context, *GetGaneshaLogsRequest) (*GetGaneshaLogsResponse, error)
+	FilesystemResizeWithOptions(context.Context, *FilesystemResizeRequest) (*emptypb.Empty, error)
+	GetCapabilities(context.Context, *emptypb.Empty) (*Capabilities, error)
+	SetScheduledTrimPaused(context.Context, *SetScheduledTrimPausedRequest) (*emptypb.Empty, error)
+	GetGaneshaProcessStats(context.Context, *emptypb.Empty) (*GaneshaProcessStats, error)
+	Wipe(context.Context, *WipeRequest) (*emptypb.Empty, error)
+	GetMountOptions(context.Context, *emptypb.Empty) (*MountOptions, error)
+	ExportSnapshot(context.Context, *ExportSnapshotRequest) (*SnapshotExport, error)
+	UnexportSnapshot(context.Context, *UnexportSnapshotRequest) (*emptypb.Empty, error)
+	ListSnapshotExports(context.Context, *emptypb.Empty) ([]SnapshotExport, error)
+	DisconnectClient(context.Context, *DisconnectClientRequest) (*emptypb.Empty, error)
+	PreviewExport(context.Context, *DesiredExportState) (*PreviewExportResponse, error)
+	GetPassphraseFingerprint(context.Context, *emptypb.Empty) (*PassphraseFingerprint, error)
+	GetExportStatistics(context.Context, *emptypb.Empty) (*ExportStatistics, error)
+}
+
+var shareManagerAPIMethods = []grpc.MethodDesc{
+	unaryMethod("GetStatus", ShareManagerAPIServer.GetStatus),
+	unaryMethod("FilesystemResize", ShareManagerAPIServer.FilesystemResize),
+	unaryMethod("ListExports", ShareManagerAPIServer.ListExports),
+	unaryMethod("RefreshSize", ShareManagerAPIServer.RefreshSize),
+	unaryMethod("CreateReadOnlyBindMount", ShareManagerAPIServer.CreateReadOnlyBindMount),
+	unaryMethod("RemoveReadOnlyBindMount", ShareManagerAPIServer.RemoveReadOnlyBindMount),
+	unaryMethod("TrimAll", ShareManagerAPIServer.TrimAll),
+	unaryMethod("GetMaxConnections", ShareManagerAPIServer.GetMaxConnections),
+	unaryMethod("SetMaxConnections", ShareManagerAPIServer.SetMaxConnections),
+	unaryMethod("SetMaintenanceMode", ShareManagerAPIServer.SetMaintenanceMode),
+	unaryMethod("GetIOStats", ShareManagerAPIServer.GetIOStats),
+	unaryMethod("PrepareMigration", ShareManagerAPIServer.PrepareMigration),
+	unaryMethod("CompleteMigration", ShareManagerAPIServer.CompleteMigration),
+	unaryMethod("ListBlockedClients", ShareManagerAPIServer.ListBlockedClients),
+	unaryMethod("BlockClient", ShareManagerAPIServer.BlockClient),
+	unaryMethod("UnblockClient", ShareManagerAPIServer.UnblockClient),
+	unaryMethod("Provision", ShareManagerAPIServer.Provision),
+	unaryMethod("GetRecentErrors", ShareManagerAPIServer.GetRecentErrors),
+	unaryMethod("GetDeviceInfo", ShareManagerAPIServer.GetDeviceInfo),
+	unaryMethod("ShrinkFilesystem", ShareManagerAPIServer.ShrinkFilesystem),
+	unaryMethod("VerifyExport", ShareManagerAPIServer.VerifyExport),
+	unaryMethod("FilesystemTrimRange", ShareManagerAPIServer.FilesystemTrimRange),
+	unaryMethod("ReconcileExport", ShareManagerAPIServer.ReconcileExport),
+	unaryMethod("DumpGaneshaState", ShareManagerAPIServer.DumpGaneshaState),
+	unaryMethod("GetFilesystemStats", ShareManagerAPIServer.GetFilesystemStats),
+	unaryMethod("GetGaneshaLogs", ShareManagerAPIServer.GetGaneshaLogs),
+	unaryMethod("FilesystemResizeWithOptions", ShareManagerAPIServer.FilesystemResizeWithOptions),
+	unaryMethod("GetCapabilities", ShareManagerAPIServer.GetCapabilities),
+	unaryMethod("SetScheduledTrimPaused", ShareManagerAPIServer.SetScheduledTrimPaused),
+	unaryMethod("GetGaneshaProcessStats", ShareManagerAPIServer.GetGaneshaProcessStats),
+	unaryMethod("Wipe", ShareManagerAPIServer.Wipe),
+	unaryMethod("GetMountOptions", ShareManagerAPIServer.GetMountOptions),
+	unaryMethod("ExportSnapshot", ShareManagerAPIServer.ExportSnapshot),
+	unaryMethod("UnexportSnapshot", ShareManagerAPIServer.UnexportSnapshot),
+	unaryMethod("ListSnapshotExports", ShareManagerAPIServer.ListSnapshotExports),
+	unaryMethod("DisconnectClient", ShareManagerAPIServer.DisconnectClient),
+	unaryMethod("PreviewExport", ShareManagerAPIServer.PreviewExport),
+	unaryMethod("GetPassphraseFingerprint", ShareManagerAPIServer.GetPassphraseFingerprint),
+	unaryMethod("GetExportStatistics", ShareManagerAPIServer.GetExportStatistics),
+}
+
+var shareManagerAPIStreams = []grpc.StreamDesc{
+	{
+		StreamName:    "WatchStatus",
+		Handler:       watchStatusHandler,
+		ServerStreams: true,
+	},
+}
+
+// RegisterShareManagerAPIServer registers the ShareManagerAPIService on the gRPC server
+func RegisterShareManagerAPIServer(s grpc.ServiceRegistrar, srv ShareManagerAPIServer) {
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: ShareManagerAPIServiceName,
+		HandlerType: (*ShareManagerAPIServer)(nil),
+		Methods:     shareManagerAPIMethods,
+		Streams:     shareManagerAPIStreams,
+		Metadata:    "pkg/rpc/api.go",
+	}, srv)
+}
+
+// InvokeShareManagerAPI calls a unary method of the ShareManagerAPIService on the connection
+func InvokeShareManagerAPI(ctx context.Context, conn grpc.ClientConnInterface, method string, req, resp interface{}, opts ...grpc.CallOption) error {
+	opts = append(opts, grpc.CallContentSubtype(JSONCodecName))
+	return conn.Invoke(ctx, apiMethodName(method), req, resp, opts...)
+}
+
+// WatchShareManagerStatus opens the WatchStatus stream of the ShareManagerAPIService on the
+// connection, the status updates are received with RecvMsg into a StatusUpdate
+func WatchShareManagerStatus(ctx context.Context, conn grpc.ClientConnInterface, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	opts = append(opts, grpc.CallContentSubtype(JSONCodecName))
+	stream, err := conn.NewStream(ctx, &shareManagerAPIStreams[0], apiMethodName("WatchStatus"), opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(&emptypb.Empty{}); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func apiMethodName(method string) string {
+	return "/" + ShareManagerAPIServiceName + "/" + method
+}
+
+// unaryMethod describes a unary method of the ShareManagerAPIService served by the handler
+func unaryMethod[Req, Resp any](name string, handler func(ShareManagerAPIServer, context.Context, *Req) (Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+
+			call := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return handler(srv.(ShareManagerAPIServer), ctx, req.(*Req))
+			}
+			if interceptor == nil {
+				return call(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: apiMethodName(name),
+			}
+			return interceptor(ctx, req, info, call)
+		},
+	}
+}
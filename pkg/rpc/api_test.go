@@ -0,0 +1,140 @@
+package rpc
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+)
+
+// fakeAPIServer serves the methods under test, any other method panics on the nil interface
+type fakeAPIServer struct {
+	ShareManagerAPIServer
+	status  *Status
+	updates []*StatusUpdate
+	resize  *FilesystemResizeRequest
+}
+
+func (f *fakeAPIServer) GetStatus(ctx context.Context, req *emptypb.Empty) (*Status, error) {
+	if f.status == nil {
+		return nil, grpcstatus.Error(grpccodes.Unavailable, "no status")
+	}
+	return f.status, nil
+}
+
+func (f *fakeAPIServer) FilesystemResizeWithOptions(ctx context.Context, req *FilesystemResizeRequest) (*emptypb.Empty, error) {
+	f.resize = req
+	return &emptypb.Empty{}, nil
+}
+
+func (f *fakeAPIServer) WatchStatus(req *emptypb.Empty, stream StatusStream) error {
+	for _, update := range f.updates {
+		if err := stream.Send(update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newAPITestConn(t *testing.T, srv ShareManagerAPIServer) *grpc.ClientConn {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	RegisterShareManagerAPIServer(s, srv)
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestShareManagerAPIGetStatus(t *testing.T) {
+	srv := &fakeAPIServer{status: &Status{Volume: "pvc-1", State: server.StateMounted, Exported: true}}
+	conn := newAPITestConn(t, srv)
+
+	status := &Status{}
+	if err := InvokeShareManagerAPI(context.Background(), conn, "GetStatus", &emptypb.Empty{}, status); err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+	if status.Volume != "pvc-1" || status.State != server.StateMounted || !status.Exported {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestShareManagerAPIError(t *testing.T) {
+	conn := newAPITestConn(t, &fakeAPIServer{})
+
+	err := InvokeShareManagerAPI(context.Background(), conn, "GetStatus", &emptypb.Empty{}, &Status{})
+	if code := grpcstatus.Code(err); code != grpccodes.Unavailable {
+		t.Fatalf("expected code %v, got %v: %v", grpccodes.Unavailable, code, err)
+	}
+}
+
+func TestShareManagerAPIFilesystemResizeWithOptions(t *testing.T) {
+	srv := &fakeAPIServer{}
+	conn := newAPITestConn(t, srv)
+
+	req := &FilesystemResizeRequest{SkipCryptoResize: true}
+	if err := InvokeShareManagerAPI(context.Background(), conn, "FilesystemResizeWithOptions", req, &emptypb.Empty{}); err != nil {
+		t.Fatalf("FilesystemResizeWithOptions failed: %v", err)
+	}
+	if srv.resize == nil || !srv.resize.SkipCryptoResize {
+		t.Fatalf("expected the skip crypto resize flag to reach the server, got %+v", srv.resize)
+	}
+}
+
+func TestShareManagerAPIUnknownMethod(t *testing.T) {
+	conn := newAPITestConn(t, &fakeAPIServer{})
+
+	err := InvokeShareManagerAPI(context.Background(), conn, "NoSuchMethod", &emptypb.Empty{}, &emptypb.Empty{})
+	if code := grpcstatus.Code(err); code != grpccodes.Unimplemented {
+		t.Fatalf("expected code %v, got %v: %v", grpccodes.Unimplemented, code, err)
+	}
+}
+
+func TestShareManagerAPIWatchStatus(t *testing.T) {
+	srv := &fakeAPIServer{updates: []*StatusUpdate{
+		{Status: &Status{Volume: "pvc-1", State: server.StateMounting}},
+		{Status: &Status{Volume: "pvc-1", State: server.StateMounted}},
+		{Status: &Status{Volume: "pvc-1", State: server.StateMounted}, Keepalive: true},
+	}}
+	conn := newAPITestConn(t, srv)
+
+	stream, err := WatchShareManagerStatus(context.Background(), conn)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+
+	for i, expected := range srv.updates {
+		update := &StatusUpdate{}
+		if err := stream.RecvMsg(update); err != nil {
+			t.Fatalf("failed to receive update %v: %v", i, err)
+		}
+		if update.Status.State != expected.Status.State || update.Keepalive != expected.Keepalive {
+			t.Fatalf("update %v: expected %+v, got %+v", i, expected, update)
+		}
+	}
+	if err := stream.RecvMsg(&StatusUpdate{}); err != io.EOF {
+		t.Fatalf("expected end of stream, got %v", err)
+	}
+}
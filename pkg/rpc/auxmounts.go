@@ -0,0 +1,97 @@
+package rpc
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	mount "k8s.io/mount-utils"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+// auxMountUnmountTimeout bounds the unmount of each auxiliary mount during cleanup
+const auxMountUnmountTimeout = 10 * time.Second
+
+// Kinds of the auxiliary mounts created next to the volume mount
+const (
+	auxMountBind     = "bind"
+	auxMountSnapshot = "snapshot"
+	auxMountVerify   = "verify"
+)
+
+// auxMountRegistry tracks the auxiliary mounts created by the server by path, so they can
+// be cleaned up on shutdown
+type auxMountRegistry struct {
+	sync.Mutex
+	mounts map[string]string
+}
+
+func (r *auxMountRegistry) add(path, kind string) {
+	r.Lock()
+	defer r.Unlock()
+	if r.mounts == nil {
+		r.mounts = map[string]string{}
+	}
+	r.mounts[path] = kind
+}
+
+func (r *auxMountRegistry) remove(path string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.mounts, path)
+}
+
+func (r *auxMountRegistry) list() map[string]string {
+	r.Lock()
+	defer r.Unlock()
+	mounts := make(map[string]string, len(r.mounts))
+	for path, kind := range r.mounts {
+		mounts[path] = kind
+	}
+	return mounts
+}
+
+// cleanupAuxMounts unmounts all registered auxiliary mounts before the volume is unmounted on
+// shutdown. It is best effort, a mount that fails to unmount is logged and skipped.
+func (s *ShareManagerServer) cleanupAuxMounts() {
+	for path, kind := range s.auxMounts.list() {
+		s.unmountAuxMount(path, kind)
+	}
+}
+
+// cleanupLeftoverAuxMounts unmounts the auxiliary mounts of the volume a previous instance
+// left behind. They are found by their paths, since the registry does not survive a restart.
+func (s *ShareManagerServer) cleanupLeftoverAuxMounts() error {
+	mountPoints, err := mount.New("").List()
+	if err != nil {
+		return errors.Wrap(err, "failed to list mount points")
+	}
+
+	snapshotPrefix := types.GetMountPath(snapshotExportName(s.manager.GetVolume().Name, ""))
+	for _, mp := range mountPoints {
+		switch {
+		case strings.HasPrefix(mp.Path, types.ReadOnlyBindMountPath+"/"):
+			s.unmountAuxMount(mp.Path, auxMountBind)
+		case strings.HasPrefix(mp.Path, snapshotPrefix):
+			s.unmountAuxMount(mp.Path, auxMountSnapshot)
+		case strings.HasPrefix(mp.Path, verifyExportPath+"/"):
+			s.unmountAuxMount(mp.Path, auxMountVerify)
+		}
+	}
+	return nil
+}
+
+func (s *ShareManagerServer) unmountAuxMount(path, kind string) {
+	log := s.logger.WithField("path", path)
+	if volume.CheckMountValid(path) {
+		log.Infof("Unmounting %v mount", kind)
+		if err := volume.UnmountVolumeWithTimeout(path, auxMountUnmountTimeout); err != nil {
+			log.WithError(err).Warnf("Failed to unmount %v mount", kind)
+			return
+		}
+	}
+	s.auxMounts.remove(path)
+}
@@ -0,0 +1,114 @@
+package rpc
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+type BindMountRequest struct {
+	TargetPath string
+}
+
+// CreateReadOnlyBindMount bind mounts the mounted volume read only at the target path,
+// e.g. for backup agents. The target must be below types.ReadOnlyBindMountPath.
+func (s *ShareManagerServer) CreateReadOnlyBindMount(ctx context.Context, req *BindMountRequest) (resp *emptypb.Empty, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
+	vol := s.manager.GetVolume()
+	log := s.logger.WithField("volume", vol.Name)
+
+	targetPath, err := validateBindMountTarget(req.TargetPath)
+	if err != nil {
+		return &emptypb.Empty{}, grpcstatus.Error(grpccodes.InvalidArgument, err.Error())
+	}
+
+	if state := s.manager.GetState(); state != server.StateMounted {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.FailedPrecondition, "volume %v is %v", vol.Name, state)
+	}
+
+	log.Infof("Bind mounting volume read only at %v", targetPath)
+	if err := volume.BindMountReadOnly(types.GetMountPath(vol.Name), targetPath); err != nil {
+		log.WithError(err).Errorf("Failed to bind mount volume at %v", targetPath)
+		s.recordError("CreateReadOnlyBindMount", vol.Name, err)
+		return &emptypb.Empty{}, grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+
+	if s.bindMounts == nil {
+		s.bindMounts = map[string]struct{}{}
+	}
+	s.bindMounts[targetPath] = struct{}{}
+	s.auxMounts.add(targetPath, auxMountBind)
+
+	return &emptypb.Empty{}, nil
+}
+
+// RemoveReadOnlyBindMount removes a bind mount created by CreateReadOnlyBindMount
+func (s *ShareManagerServer) RemoveReadOnlyBindMount(ctx context.Context, req *BindMountRequest) (resp *emptypb.Empty, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	targetPath, err := validateBindMountTarget(req.TargetPath)
+	if err != nil {
+		return &emptypb.Empty{}, grpcstatus.Error(grpccodes.InvalidArgument, err.Error())
+	}
+
+	if _, ok := s.bindMounts[targetPath]; !ok {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.NotFound, "bind mount %v is not found", targetPath)
+	}
+
+	if err := s.removeBindMount(targetPath); err != nil {
+		return &emptypb.Empty{}, grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// removeBindMounts removes all bind mounts, the caller must hold the lock
+func (s *ShareManagerServer) removeBindMounts() error {
+	for targetPath := range s.bindMounts {
+		if err := s.removeBindMount(targetPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ShareManagerServer) removeBindMount(targetPath string) error {
+	s.logger.Infof("Removing read only bind mount %v", targetPath)
+	if volume.CheckMountValid(targetPath) {
+		if err := volume.UnmountVolume(targetPath); err != nil {
+			return errors.Wrapf(err, "failed to unmount bind mount %v", targetPath)
+		}
+	}
+
+	delete(s.bindMounts, targetPath)
+	s.auxMounts.remove(targetPath)
+	return nil
+}
+
+func validateBindMountTarget(targetPath string) (string, error) {
+	if targetPath == "" {
+		return "", errors.New("target path is missing")
+	}
+
+	targetPath = filepath.Clean(targetPath)
+	if !strings.HasPrefix(targetPath, types.ReadOnlyBindMountPath+"/") {
+		return "", errors.Errorf("target path %v is not below %v", targetPath, types.ReadOnlyBindMountPath)
+	}
+	return targetPath, nil
+}
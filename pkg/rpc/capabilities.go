@@ -0,0 +1,87 @@
+package rpc
+
+import (
+	"os/exec"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	lhns "github.com/longhorn/go-common-libs/ns"
+	lhtypes "github.com/longhorn/go-common-libs/types"
+)
+
+// filesystemTools are the binaries needed to format and grow each filesystem
+var filesystemTools = map[string]struct {
+	mkfs   string
+	resize string
+	defrag string
+}{
+	"ext4":  {mkfs: "mkfs.ext4", resize: "resize2fs", defrag: "e4defrag"},
+	"xfs":   {mkfs: "mkfs.xfs", resize: "xfs_growfs", defrag: "xfs_fsr"},
+	"btrfs": {mkfs: "mkfs.btrfs", resize: "btrfs", defrag: "btrfs"},
+}
+
+// FilesystemCapabilities are the operations the pod can perform on a filesystem
+type FilesystemCapabilities struct {
+	Format bool `json:"format"`
+	Resize bool `json:"resize"`
+	Defrag bool `json:"defrag"`
+}
+
+// Capabilities are the filesystems and features the running pod supports, based on the tools
+// found in the image and, for encryption, on the host
+type Capabilities struct {
+	Filesystems map[string]FilesystemCapabilities `json:"filesystems"`
+	Encryption  bool                              `json:"encryption"`
+	Trim        bool                              `json:"trim"`
+}
+
+// capabilitiesProbe caches the capabilities, the tools do not change during the life of the pod
+type capabilitiesProbe struct {
+	once         sync.Once
+	capabilities *Capabilities
+}
+
+// GetCapabilities returns the filesystems and features the pod can handle, so the controller can
+// avoid requesting unsupported operations. The tools are probed once and the result is cached.
+func (s *ShareManagerServer) GetCapabilities(ctx context.Context, req *emptypb.Empty) (*Capabilities, error) {
+	s.capabilities.once.Do(func() {
+		s.capabilities.capabilities = probeCapabilities()
+		s.logger.Infof("Probed capabilities %+v", *s.capabilities.capabilities)
+	})
+	return s.capabilities.capabilities, nil
+}
+
+func probeCapabilities() *Capabilities {
+	capabilities := &Capabilities{
+		Filesystems: map[string]FilesystemCapabilities{},
+		Trim:        hasBinary(lhtypes.BinaryFstrim),
+		Encryption:  hasHostCryptsetup(),
+	}
+
+	for fsType, tools := range filesystemTools {
+		capabilities.Filesystems[fsType] = FilesystemCapabilities{
+			Format: hasBinary(tools.mkfs),
+			Resize: hasBinary(tools.resize),
+			Defrag: hasBinary(tools.defrag),
+		}
+	}
+	return capabilities
+}
+
+func hasBinary(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// hasHostCryptsetup checks for cryptsetup in the host namespace, where the crypto package runs it
+func hasHostCryptsetup() bool {
+	namespaces := []lhtypes.Namespace{lhtypes.NamespaceMnt, lhtypes.NamespaceIpc}
+	nsexec, err := lhns.NewNamespaceExecutor(lhtypes.ProcessNone, lhtypes.HostProcDirectory, namespaces)
+	if err != nil {
+		return false
+	}
+	_, err = nsexec.Execute(nil, lhtypes.BinaryCryptsetup, []string{"--version"}, lhtypes.ExecuteDefaultTimeout)
+	return err == nil
+}
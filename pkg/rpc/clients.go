@@ -0,0 +1,172 @@
+package rpc
+
+import (
+	"net"
+	"slices"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+)
+
+type ClientRequest struct {
+	// IP is the address of the nfs client
+	IP string
+}
+
+// ListBlockedClients returns the clients denied access to the export of the volume
+func (s *ShareManagerServer) ListBlockedClients(ctx context.Context, req *emptypb.Empty) ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	exporter, err := s.getExporter()
+	if err != nil {
+		return nil, err
+	}
+
+	clients, err := exporter.GetBlockedClients(s.manager.GetVolume().Name)
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.FailedPrecondition, err.Error())
+	}
+	return clients, nil
+}
+
+// BlockClient denies the client access to the export of the volume, e.g. when it misbehaves.
+// Open client state is dropped once the nfs server reloads the export.
+func (s *ShareManagerServer) BlockClient(ctx context.Context, req *ClientRequest) (*emptypb.Empty, error) {
+	return s.updateBlockedClients(req, func(clients []string, ip string) []string {
+		if slices.Contains(clients, ip) {
+			return clients
+		}
+		return append(clients, ip)
+	})
+}
+
+// UnblockClient allows the client to access the export of the volume again
+func (s *ShareManagerServer) UnblockClient(ctx context.Context, req *ClientRequest) (*emptypb.Empty, error) {
+	return s.updateBlockedClients(req, func(clients []string, ip string) []string {
+		return slices.DeleteFunc(clients, func(client string) bool { return client == ip })
+	})
+}
+
+func (s *ShareManagerServer) updateBlockedClients(req *ClientRequest, update func([]string, string) []string) (resp *emptypb.Empty, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
+	ip := net.ParseIP(req.IP)
+	if ip == nil {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.InvalidArgument, "invalid client ip %v", req.IP)
+	}
+
+	vol := s.manager.GetVolume()
+	log := s.logger.WithField("volume", vol.Name)
+
+	defer func() {
+		if err != nil {
+			log.WithError(err).Errorf("Failed to update blocked client %v", ip)
+			s.recordError("UpdateBlockedClients", vol.Name, err)
+		}
+	}()
+
+	exporter, err := s.getExporter()
+	if err != nil {
+		return nil, err
+	}
+
+	clients, err := exporter.GetBlockedClients(vol.Name)
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.FailedPrecondition, err.Error())
+	}
+
+	updated := update(slices.Clone(clients), ip.String())
+	if slices.Equal(clients, updated) {
+		return &emptypb.Empty{}, nil
+	}
+
+	log.Infof("Updating blocked clients from %v to %v", clients, updated)
+	if err := exporter.SetBlockedClientsAndReload(vol.Name, updated); err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// getExporter returns the exporter of the running nfs server
+func (s *ShareManagerServer) getExporter() (*nfs.Exporter, error) {
+	if !nfsServerIsRunning() {
+		return nil, newReasonError(grpccodes.Unavailable, ReasonGaneshaNotRunning, s.manager.GetVolume().Name, "nfs server is not running")
+	}
+
+	exporter, err := nfs.NewExporter(configPath, types.ExportPath)
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, errors.Wrap(err, "failed to create nfs exporter").Error())
+	}
+	return exporter, nil
+}
+
+type DisconnectClientRequest struct {
+	// IP is the address of the nfs client
+	IP string
+}
+
+// DisconnectClient terminates the sessions of a connected client, e.g. when it wedges the
+// export and a drain does not release it. Unlike BlockClient, the client is allowed to connect
+// again right away.
+func (s *ShareManagerServer) DisconnectClient(ctx context.Context, req *DisconnectClientRequest) (resp *emptypb.Empty, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
+	ip := net.ParseIP(req.IP)
+	if ip == nil {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.InvalidArgument, "invalid client ip %v", req.IP)
+	}
+
+	vol := s.manager.GetVolume()
+	log := s.logger.WithField("volume", vol.Name)
+
+	defer func() {
+		if err != nil {
+			log.WithError(err).Errorf("Failed to disconnect client %v", ip)
+			s.recordError("DisconnectClient", vol.Name, err)
+		}
+	}()
+
+	if !nfsServerIsRunning() {
+		return &emptypb.Empty{}, newReasonError(grpccodes.Unavailable, ReasonGaneshaNotRunning, vol.Name, "nfs server is not running")
+	}
+
+	address, err := nfs.ConnectedClientAddress(ctx, ip)
+	if err != nil {
+		return &emptypb.Empty{}, managementError(errors.Wrap(err, "failed to list connected clients"))
+	}
+	if address == "" {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.NotFound, "nfs client %v is not connected", ip)
+	}
+
+	log.Warnf("Disconnecting nfs client %v", address)
+	if err := nfs.RemoveClient(ctx, address); err != nil {
+		return &emptypb.Empty{}, managementError(errors.Wrapf(err, "failed to disconnect client %v", ip))
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// managementError maps an unavailable ganesha management interface to Unimplemented,
+// since the operation cannot be done without it
+func managementError(err error) error {
+	if errors.Is(err, nfs.ErrManagementUnavailable) {
+		return grpcstatus.Error(grpccodes.Unimplemented, err.Error())
+	}
+	return toGRPCError(err)
+}
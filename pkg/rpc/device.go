@@ -0,0 +1,73 @@
+package rpc
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/util"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+// DeviceInfo describes the devices backing the volume, for debugging device path mismatches
+type DeviceInfo struct {
+	DataEngine volume.DataEngine `json:"dataEngine"`
+	// RawDevicePath is the longhorn device of the volume and ResolvedDevicePath its symlink target
+	RawDevicePath      string `json:"rawDevicePath"`
+	ResolvedDevicePath string `json:"resolvedDevicePath,omitempty"`
+	// DeviceName is the kernel name of the raw device, e.g. sda for v1 or nvme0n1 for v2
+	DeviceName string `json:"deviceName,omitempty"`
+	// MappedDevicePath is the crypto device of an encrypted volume
+	MappedDevicePath  string `json:"mappedDevicePath,omitempty"`
+	MappedDeviceValid bool   `json:"mappedDeviceValid"`
+	// Valid is set when the raw device exists and is the kind of device exposed by the data engine
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// GetDeviceInfo returns the data engine and the devices of the volume
+func (s *ShareManagerServer) GetDeviceInfo(ctx context.Context, req *emptypb.Empty) (*DeviceInfo, error) {
+	vol := s.manager.GetVolume()
+
+	dataEngine := vol.DataEngine
+	if dataEngine == "" {
+		dataEngine = volume.DataEngineV1
+	}
+
+	info := &DeviceInfo{
+		DataEngine:    dataEngine,
+		RawDevicePath: vol.DevicePath(false),
+	}
+
+	if vol.IsEncrypted() {
+		info.MappedDevicePath = vol.DevicePath(true)
+		info.MappedDeviceValid = volume.CheckDeviceValid(info.MappedDevicePath)
+	}
+
+	if resolvedPath, err := filepath.EvalSymlinks(info.RawDevicePath); err == nil {
+		info.ResolvedDevicePath = resolvedPath
+	}
+
+	if !volume.CheckDeviceValid(info.RawDevicePath) {
+		info.Reason = fmt.Sprintf("device %v is not a valid block device", info.RawDevicePath)
+		return info, nil
+	}
+
+	deviceName, err := util.GetBlockDeviceName(info.RawDevicePath)
+	if err != nil {
+		info.Reason = fmt.Sprintf("failed to get kernel name of device %v: %v", info.RawDevicePath, err)
+		return info, nil
+	}
+	info.DeviceName = deviceName
+
+	if prefix := dataEngine.ExpectedDeviceNamePrefix(); !strings.HasPrefix(deviceName, prefix) {
+		info.Reason = fmt.Sprintf("device %v is not a %v device expected for data engine %v", deviceName, prefix, dataEngine)
+		return info, nil
+	}
+
+	info.Valid = true
+	return info, nil
+}
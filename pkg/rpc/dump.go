@@ -0,0 +1,39 @@
+package rpc
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+)
+
+// dumpTimeout bounds the management interface calls of a state dump
+const dumpTimeout = 30 * time.Second
+
+type GaneshaStateDump struct {
+	NFSServerRunning bool `json:"nfsServerRunning"`
+	*nfs.StateDump
+}
+
+// DumpGaneshaState collects the nfs server config, exports, clients and grace status for
+// support bundles. It works while the nfs server is down, the dump then holds the config only.
+func (s *ShareManagerServer) DumpGaneshaState(ctx context.Context, req *emptypb.Empty) (*GaneshaStateDump, error) {
+	exporter, err := nfs.NewExporter(configPath, types.ExportPath)
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, errors.Wrap(err, "failed to create nfs exporter").Error())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dumpTimeout)
+	defer cancel()
+
+	return &GaneshaStateDump{
+		NFSServerRunning: nfsServerIsRunning(),
+		StateDump:        exporter.DumpState(ctx),
+	}, nil
+}
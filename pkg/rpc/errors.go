@@ -0,0 +1,111 @@
+package rpc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// errorDomain is the domain of the ErrorInfo details attached to the returned errors
+const errorDomain = "sharemanager.longhorn.io"
+
+// Reasons of the ErrorInfo details, callers can branch on them instead of the error message
+const (
+	ReasonDeviceNotValid    = "DEVICE_NOT_VALID"
+	ReasonTargetBusy        = "TARGET_BUSY"
+	ReasonGaneshaNotRunning = "GANESHA_NOT_RUNNING"
+	ReasonMaintenanceMode   = "MAINTENANCE_MODE"
+	ReasonInvalidTransition = "INVALID_STATE_TRANSITION"
+	ReasonDeviceBusy        = "DEVICE_BUSY"
+	ReasonConfigRejected    = "CONFIG_REJECTED"
+	ReasonFilesystemCorrupt = "FILESYSTEM_CORRUPT"
+)
+
+// newReasonError returns a status error carrying an ErrorInfo with the reason and the volume name
+func newReasonError(code grpccodes.Code, reason, volume, format string, args ...interface{}) error {
+	st := grpcstatus.New(code, fmt.Sprintf(format, args...))
+	metadata := map[string]string{}
+	if volume != "" {
+		metadata["volume"] = volume
+	}
+
+	withDetails, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   errorDomain,
+		Metadata: metadata,
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// errorHistorySize caps the number of operation errors kept in memory
+const errorHistorySize = 100
+
+// OperationError is a failed operation recorded for debugging
+type OperationError struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Volume    string    `json:"volume"`
+	Error     string    `json:"error"`
+}
+
+// errorHistory is a fixed size ring buffer of the most recent operation errors
+type errorHistory struct {
+	sync.Mutex
+	entries [errorHistorySize]OperationError
+	next    int
+	count   int
+}
+
+type GetRecentErrorsRequest struct {
+	// Limit is the maximum number of errors to return, zero returns all kept errors
+	Limit int
+}
+
+// GetRecentErrors returns the most recent operation errors, newest first
+func (s *ShareManagerServer) GetRecentErrors(ctx context.Context, req *GetRecentErrorsRequest) ([]OperationError, error) {
+	if req.Limit < 0 {
+		return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "invalid limit %v", req.Limit)
+	}
+	return s.recentErrors.list(req.Limit), nil
+}
+
+// recordError adds a failed operation to the error history
+func (s *ShareManagerServer) recordError(operation, volume string, err error) {
+	s.recentErrors.Lock()
+	defer s.recentErrors.Unlock()
+
+	h := &s.recentErrors
+	h.entries[h.next] = OperationError{
+		Time:      time.Now(),
+		Operation: operation,
+		Volume:    volume,
+		Error:     err.Error(),
+	}
+	h.next = (h.next + 1) % errorHistorySize
+	if h.count < errorHistorySize {
+		h.count++
+	}
+}
+
+func (h *errorHistory) list(limit int) []OperationError {
+	h.Lock()
+	defer h.Unlock()
+
+	if limit == 0 || limit > h.count {
+		limit = h.count
+	}
+
+	result := make([]OperationError, 0, limit)
+	for i := 1; i <= limit; i++ {
+		result = append(result, h.entries[(h.next-i+errorHistorySize)%errorHistorySize])
+	}
+	return result
+}
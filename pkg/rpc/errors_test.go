@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+// errorInfo returns the ErrorInfo details of a status error, nil if it has none
+func errorInfo(t *testing.T, err error) *errdetails.ErrorInfo {
+	t.Helper()
+
+	st, ok := grpcstatus.FromError(err)
+	if !ok {
+		t.Fatalf("expected a status error, got %v", err)
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+	return nil
+}
+
+func TestNewReasonError(t *testing.T) {
+	err := newReasonError(grpccodes.FailedPrecondition, ReasonMaintenanceMode, "pvc-1", "volume %v is in maintenance mode", "pvc-1")
+
+	if code := grpcstatus.Code(err); code != grpccodes.FailedPrecondition {
+		t.Fatalf("expected code %v, got %v", grpccodes.FailedPrecondition, code)
+	}
+	if message := grpcstatus.Convert(err).Message(); message != "volume pvc-1 is in maintenance mode" {
+		t.Fatalf("unexpected message %q", message)
+	}
+
+	info := errorInfo(t, err)
+	if info == nil {
+		t.Fatal("expected ErrorInfo details")
+	}
+	if info.Reason != ReasonMaintenanceMode || info.Domain != errorDomain || info.Metadata["volume"] != "pvc-1" {
+		t.Fatalf("unexpected ErrorInfo %v", info)
+	}
+
+	info = errorInfo(t, newReasonError(grpccodes.Unavailable, ReasonDeviceNotValid, "", "device is not ready"))
+	if _, ok := info.Metadata["volume"]; ok {
+		t.Fatalf("expected no volume without a volume name, got %v", info.Metadata)
+	}
+}
+
+func TestToGRPCError(t *testing.T) {
+	tests := []struct {
+		err    error
+		code   grpccodes.Code
+		reason string
+	}{
+		{err: errors.Wrap(nfs.ErrExportIDInUse, "export 1"), code: grpccodes.AlreadyExists},
+		{err: fmt.Errorf("mount failed: %w", volume.ErrDeviceNotReady), code: grpccodes.Unavailable, reason: ReasonDeviceNotValid},
+		{err: fmt.Errorf("mount failed: %w", volume.ErrFilesystemCorrupt), code: grpccodes.DataLoss, reason: ReasonFilesystemCorrupt},
+		{err: fmt.Errorf("%w: unknown parameter", nfs.ErrConfigRejected), code: grpccodes.FailedPrecondition, reason: ReasonConfigRejected},
+		{err: errors.New("unexpected"), code: grpccodes.Internal},
+	}
+
+	for _, tt := range tests {
+		err := toGRPCError(tt.err)
+		if code := grpcstatus.Code(err); code != tt.code {
+			t.Errorf("%v: expected code %v, got %v", tt.err, tt.code, code)
+		}
+
+		reason := ""
+		if info := errorInfo(t, err); info != nil {
+			reason = info.Reason
+		}
+		if reason != tt.reason {
+			t.Errorf("%v: expected reason %q, got %q", tt.err, tt.reason, reason)
+		}
+	}
+}
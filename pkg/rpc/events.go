@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"sync"
+	"time"
+)
+
+// eventBufferSize is the number of events buffered per subscriber before new events are dropped
+const eventBufferSize = 16
+
+type EventType string
+
+const (
+	EventTypeExported   = EventType("exported")
+	EventTypeUnexported = EventType("unexported")
+	EventTypeResized    = EventType("resized")
+	// EventTypeStateChanged is emitted on every transition of the volume state
+	EventTypeStateChanged = EventType("stateChanged")
+)
+
+// Event describes a lifecycle transition of the shared volume
+type Event struct {
+	Type   EventType `json:"type"`
+	Volume string    `json:"volume"`
+	Time   time.Time `json:"time"`
+}
+
+// eventBroadcaster fans out events to subscribers without blocking the sender
+type eventBroadcaster struct {
+	sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// Subscribe returns a channel receiving the lifecycle events of the volume and a function
+// to cancel the subscription. Events are dropped for a subscriber that does not keep up,
+// so a slow consumer cannot stall the RPCs.
+func (s *ShareManagerServer) Subscribe() (<-chan Event, func()) {
+	b := &s.events
+	b.Lock()
+	defer b.Unlock()
+
+	if b.subscribers == nil {
+		b.subscribers = map[chan Event]struct{}{}
+	}
+
+	ch := make(chan Event, eventBufferSize)
+	b.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		b.Lock()
+		defer b.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (s *ShareManagerServer) emitEvent(eventType EventType) {
+	event := Event{
+		Type:   eventType,
+		Volume: s.manager.GetVolume().Name,
+		Time:   time.Now(),
+	}
+
+	b := &s.events
+	b.Lock()
+	defer b.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			s.logger.Warnf("Dropping %v event for slow subscriber", eventType)
+		}
+	}
+}
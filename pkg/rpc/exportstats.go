@@ -0,0 +1,51 @@
+package rpc
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+)
+
+type ExportStatistics struct {
+	Volume   string `json:"volume"`
+	ExportID uint16 `json:"exportID"`
+	*nfs.ExportStatistics
+}
+
+// GetExportStatistics returns the read and write operation counts, bytes and latencies ganesha
+// counted for the export of the volume. It returns Unimplemented if the nfs server does not
+// count statistics or its management interface is unavailable.
+func (s *ShareManagerServer) GetExportStatistics(ctx context.Context, req *emptypb.Empty) (*ExportStatistics, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	vol := s.manager.GetVolume()
+
+	exporter, err := s.getExporter()
+	if err != nil {
+		return nil, err
+	}
+
+	exportID := exporter.GetExport(vol.Name)
+	if exportID == 0 {
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "volume %v is not exported", vol.Name)
+	}
+
+	stats, err := nfs.GetExportStatistics(ctx, exportID)
+	if err != nil {
+		if errors.Is(err, nfs.ErrStatisticsDisabled) {
+			return nil, grpcstatus.Error(grpccodes.Unimplemented, err.Error())
+		}
+		return nil, managementError(errors.Wrapf(err, "failed to get statistics of export %v", exportID))
+	}
+
+	return &ExportStatistics{
+		Volume:           vol.Name,
+		ExportID:         exportID,
+		ExportStatistics: stats,
+	}, nil
+}
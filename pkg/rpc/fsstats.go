@@ -0,0 +1,50 @@
+package rpc
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+// GetFilesystemStats returns the space and inode usage of the mounted filesystem, so running
+// out of inodes can be told apart from running out of space
+func (s *ShareManagerServer) GetFilesystemStats(ctx context.Context, req *emptypb.Empty) (*volume.FilesystemStats, error) {
+	vol := s.manager.GetVolume()
+	if s.manager.GetState() != server.StateMounted {
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "volume %v is not mounted", vol.Name)
+	}
+
+	stats, err := volume.GetFilesystemStats(types.GetMountPath(vol.Name))
+	if err != nil {
+		return nil, grpcstatus.Errorf(grpccodes.Internal, "failed to get filesystem stats of volume %v: %v", vol.Name, err)
+	}
+	return stats, nil
+}
+
+// degradedReason extends the degraded reason of the share manager with the inode usage check
+func (s *ShareManagerServer) degradedReason() string {
+	if reason := s.manager.GetDegradedReason(); reason != "" {
+		return reason
+	}
+
+	if s.options.InodeUsageThreshold <= 0 || s.manager.GetState() != server.StateMounted {
+		return ""
+	}
+
+	mountPath := types.GetMountPath(s.manager.GetVolume().Name)
+	stats, err := volume.GetFilesystemStats(mountPath)
+	if err != nil {
+		return fmt.Sprintf("failed to get filesystem stats of %v: %v", mountPath, err)
+	}
+	if usage := stats.InodeUsagePercentage(); usage > s.options.InodeUsageThreshold {
+		return fmt.Sprintf("filesystem mounted at %v uses %.1f%% of its inodes, %v are free", mountPath, usage, stats.FreeInodes)
+	}
+	return ""
+}
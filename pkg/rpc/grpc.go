@@ -0,0 +1,57 @@
+package rpc
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	DefaultKeepaliveTime    = 30 * time.Second
+	DefaultKeepaliveTimeout = 10 * time.Second
+	DefaultMaxMsgSize       = 16 * 1024 * 1024
+
+	// keepaliveMinTime is the minimum interval of client pings accepted by the server
+	keepaliveMinTime = 10 * time.Second
+)
+
+// GRPCOptions holds the connection settings of the gRPC server
+type GRPCOptions struct {
+	// KeepaliveTime is the idle time after which the server pings the client
+	KeepaliveTime time.Duration
+	// KeepaliveTimeout is how long the server waits for the ping ack before closing the connection
+	KeepaliveTimeout time.Duration
+
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+}
+
+// Validate checks that the gRPC options are usable
+func (o GRPCOptions) Validate() error {
+	if o.KeepaliveTime <= 0 || o.KeepaliveTimeout <= 0 {
+		return fmt.Errorf("keepalive time %v and timeout %v must be positive", o.KeepaliveTime, o.KeepaliveTimeout)
+	}
+	if o.MaxRecvMsgSize <= 0 || o.MaxSendMsgSize <= 0 {
+		return fmt.Errorf("max receive message size %v and max send message size %v must be positive", o.MaxRecvMsgSize, o.MaxSendMsgSize)
+	}
+	return nil
+}
+
+// ServerOptions returns the options to create the gRPC server with
+func (o GRPCOptions) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    o.KeepaliveTime,
+			Timeout: o.KeepaliveTimeout,
+		}),
+		// allow the clients to keep idle connections alive with their own pings
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             keepaliveMinTime,
+			PermitWithoutStream: true,
+		}),
+		grpc.MaxRecvMsgSize(o.MaxRecvMsgSize),
+		grpc.MaxSendMsgSize(o.MaxSendMsgSize),
+	}
+}
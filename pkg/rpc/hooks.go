@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+const defaultHookTimeout = time.Minute
+
+// Hook is a shell command run at a point of the volume lifecycle.
+// The command is a template with the fields Volume and MountPath, e.g. "restorecon -R {{.MountPath}}".
+type Hook struct {
+	Command string
+	// Timeout bounds the command run time, zero uses defaultHookTimeout
+	Timeout time.Duration
+	// Fatal fails the operation when the command fails, otherwise a warning is logged
+	Fatal bool
+}
+
+// Validate checks that the hook command is a valid template
+func (h *Hook) Validate() error {
+	if h == nil {
+		return nil
+	}
+	if _, err := h.render(volume.Volume{}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (h *Hook) render(vol volume.Volume) (string, error) {
+	tmpl, err := template.New("hook").Option("missingkey=error").Parse(h.Command)
+	if err != nil {
+		return "", fmt.Errorf("invalid hook command %q: %v", h.Command, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, struct {
+		Volume    string
+		MountPath string
+	}{
+		Volume:    vol.Name,
+		MountPath: types.GetMountPath(vol.Name),
+	}); err != nil {
+		return "", fmt.Errorf("invalid hook command %q: %v", h.Command, err)
+	}
+	return buf.String(), nil
+}
+
+// runHook runs the hook and logs its output. The error is only returned for a fatal hook.
+func runHook(ctx context.Context, name string, hook *Hook, vol volume.Volume, log logrus.FieldLogger) error {
+	if hook == nil || hook.Command == "" {
+		return nil
+	}
+
+	command, err := hook.render(vol)
+	if err != nil {
+		return err
+	}
+
+	timeout := hook.Timeout
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.Infof("Running %v hook: %v", name, command)
+	out, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("%v hook failed: %v, output: %s", name, err, out)
+		if hook.Fatal {
+			return err
+		}
+		log.WithError(err).Warnf("Ignoring failure of %v hook", name)
+		return nil
+	}
+
+	log.Infof("Finished %v hook, output: %s", name, out)
+	return nil
+}
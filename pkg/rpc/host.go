@@ -0,0 +1,68 @@
+package rpc
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"k8s.io/mount-utils"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+// volumeExporter is the part of the nfs exporter Mount and Unmount use to export the volume
+type volumeExporter interface {
+	GetExport(volume string) uint16
+	HasExport(volume string, options nfs.ExportOptions) (bool, error)
+	CreateExportAndReload(volume string, options nfs.ExportOptions) (uint16, error)
+	DeleteExportAndReload(volume string) error
+}
+
+// shareHost is how Mount and Unmount reach the nfs server and the mount of the volume,
+// so the handlers can be tested without ganesha and a volume device
+type shareHost interface {
+	// checkNFSServerRunning returns nil if the nfs server process is running
+	checkNFSServerRunning() error
+	newExporter() (volumeExporter, error)
+	listRunningExportIDs(ctx context.Context) ([]uint16, error)
+	isMountPoint(path string) (bool, error)
+	// unmount unmounts the path, a zero timeout does not bound the unmount
+	unmount(path string, timeout time.Duration) error
+}
+
+// podHost is the share manager pod the server runs in
+type podHost struct{}
+
+func (podHost) checkNFSServerRunning() error {
+	return checkNFSServerRunning()
+}
+
+func (podHost) newExporter() (volumeExporter, error) {
+	exporter, err := nfs.NewExporter(configPath, types.ExportPath)
+	if err != nil {
+		return nil, err
+	}
+	return exporter, nil
+}
+
+func (podHost) listRunningExportIDs(ctx context.Context) ([]uint16, error) {
+	return nfs.ListRunningExportIDs(ctx)
+}
+
+func (podHost) isMountPoint(path string) (bool, error) {
+	return mount.New("").IsMountPoint(path)
+}
+
+func (podHost) unmount(path string, timeout time.Duration) error {
+	if timeout > 0 {
+		return volume.UnmountVolumeWithTimeout(path, timeout)
+	}
+	return volume.UnmountVolume(path)
+}
+
+// checkMountValid returns true if the path is a mount point, like volume.CheckMountValid
+func (s *ShareManagerServer) checkMountValid(path string) bool {
+	isMountPoint, err := s.host.isMountPoint(path)
+	return err == nil && isMountPoint
+}
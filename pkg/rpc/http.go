@@ -0,0 +1,64 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// statusServerShutdownTimeout bounds the wait for in flight status requests on shutdown
+const statusServerShutdownTimeout = 5 * time.Second
+
+// RunStatusServer serves the volume status as JSON at /status and the metrics in the Prometheus
+// text format at /metrics on the status address until the context is done, so both can be
+// checked without a gRPC client. It is a no-op if no status address is configured.
+func (s *ShareManagerServer) RunStatusServer(ctx context.Context) {
+	if s.options.StatusAddress == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.serveStatus)
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	httpServer := &http.Server{
+		Addr:              s.options.StatusAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), statusServerShutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			s.logger.WithError(err).Warn("Failed to shut down status server")
+		}
+	}()
+
+	s.logger.Infof("Listening on share manager status server %v", s.options.StatusAddress)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		s.logger.WithError(err).Errorf("Share manager status server at %v is down", s.options.StatusAddress)
+	}
+}
+
+func (s *ShareManagerServer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, err := s.GetStatus(r.Context(), &emptypb.Empty{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		s.logger.WithError(err).Debug("Failed to write status response")
+	}
+}
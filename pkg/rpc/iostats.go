@@ -0,0 +1,72 @@
+package rpc
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/util"
+)
+
+// IOStats are the I/O counters of the volume device and the rates since the previous sample
+type IOStats struct {
+	Device     string `json:"device"`
+	ReadIOs    uint64 `json:"readIOs"`
+	ReadBytes  uint64 `json:"readBytes"`
+	WriteIOs   uint64 `json:"writeIOs"`
+	WriteBytes uint64 `json:"writeBytes"`
+
+	ReadIOPS            float64 `json:"readIOPS"`
+	WriteIOPS           float64 `json:"writeIOPS"`
+	ReadBytesPerSecond  float64 `json:"readBytesPerSecond"`
+	WriteBytesPerSecond float64 `json:"writeBytesPerSecond"`
+}
+
+// ioStatsSampler keeps the previous sample of the device counters to compute rates
+type ioStatsSampler struct {
+	sync.Mutex
+	last *util.DiskStats
+}
+
+// GetIOStats samples the I/O counters of the volume device from /proc/diskstats.
+// The rates cover the time since the previous call and are zero on the first one.
+func (s *ShareManagerServer) GetIOStats(ctx context.Context, req *emptypb.Empty) (*IOStats, error) {
+	vol := s.manager.GetVolume()
+	devicePath := vol.DevicePath(vol.IsEncrypted())
+
+	stats, err := util.GetDiskStats(devicePath)
+	if err != nil {
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "failed to get I/O stats of volume %v: %v", vol.Name, err)
+	}
+
+	return s.ioStats.sample(stats), nil
+}
+
+func (i *ioStatsSampler) sample(stats *util.DiskStats) *IOStats {
+	i.Lock()
+	defer i.Unlock()
+
+	result := &IOStats{
+		Device:     stats.Device,
+		ReadIOs:    stats.ReadIOs,
+		ReadBytes:  stats.ReadBytes,
+		WriteIOs:   stats.WriteIOs,
+		WriteBytes: stats.WriteBytes,
+	}
+
+	// the counters restart when the device is re-created
+	if last := i.last; last != nil && last.Device == stats.Device && stats.ReadIOs >= last.ReadIOs && stats.WriteIOs >= last.WriteIOs {
+		if elapsed := stats.Time.Sub(last.Time).Seconds(); elapsed > 0 {
+			result.ReadIOPS = float64(stats.ReadIOs-last.ReadIOs) / elapsed
+			result.WriteIOPS = float64(stats.WriteIOs-last.WriteIOs) / elapsed
+			result.ReadBytesPerSecond = float64(stats.ReadBytes-last.ReadBytes) / elapsed
+			result.WriteBytesPerSecond = float64(stats.WriteBytes-last.WriteBytes) / elapsed
+		}
+	}
+	i.last = stats
+
+	return result
+}
@@ -0,0 +1,62 @@
+package rpc
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/util"
+)
+
+const (
+	// maxGaneshaLogLines caps the lines returned by GetGaneshaLogs
+	maxGaneshaLogLines = 1000
+	// defaultGaneshaLogLines is the number of lines returned if the request does not set it
+	defaultGaneshaLogLines = 100
+	// maxGaneshaLogBytes bounds the bytes read from the end of the log file
+	maxGaneshaLogBytes = 1 << 20
+)
+
+type GetGaneshaLogsRequest struct {
+	// Lines is the number of lines to return from the end of the log, zero returns the default
+	Lines int
+}
+
+type GetGaneshaLogsResponse struct {
+	Path  string   `json:"path"`
+	Lines []string `json:"lines"`
+}
+
+// GetGaneshaLogs returns the last lines of the ganesha log file, so nfs server errors can be
+// surfaced without exec access to the pod
+func (s *ShareManagerServer) GetGaneshaLogs(ctx context.Context, req *GetGaneshaLogsRequest) (*GetGaneshaLogsResponse, error) {
+	lines := req.Lines
+	if lines < 0 {
+		return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "invalid line count %v", lines)
+	}
+	if lines == 0 {
+		lines = defaultGaneshaLogLines
+	}
+	if lines > maxGaneshaLogLines {
+		lines = maxGaneshaLogLines
+	}
+
+	logPath := s.options.GaneshaLogPath
+	if logPath == "" || strings.HasPrefix(logPath, "/proc/") {
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "ganesha logs to %q, which is not a regular file", logPath)
+	}
+
+	logLines, err := util.TailFile(logPath, lines, maxGaneshaLogBytes)
+	if err != nil {
+		// the file does not exist between a rotation and the next write
+		if os.IsNotExist(err) {
+			return &GetGaneshaLogsResponse{Path: logPath, Lines: []string{}}, nil
+		}
+		return nil, grpcstatus.Errorf(grpccodes.Internal, "failed to read ganesha log %v: %v", logPath, err)
+	}
+
+	return &GetGaneshaLogsResponse{Path: logPath, Lines: logLines}, nil
+}
@@ -0,0 +1,28 @@
+package rpc
+
+import (
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type SetMaintenanceModeRequest struct {
+	Enabled bool
+}
+
+// SetMaintenanceMode toggles the maintenance mode. While enabled, operations changing the
+// volume are rejected with Unavailable, while status queries and health checks keep working.
+// The mode is kept in memory only.
+func (s *ShareManagerServer) SetMaintenanceMode(ctx context.Context, req *SetMaintenanceModeRequest) (*emptypb.Empty, error) {
+	if s.maintenance.Swap(req.Enabled) != req.Enabled {
+		s.logger.Infof("Maintenance mode is set to %v", req.Enabled)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ShareManagerServer) checkMaintenanceMode() error {
+	if s.maintenance.Load() {
+		return newReasonError(grpccodes.Unavailable, ReasonMaintenanceMode, s.manager.GetVolume().Name, "share manager is in maintenance mode")
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/util"
+)
+
+const (
+	metricsNamespace = "longhorn_share_manager"
+	// metricsContentType is the Prometheus text exposition format
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// metricsWriter writes metrics in the Prometheus text exposition format, the share manager
+// does not depend on the Prometheus client for the handful of metrics it has
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+// labelValueEscaper escapes a label value as the text exposition format expects
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type metricLabel struct {
+	name  string
+	value string
+}
+
+// write writes a metric family with a single sample, the name is prefixed with the namespace
+func (m *metricsWriter) write(name, metricType, help string, value float64, labels ...metricLabel) {
+	name = metricsNamespace + "_" + name
+	fmt.Fprintf(&m.buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(&m.buf, "# TYPE %s %s\n", name, metricType)
+	m.buf.WriteString(name)
+	if len(labels) > 0 {
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+		pairs := make([]string, len(labels))
+		for i, label := range labels {
+			pairs[i] = label.name + `="` + labelValueEscaper.Replace(label.value) + `"`
+		}
+		m.buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	m.buf.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+// serveMetrics serves the metrics of the volume and the nfs server. Metrics that cannot be
+// sampled, e.g. of the nfs server process while it is not running, are left out.
+func (s *ShareManagerServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m := &metricsWriter{}
+	s.writeMetrics(r.Context(), m)
+
+	w.Header().Set("Content-Type", metricsContentType)
+	if _, err := w.Write(m.buf.Bytes()); err != nil {
+		s.logger.WithError(err).Debug("Failed to write metrics response")
+	}
+}
+
+func (s *ShareManagerServer) writeMetrics(ctx context.Context, m *metricsWriter) {
+	vol := s.manager.GetVolume()
+	volume := metricLabel{"volume", vol.Name}
+
+	m.write("export_self_heals_total", "counter", "Number of times a lost export of the volume was restored.",
+		float64(s.exportSelfHeals.Load()), volume)
+
+	// the raw counters are exposed, so rates are left to the queries and GetIOStats keeps its own sample
+	if stats, err := util.GetDiskStats(vol.DevicePath(vol.IsEncrypted())); err == nil {
+		device := metricLabel{"device", stats.Device}
+		m.write("device_read_ios_total", "counter", "Number of reads completed by the volume device.",
+			float64(stats.ReadIOs), volume, device)
+		m.write("device_read_bytes_total", "counter", "Number of bytes read by the volume device.",
+			float64(stats.ReadBytes), volume, device)
+		m.write("device_write_ios_total", "counter", "Number of writes completed by the volume device.",
+			float64(stats.WriteIOs), volume, device)
+		m.write("device_write_bytes_total", "counter", "Number of bytes written by the volume device.",
+			float64(stats.WriteBytes), volume, device)
+	}
+
+	if stats, err := s.GetGaneshaProcessStats(ctx, &emptypb.Empty{}); err == nil {
+		m.write("nfs_server_cpu_seconds_total", "counter", "Total user and system cpu time of the nfs server process in seconds.",
+			stats.CPUSeconds)
+		m.write("nfs_server_resident_memory_bytes", "gauge", "Resident memory size of the nfs server process in bytes.",
+			float64(stats.ResidentBytes))
+		m.write("nfs_server_virtual_memory_bytes", "gauge", "Virtual memory size of the nfs server process in bytes.",
+			float64(stats.VirtualBytes))
+		m.write("nfs_server_threads", "gauge", "Number of threads of the nfs server process.",
+			float64(stats.Threads))
+	}
+
+	// the export statistics are only available if the nfs server is configured to count them
+	if stats, err := s.GetExportStatistics(ctx, &emptypb.Empty{}); err == nil {
+		writeExportMetrics(m, volume, "read", stats.Read)
+		writeExportMetrics(m, volume, "write", stats.Write)
+	} else if code := grpcstatus.Code(err); code != grpccodes.Unimplemented && code != grpccodes.FailedPrecondition {
+		s.logger.WithError(err).Debug("Failed to get export statistics for the metrics")
+	}
+}
+
+func writeExportMetrics(m *metricsWriter, volume metricLabel, op string, stats nfs.IOStatistics) {
+	m.write("export_"+op+"_operations_total", "counter", fmt.Sprintf("Number of %v operations on the export.", op),
+		float64(stats.Operations), volume)
+	m.write("export_"+op+"_errors_total", "counter", fmt.Sprintf("Number of failed %v operations on the export.", op),
+		float64(stats.Errors), volume)
+	m.write("export_"+op+"_bytes_total", "counter", fmt.Sprintf("Number of bytes transferred by %v operations on the export.", op),
+		float64(stats.TransferredBytes), volume)
+	m.write("export_"+op+"_latency_seconds_total", "counter", fmt.Sprintf("Total latency of %v operations on the export in seconds.", op),
+		float64(stats.LatencyNanoseconds)/1e9, volume)
+}
@@ -0,0 +1,28 @@
+package rpc
+
+import (
+	"testing"
+)
+
+func TestMetricsWriter(t *testing.T) {
+	m := &metricsWriter{}
+	m.write("device_read_bytes_total", "counter", "Number of bytes read by the volume device.", 4096,
+		metricLabel{"volume", "pvc-1"}, metricLabel{"device", "sda"})
+	m.write("nfs_server_threads", "gauge", "Number of threads of the nfs server process.", 12)
+	m.write("export_read_latency_seconds_total", "counter", "Total latency.", 0.25,
+		metricLabel{"volume", `pvc-"quoted"`})
+
+	expected := `# HELP longhorn_share_manager_device_read_bytes_total Number of bytes read by the volume device.
+# TYPE longhorn_share_manager_device_read_bytes_total counter
+longhorn_share_manager_device_read_bytes_total{device="sda",volume="pvc-1"} 4096
+# HELP longhorn_share_manager_nfs_server_threads Number of threads of the nfs server process.
+# TYPE longhorn_share_manager_nfs_server_threads gauge
+longhorn_share_manager_nfs_server_threads 12
+# HELP longhorn_share_manager_export_read_latency_seconds_total Total latency.
+# TYPE longhorn_share_manager_export_read_latency_seconds_total counter
+longhorn_share_manager_export_read_latency_seconds_total{volume="pvc-\"quoted\""} 0.25
+`
+	if got := m.buf.String(); got != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
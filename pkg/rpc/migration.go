@@ -0,0 +1,84 @@
+package rpc
+
+import (
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+)
+
+// A planned migration of the share manager to another node is driven by the controller:
+//
+//  1. PrepareMigration on the old share manager flushes the filesystem and starts the
+//     grace period, so no new state is granted while clients are moved.
+//  2. Unmount on the old share manager, then detach and attach the volume to the new node.
+//  3. Start the new share manager with the same volume UID and export id and move the
+//     service endpoint to it.
+//  4. CompleteMigration on the new share manager exports the volume with the same export
+//     id and fsid and starts the grace period, so clients reconnect and reclaim their state.
+
+type MigrationRequest struct {
+	// IPAddress is the service address the clients use, empty to only start the grace period
+	IPAddress string
+}
+
+// PrepareMigration quiesces the export before the volume is moved to another node
+func (s *ShareManagerServer) PrepareMigration(ctx context.Context, req *MigrationRequest) (resp *emptypb.Empty, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
+	vol := s.manager.GetVolume()
+	log := s.logger.WithField("volume", vol.Name)
+
+	if state := s.manager.GetState(); state != server.StateMounted {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.FailedPrecondition, "volume %v is %v instead of mounted", vol.Name, state)
+	}
+
+	defer func() {
+		if err != nil {
+			log.WithError(err).Error("Failed to prepare migration")
+			s.recordError("PrepareMigration", vol.Name, err)
+		}
+	}()
+
+	s.syncFilesystem(ctx, vol, log)
+
+	log.Info("Starting nfs grace period for migration")
+	if err := nfs.StartGrace(ctx, req.IPAddress); err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// CompleteMigration exports the volume on the new node and lets the clients reclaim their state.
+// The export id and fsid must be stable, otherwise the clients get stale file handles.
+func (s *ShareManagerServer) CompleteMigration(ctx context.Context, req *MigrationRequest) (*emptypb.Empty, error) {
+	vol := s.manager.GetVolume()
+	log := s.logger.WithField("volume", vol.Name)
+
+	options := s.manager.GetExportOptions()
+	if options.ExportID == 0 || options.FilesystemID == "" {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.FailedPrecondition, "volume %v has no stable export id and fsid for migration", vol.Name)
+	}
+
+	if _, err := s.Mount(ctx, &emptypb.Empty{}); err != nil {
+		return nil, err
+	}
+
+	log.Info("Starting nfs grace period after migration")
+	if err := nfs.StartGrace(ctx, req.IPAddress); err != nil {
+		log.WithError(err).Error("Failed to complete migration")
+		s.recordError("CompleteMigration", vol.Name, err)
+		return nil, toGRPCError(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
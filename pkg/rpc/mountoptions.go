@@ -0,0 +1,49 @@
+package rpc
+
+import (
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+// MountOptions are the effective options of the volume mount as listed in /proc/mounts
+type MountOptions struct {
+	MountPath string   `json:"mountPath"`
+	Device    string   `json:"device"`
+	FsType    string   `json:"fsType"`
+	Options   []string `json:"options"`
+	// Requested are the mount options configured for the volume, the filesystem specific
+	// options added on mount, e.g. for ACLs or discard, are only part of the effective ones
+	Requested []string `json:"requested"`
+}
+
+// GetMountOptions returns the options the volume is actually mounted with, e.g. to verify
+// that noatime, discard or acl were applied after a remount
+func (s *ShareManagerServer) GetMountOptions(ctx context.Context, req *emptypb.Empty) (*MountOptions, error) {
+	vol := s.manager.GetVolume()
+	mountPath := types.GetMountPath(vol.Name)
+
+	mp, err := volume.GetMountPoint(mountPath)
+	if err != nil {
+		return nil, grpcstatus.Errorf(grpccodes.Internal, "failed to list mount points: %v", err)
+	}
+	if mp == nil {
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "volume %v is not mounted", vol.Name)
+	}
+
+	requested := vol.MountOptions
+	if requested == nil {
+		requested = []string{}
+	}
+	return &MountOptions{
+		MountPath: mountPath,
+		Device:    mp.Device,
+		FsType:    mp.Type,
+		Options:   mp.Opts,
+		Requested: requested,
+	}, nil
+}
@@ -0,0 +1,29 @@
+package rpc
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/crypto"
+)
+
+type PassphraseFingerprint struct {
+	// Scheme is how the fingerprint is derived, so it can be computed from the stored secret
+	Scheme string `json:"scheme,omitempty"`
+	// Fingerprint is empty for an unencrypted volume
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// GetPassphraseFingerprint returns a fingerprint of the passphrase the volume is encrypted with,
+// so a drift of the stored secret can be detected without transmitting the passphrase
+func (s *ShareManagerServer) GetPassphraseFingerprint(ctx context.Context, req *emptypb.Empty) (*PassphraseFingerprint, error) {
+	vol := s.manager.GetVolume()
+	if !vol.IsEncrypted() {
+		return &PassphraseFingerprint{}, nil
+	}
+
+	return &PassphraseFingerprint{
+		Scheme:      crypto.PassphraseFingerprintScheme,
+		Fingerprint: crypto.PassphraseFingerprint(vol.Passphrase, vol.Name),
+	}, nil
+}
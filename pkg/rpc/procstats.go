@@ -0,0 +1,109 @@
+package rpc
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/util"
+)
+
+// GaneshaProcessStats is the resource usage of the nfs server process
+type GaneshaProcessStats struct {
+	Pid        int     `json:"pid"`
+	CPUSeconds float64 `json:"cpuSeconds"`
+	// CPUUsage is the cpu usage in percent of one core since the previous sample
+	CPUUsage      float64   `json:"cpuUsage"`
+	ResidentBytes uint64    `json:"residentBytes"`
+	VirtualBytes  uint64    `json:"virtualBytes"`
+	Threads       int64     `json:"threads"`
+	Time          time.Time `json:"time"`
+}
+
+// processStatsSampler keeps the previous sample of the nfs server process to compute the cpu usage
+type processStatsSampler struct {
+	sync.Mutex
+	last   *util.ProcessStats
+	latest *GaneshaProcessStats
+}
+
+// GetGaneshaProcessStats returns the resource usage of the nfs server process. With a stats
+// interval configured it returns the latest periodic sample, otherwise it samples on the call
+// and the cpu usage covers the time since the previous call.
+// NotFound is returned while the nfs server is not running.
+func (s *ShareManagerServer) GetGaneshaProcessStats(ctx context.Context, req *emptypb.Empty) (*GaneshaProcessStats, error) {
+	var stats *GaneshaProcessStats
+	if s.options.GaneshaStatsInterval > 0 {
+		stats = s.processStats.get()
+	} else {
+		stats = s.processStats.sample()
+	}
+
+	if stats == nil {
+		return nil, grpcstatus.Error(grpccodes.NotFound, "nfs server is not running")
+	}
+	return stats, nil
+}
+
+// RunGaneshaStatsSampler periodically samples the resource usage of the nfs server process
+// until the context is done. It is a no-op if no stats interval is configured.
+func (s *ShareManagerServer) RunGaneshaStatsSampler(ctx context.Context) {
+	if s.options.GaneshaStatsInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.options.GaneshaStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.processStats.sample()
+		}
+	}
+}
+
+func (p *processStatsSampler) get() *GaneshaProcessStats {
+	p.Lock()
+	defer p.Unlock()
+	return p.latest
+}
+
+// sample reads the stats of the nfs server process, the stats are cleared if it is not running
+func (p *processStatsSampler) sample() *GaneshaProcessStats {
+	p.Lock()
+	defer p.Unlock()
+
+	p.latest = nil
+	process, err := util.FindProcessByName(nfs.ProcessName)
+	if err != nil {
+		p.last = nil
+		return nil
+	}
+	stats, err := util.GetProcessStats(process.Pid)
+	if err != nil {
+		// the process exited after it was found
+		p.last = nil
+		return nil
+	}
+
+	p.latest = &GaneshaProcessStats{
+		Pid:           stats.Pid,
+		CPUSeconds:    stats.CPUSeconds,
+		CPUUsage:      stats.CPUUsage(p.last),
+		ResidentBytes: stats.ResidentBytes,
+		VirtualBytes:  stats.VirtualBytes,
+		Threads:       stats.Threads,
+		Time:          stats.Time,
+	}
+	p.last = stats
+
+	return p.latest
+}
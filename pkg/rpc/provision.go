@@ -0,0 +1,123 @@
+package rpc
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/crypto"
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+// ProvisionRequest describes the volume the caller expects, it has to match the share manager settings
+type ProvisionRequest struct {
+	// FsType is the filesystem of the volume, empty accepts the configured one
+	FsType string
+	// Encrypted signals that the volume is encrypted
+	Encrypted bool
+}
+
+// Provision sets up the crypto device if needed, formats and mounts a new volume and exports it.
+// If a step fails, the steps done by this call are undone, so no half provisioned volume is left.
+func (s *ShareManagerServer) Provision(ctx context.Context, req *ProvisionRequest) (resp *emptypb.Empty, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
+	vol := s.manager.GetVolume()
+	if req.FsType != "" && req.FsType != vol.FsType {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.InvalidArgument, "requested filesystem %v does not match filesystem %v of volume %v", req.FsType, vol.FsType, vol.Name)
+	}
+	if req.Encrypted != vol.IsEncrypted() {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.InvalidArgument, "requested encryption %v does not match volume %v", req.Encrypted, vol.Name)
+	}
+
+	if !nfsServerIsRunning() {
+		return &emptypb.Empty{}, newReasonError(grpccodes.Unavailable, ReasonGaneshaNotRunning, vol.Name, "nfs server is not running")
+	}
+
+	log := s.logger.WithField("volume", vol.Name)
+
+	rawDevicePath := vol.DevicePath(false)
+	mountPath := types.GetMountPath(vol.Name)
+
+	if s.manager.GetState() == server.StateMounted && s.manager.ShareIsExported() && volume.CheckMountValid(mountPath) {
+		return &emptypb.Empty{}, nil
+	}
+
+	prevState := s.manager.GetState()
+	if err := s.manager.TransitionState(server.StateMounting); err != nil {
+		return &emptypb.Empty{}, newReasonError(grpccodes.FailedPrecondition, ReasonInvalidTransition, vol.Name, err.Error())
+	}
+
+	var cleanups []func() error
+	defer func() {
+		if err != nil {
+			log.WithError(err).Error("Failed to provision volume, rolling back")
+			s.recordError("Provision", vol.Name, err)
+			for i := len(cleanups) - 1; i >= 0; i-- {
+				if cleanupErr := cleanups[i](); cleanupErr != nil {
+					log.WithError(cleanupErr).Warn("Failed to roll back provisioning step")
+				}
+			}
+			s.manager.SettleState(prevState)
+			return
+		}
+		s.manager.SettleState(server.StateMounted)
+	}()
+
+	err = s.waitForDevice(ctx, rawDevicePath)
+	if err != nil {
+		return nil, err
+	}
+
+	devicePath := rawDevicePath
+	if vol.IsEncrypted() {
+		isOpen, openErr := crypto.IsDeviceOpen(vol.DevicePath(true))
+		if openErr != nil {
+			err = openErr
+			return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+		}
+		devicePath, err = s.manager.SetupDevice(rawDevicePath)
+		if err != nil {
+			return nil, toGRPCError(err)
+		}
+		if !isOpen {
+			cleanups = append(cleanups, s.manager.TearDownDevice)
+		}
+	}
+
+	if !volume.CheckMountValid(mountPath) {
+		log.Info("Formatting and mounting volume")
+		err = s.mount(ctx, vol, devicePath, mountPath)
+		if err != nil {
+			return nil, toGRPCError(err)
+		}
+		cleanups = append(cleanups, func() error { return s.unmount(vol) })
+
+		err = volume.SetPermissions(mountPath, 0777)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to set permissions of %v", mountPath)
+			return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+		}
+	}
+
+	log.Info("Exporting volume")
+	err = s.export(ctx, vol)
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	log.Info("Volume is provisioned")
+	s.manager.SetShareExported(true)
+	s.emitEvent(EventTypeExported)
+
+	return &emptypb.Empty{}, nil
+}
@@ -0,0 +1,126 @@
+package rpc
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+)
+
+// DesiredExportState is the export state the controller wants. The settings not listed here
+// are taken from the volume configuration.
+type DesiredExportState struct {
+	Exported     bool
+	AccessRules  []types.AccessRule
+	SecTypes     []string
+	Squash       string
+	AnonymousUID *uint32
+	AnonymousGID *uint32
+}
+
+type ReconcileExportResponse struct {
+	// Actions are the changes applied to the export, empty if it already matched
+	Actions []nfs.ReconcileAction `json:"actions"`
+}
+
+// ReconcileExport makes the export of the volume match the desired state with the minimal
+// changes and a single reload. It is idempotent. Exporting requires the volume to be mounted.
+func (s *ShareManagerServer) ReconcileExport(ctx context.Context, req *DesiredExportState) (resp *ReconcileExportResponse, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return nil, err
+	}
+
+	vol := s.manager.GetVolume()
+	log := s.logger.WithField("volume", vol.Name)
+
+	if req.Exported && s.manager.GetState() != server.StateMounted {
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "volume %v has to be mounted to be exported", vol.Name)
+	}
+
+	options, err := s.desiredExportOptions(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			log.WithError(err).Error("Failed to reconcile export")
+			s.recordError("ReconcileExport", vol.Name, err)
+		}
+	}()
+
+	exporter, err := s.getExporter()
+	if err != nil {
+		return nil, err
+	}
+
+	actions, err := exporter.ReconcileExportAndReload(vol.Name, req.Exported, options)
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	if len(actions) > 0 {
+		log.Infof("Reconciled export with actions %v", actions)
+	}
+
+	if s.manager.ShareIsExported() != req.Exported {
+		s.manager.SetShareExported(req.Exported)
+		if req.Exported {
+			s.emitEvent(EventTypeExported)
+		} else {
+			s.emitEvent(EventTypeUnexported)
+		}
+	}
+
+	return &ReconcileExportResponse{Actions: actions}, nil
+}
+
+type PreviewExportResponse struct {
+	// Config is the export block the volume would get in the nfs server config
+	Config string `json:"config"`
+}
+
+// PreviewExport returns the export block ReconcileExport would write for the desired state,
+// so it can be validated and diffed before it is applied. It changes neither the config nor
+// the running nfs server.
+func (s *ShareManagerServer) PreviewExport(ctx context.Context, req *DesiredExportState) (*PreviewExportResponse, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	options, err := s.desiredExportOptions(req)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := nfs.NewExporter(configPath, types.ExportPath)
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, errors.Wrap(err, "failed to create nfs exporter").Error())
+	}
+
+	config, err := exporter.RenderExport(s.manager.GetVolume().Name, options)
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+	return &PreviewExportResponse{Config: config}, nil
+}
+
+// desiredExportOptions returns the export options of the volume with the desired settings applied
+func (s *ShareManagerServer) desiredExportOptions(req *DesiredExportState) (nfs.ExportOptions, error) {
+	options := s.manager.GetExportOptions()
+	options.AccessRules = req.AccessRules
+	options.SecTypes = req.SecTypes
+	options.Squash = req.Squash
+	options.AnonymousUID = req.AnonymousUID
+	options.AnonymousGID = req.AnonymousGID
+	if err := options.Validate(); err != nil {
+		return options, grpcstatus.Error(grpccodes.InvalidArgument, err.Error())
+	}
+	return options, nil
+}
@@ -0,0 +1,94 @@
+package rpc
+
+import (
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+)
+
+// listExportsTimeout bounds the management interface call listing the running exports
+const listExportsTimeout = 10 * time.Second
+
+// RunExportSelfHeal periodically checks that the export of the mounted volume is in the config
+// and loaded by the running nfs server, and restores it if it was lost. It runs until the context
+// is done and is a no-op if no reconcile interval is configured.
+func (s *ShareManagerServer) RunExportSelfHeal(ctx context.Context) {
+	if s.options.ExportReconcileInterval <= 0 {
+		return
+	}
+
+	s.logger.Infof("Starting export self heal with interval %v", s.options.ExportReconcileInterval)
+	ticker := time.NewTicker(s.options.ExportReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Export self heal is shutting down")
+			return
+		case <-ticker.C:
+			if err := s.selfHealExport(ctx); err != nil {
+				s.logger.WithError(err).Warn("Failed to self heal export")
+			}
+		}
+	}
+}
+
+func (s *ShareManagerServer) selfHealExport(ctx context.Context) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.maintenance.Load() {
+		s.logger.Debug("Skipping export self heal in maintenance mode")
+		return nil
+	}
+	if s.manager.GetState() != server.StateMounted || !s.manager.ShareIsExported() || !nfsServerIsRunning() {
+		return nil
+	}
+
+	vol := s.manager.GetVolume()
+	log := s.logger.WithField("volume", vol.Name)
+
+	exporter, err := nfs.NewExporter(configPath, types.ExportPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to create nfs exporter")
+	}
+
+	id := exporter.GetExport(vol.Name)
+	if id == 0 {
+		log.Warn("Export of volume is missing from the nfs server config, re-exporting it")
+		if err := s.createExport(vol); err != nil {
+			return err
+		}
+		s.exportSelfHeals.Add(1)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, listExportsTimeout)
+	defer cancel()
+
+	ids, err := nfs.ListRunningExportIDs(ctx)
+	if errors.Is(err, nfs.ErrManagementUnavailable) {
+		log.WithError(err).Debug("Skipping running export check")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to list running exports")
+	}
+	if slices.Contains(ids, id) {
+		return nil
+	}
+
+	log.Warnf("Export %v of volume is not loaded by the nfs server, reloading it", id)
+	if err := exporter.ReloadExport(); err != nil {
+		return err
+	}
+	s.exportSelfHeals.Add(1)
+	return nil
+}
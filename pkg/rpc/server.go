@@ -3,12 +3,14 @@ package rpc
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/fscrypt/filesystem"
-	lhexec "github.com/longhorn/go-common-libs/exec"
 	lhtypes "github.com/longhorn/go-common-libs/types"
 	"github.com/longhorn/types/pkg/generated/smrpc"
 	"github.com/pkg/errors"
@@ -20,6 +22,7 @@ import (
 	"google.golang.org/protobuf/types/known/emptypb"
 	"k8s.io/mount-utils"
 
+	"github.com/longhorn/longhorn-share-manager/pkg/crypto"
 	"github.com/longhorn/longhorn-share-manager/pkg/server"
 	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
 	"github.com/longhorn/longhorn-share-manager/pkg/types"
@@ -31,28 +34,177 @@ const (
 	configPath = "/tmp/vfs.conf"
 
 	unmountRetryCount    = 30
-	unmountRetryInterval = 1
+	unmountRetryInterval = time.Second
+
+	syncTimeout = 30 * time.Second
+
+	deviceWaitInterval = 500 * time.Millisecond
+
+	exportRetryInitialInterval = 250 * time.Millisecond
+	exportRetryMaxInterval     = 2 * time.Second
 )
 
+// ServerOptions holds the settings of the share manager gRPC server
+type ServerOptions struct {
+	// MountTimeout is the maximum time a Mount call may spend mounting the volume
+	MountTimeout time.Duration
+	// UnmountTimeout bounds the unmount of the volume, zero does not bound it
+	UnmountTimeout time.Duration
+	// TrimTimeout bounds fstrim, zero uses the default execute timeout
+	TrimTimeout time.Duration
+	// ResizeTimeout bounds the filesystem resize of FilesystemResize, zero does not bound it
+	ResizeTimeout time.Duration
+
+	// ExportRetryTimeout is how long creating the export is retried on transient nfs server errors,
+	// e.g. while ganesha starts up. Errors in the export definition are not retried. Zero does not retry.
+	ExportRetryTimeout time.Duration
+
+	// DeviceWaitTimeout is how long Mount waits for the volume device to become valid, e.g. while
+	// udev settles after an attach. Zero checks the device once.
+	DeviceWaitTimeout time.Duration
+
+	// TrimInterval is the interval of the background trim, zero disables it
+	TrimInterval time.Duration
+	// TrimWindow restricts the background trim to a daily time window, nil allows any time.
+	// Manual FilesystemTrim calls are not restricted.
+	TrimWindow *util.TimeWindow
+	// TrimMaxUtilization is the device utilization percentage above which a trim is refused to
+	// protect the client latency, zero disables the check. Forced trims are not checked.
+	TrimMaxUtilization float64
+
+	// ExportReconcileInterval is the interval the export is checked against the config and the
+	// running nfs server and restored if it was lost, zero disables it
+	ExportReconcileInterval time.Duration
+
+	// StatusAddress is the address of the HTTP server serving the volume status as JSON at /status
+	// and the metrics at /metrics, empty disables it
+	StatusAddress string
+
+	// GaneshaStatsInterval is the interval the resource usage of the nfs server process is sampled at,
+	// zero samples on every GetGaneshaProcessStats call
+	GaneshaStatsInterval time.Duration
+
+	// PostMountHook runs after the volume is mounted and exported, nil disables it
+	PostMountHook *Hook
+
+	// PreUnmountHook runs before the volume is unexported and unmounted, nil disables it
+	PreUnmountHook *Hook
+
+	// MountWithoutNFSServer mounts the volume without exporting it while the nfs server is not
+	// running, so the filesystem can be inspected or repaired. By default Mount skips both.
+	MountWithoutNFSServer bool
+
+	// TrimDeviceCheck is how FilesystemTrim verifies that the mount point is on the volume device,
+	// one of strict (default), relaxed or skip. See the TrimDeviceCheck constants for the risks.
+	TrimDeviceCheck string
+
+	// InodeUsageThreshold is the inode usage percentage above which the volume is reported as
+	// degraded, zero disables the check
+	InodeUsageThreshold float64
+
+	// GaneshaLogPath is the log file of the nfs server read by GetGaneshaLogs
+	GaneshaLogPath string
+
+	// VerifyExport enables the VerifyExport check, which mounts the export from within the pod
+	VerifyExport bool
+	// VerifyExportAddress is the nfs server address used by the check, empty uses the loopback address
+	VerifyExportAddress string
+
+	// CleanupStaleMounts makes Init unmount a stale mount and remove a stale export of the volume
+	// left behind by a previous instance. A healthy mount of the volume device is kept for reuse.
+	CleanupStaleMounts bool
+}
+
 type ShareManagerServer struct {
 	smrpc.UnimplementedShareManagerServiceServer
 	sync.RWMutex
 
 	logger  logrus.FieldLogger
 	manager *server.ShareManager
+	options ServerOptions
+	events  eventBroadcaster
+	host    shareHost
+
+	// bindMounts are the read only bind mount targets of the volume
+	bindMounts map[string]struct{}
+	// snapshotExports are the export ids of the snapshots exported read only by snapshot name
+	snapshotExports map[string]uint16
+	// auxMounts are the bind, snapshot and verification mounts unmounted on shutdown
+	auxMounts auxMountRegistry
+
+	maintenance  atomic.Bool
+	trimPaused   atomic.Bool
+	ioStats      ioStatsSampler
+	processStats processStatsSampler
+	recentErrors errorHistory
+	capabilities capabilitiesProbe
+
+	// exportSelfHeals counts the exports restored by the export self heal
+	exportSelfHeals atomic.Uint64
+
+	// startMaxConnections is the client connection limit the nfs server was started with, -1 if unknown
+	startMaxConnections int
 }
 
-func NewShareManagerServer(manager *server.ShareManager) *ShareManagerServer {
-	return &ShareManagerServer{
+func NewShareManagerServer(manager *server.ShareManager, options ServerOptions) *ShareManagerServer {
+	s := &ShareManagerServer{
 		logger:  util.NewLogger(),
 		manager: manager,
+		options: options,
+		host:    podHost{},
+
+		startMaxConnections: -1,
 	}
+	manager.OnStateChange(func(server.State) {
+		s.emitEvent(EventTypeStateChanged)
+	})
+	manager.OnShutdown(s.cleanupAuxMounts)
+	return s
+}
+
+// Init verifies that the export path and the nfs config directory exist and are writable,
+// so a broken setup fails at startup instead of on the first Mount. If enabled, it also
+// cleans up a stale mount and export of the volume left behind by a previous instance.
+func (s *ShareManagerServer) Init() error {
+	for _, dir := range []string{types.ExportPath, filepath.Dir(configPath)} {
+		if err := util.CheckDirWritable(dir); err != nil {
+			return errors.Wrap(err, "failed to validate share manager paths")
+		}
+	}
+
+	// the nfs server config is written before the server starts, it holds the limit in effect
+	if exporter, err := nfs.NewExporter(configPath, types.ExportPath); err == nil {
+		if maxConnections, err := exporter.GetMaxConnections(); err == nil {
+			s.startMaxConnections = maxConnections
+		}
+	}
+
+	if s.options.CleanupStaleMounts {
+		if err := s.cleanupLeftoverAuxMounts(); err != nil {
+			return errors.Wrap(err, "failed to clean up leftover mounts")
+		}
+		if err := s.cleanupStaleMount(); err != nil {
+			return errors.Wrap(err, "failed to clean up stale mount")
+		}
+	}
+
+	return nil
 }
 
 func (s *ShareManagerServer) FilesystemTrim(ctx context.Context, req *smrpc.FilesystemTrimRequest) (resp *emptypb.Empty, err error) {
+	// the load is sampled before taking the lock, so other requests are not blocked meanwhile
+	if err := s.checkTrimLoad(ctx, s.manager.GetVolume(), req.EncryptedDevice); err != nil {
+		s.recordError("FilesystemTrim", s.manager.GetVolume().Name, err)
+		return &emptypb.Empty{}, err
+	}
+
 	s.Lock()
 	defer s.Unlock()
 
+	if err := s.checkMaintenanceMode(); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
 	vol := s.manager.GetVolume()
 	if vol.Name == "" {
 		s.logger.Warn("Volume name is missing")
@@ -64,28 +216,40 @@ func (s *ShareManagerServer) FilesystemTrim(ctx context.Context, req *smrpc.File
 	defer func() {
 		if err != nil {
 			log.WithError(err).Errorf("Failed to trim mounted filesystem on volume")
+			s.recordError("FilesystemTrim", vol.Name, err)
 		}
 	}()
 
-	devicePath := types.GetVolumeDevicePath(vol.Name, req.EncryptedDevice)
-	if !volume.CheckDeviceValid(devicePath) {
-		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.FailedPrecondition, "volume %v is not valid", vol.Name)
+	_, err = s.trim(ctx, vol, req.EncryptedDevice, 0, 0, log)
+	if err != nil {
+		return &emptypb.Empty{}, err
 	}
 
-	mountPath := types.GetMountPath(vol.Name)
+	return &emptypb.Empty{}, nil
+}
 
-	mnt, err := filesystem.GetMount(mountPath)
-	if err != nil {
-		return &emptypb.Empty{}, grpcstatus.Error(grpccodes.Internal, err.Error())
+// trim runs fstrim on the mounted filesystem of the volume and returns the number of trimmed bytes.
+// A non zero offset or length restricts the trim to that byte range. The device load is checked
+// by the callers with checkTrimLoad before they take the server lock.
+func (s *ShareManagerServer) trim(ctx context.Context, vol volume.Volume, encryptedDevice bool, offset, length uint64, log logrus.FieldLogger) (uint64, error) {
+	if vol.Discard {
+		log.Debug("Skipping trim since volume is mounted with online discard")
+		return 0, nil
 	}
 
-	deviceNumber, err := util.GetDeviceNumber(devicePath)
-	if err != nil {
-		return &emptypb.Empty{}, grpcstatus.Error(grpccodes.Internal, err.Error())
+	if err := checkEncryptedDevice(vol, encryptedDevice); err != nil {
+		return 0, err
 	}
 
-	if uint64(mnt.DeviceNumber) != uint64(deviceNumber) {
-		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.InvalidArgument, "the device of mount point %v is not expected", mountPath)
+	devicePath := vol.DevicePath(encryptedDevice)
+	if !volume.CheckDeviceValid(devicePath) {
+		return 0, newReasonError(grpccodes.FailedPrecondition, ReasonDeviceNotValid, vol.Name, "volume %v is not valid", vol.Name)
+	}
+
+	mountPath := types.GetMountPath(vol.Name)
+
+	if err := s.checkTrimMountDevice(devicePath, mountPath, log); err != nil {
+		return 0, err
 	}
 
 	log.Infof("Trimming mounted filesystem %v", mountPath)
@@ -93,39 +257,462 @@ func (s *ShareManagerServer) FilesystemTrim(ctx context.Context, req *smrpc.File
 	mounter := mount.New("")
 	isMountPoint, err := mounter.IsMountPoint(mountPath)
 	if !isMountPoint {
-		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.InvalidArgument, "%v is not a mount point", mountPath)
+		return 0, grpcstatus.Errorf(grpccodes.InvalidArgument, "%v is not a mount point", mountPath)
 	}
 	if err != nil {
-		return &emptypb.Empty{}, grpcstatus.Error(grpccodes.Internal, err.Error())
+		return 0, grpcstatus.Error(grpccodes.Internal, err.Error())
 	}
 
 	if _, err := os.ReadDir(mountPath); err != nil {
-		return &emptypb.Empty{}, grpcstatus.Error(grpccodes.Internal, err.Error())
+		return 0, grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+
+	if offset > 0 || length > 0 {
+		size, err := volume.GetFilesystemSize(mountPath)
+		if err != nil {
+			return 0, grpcstatus.Error(grpccodes.Internal, err.Error())
+		}
+		if offset >= size || length > size-offset {
+			return 0, grpcstatus.Errorf(grpccodes.InvalidArgument, "trim range offset %v length %v exceeds the filesystem size %v", offset, length, size)
+		}
+		log.Infof("Trimming %v bytes at offset %v of the filesystem", length, offset)
+	}
+
+	trimTimeout := s.options.TrimTimeout
+	if trimTimeout <= 0 {
+		trimTimeout = lhtypes.ExecuteDefaultTimeout
 	}
+	ctx, cancel := context.WithTimeout(ctx, trimTimeout)
+	defer cancel()
 
-	execute := lhexec.NewExecutor().Execute
-	_, err = execute([]string{}, lhtypes.BinaryFstrim, []string{mountPath}, lhtypes.ExecuteDefaultTimeout)
+	output, err := volume.TrimFilesystem(ctx, mountPath, offset, length)
 	if err != nil {
-		return &emptypb.Empty{}, grpcstatus.Error(grpccodes.Internal, err.Error())
+		return 0, toGRPCError(err)
 	}
 
-	log.Infof("Finished trimming mounted filesystem %v", mountPath)
+	trimmedBytes := parseTrimmedBytes(output)
+	log.Infof("Finished trimming mounted filesystem %v, trimmed %v bytes", mountPath, trimmedBytes)
+
+	return trimmedBytes, nil
+}
+
+// FilesystemResize grows the crypto device, if any, and the mounted filesystem to the size of the volume.
+// Both steps are no-ops when already done, so a resize interrupted in between is completed by the next call.
+func (s *ShareManagerServer) FilesystemResize(ctx context.Context, req *emptypb.Empty) (*emptypb.Empty, error) {
+	return s.FilesystemResizeWithOptions(ctx, &FilesystemResizeRequest{})
+}
+
+type FilesystemResizeRequest struct {
+	// SkipCryptoResize grows the filesystem only, for a crypto device already resized by another component
+	SkipCryptoResize bool
+}
+
+// resizesCryptoDevice reports whether a resize request grows the crypto device of the volume
+func resizesCryptoDevice(vol volume.Volume, req *FilesystemResizeRequest) bool {
+	return vol.IsEncrypted() && !req.SkipCryptoResize
+}
+
+// FilesystemResizeWithOptions is FilesystemResize with the crypto device resize optionally skipped
+func (s *ShareManagerServer) FilesystemResizeWithOptions(ctx context.Context, req *FilesystemResizeRequest) (resp *emptypb.Empty, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
+	vol := s.manager.GetVolume()
+	if vol.Name == "" {
+		s.logger.Warn("Volume name is missing")
+		return &emptypb.Empty{}, nil
+	}
+
+	log := s.logger.WithField("volume", vol.Name)
+
+	defer func() {
+		if err != nil {
+			log.WithError(err).Errorf("Failed to resize filesystem on volume")
+			s.recordError("FilesystemResize", vol.Name, err)
+		}
+	}()
+
+	if err := s.checkResizable(vol); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
+	if err := s.checkDeviceExpanded(vol, log); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
+	if vol.IsEncrypted() && req.SkipCryptoResize {
+		log.Info("Skipping crypto device resize as requested")
+	}
+	if resizesCryptoDevice(vol, req) {
+		log.Info("Resizing crypto device")
+		if err := crypto.ResizeEncryptoDevice(vol.CryptoMappingName(), vol.Passphrase, vol.CryptoHeaderPath, vol.CryptoTimeout); err != nil {
+			return &emptypb.Empty{}, grpcstatus.Error(grpccodes.Internal, err.Error())
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return &emptypb.Empty{}, toGRPCError(err)
+	}
+
+	if _, err := s.growFilesystem(ctx, vol, log); err != nil {
+		return &emptypb.Empty{}, err
+	}
+	s.manager.RecordGrownDeviceSize()
+
+	s.emitEvent(EventTypeResized)
 
 	return &emptypb.Empty{}, nil
 }
 
-func (s *ShareManagerServer) unexport(vol volume.Volume) error {
+// RefreshSize grows the mounted filesystem after the device was resized out of band.
+// Unlike FilesystemResize it assumes the crypto device, if any, is already at the right size.
+func (s *ShareManagerServer) RefreshSize(ctx context.Context, req *emptypb.Empty) (resized bool, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return false, err
+	}
+
+	vol := s.manager.GetVolume()
+	if vol.Name == "" {
+		s.logger.Warn("Volume name is missing")
+		return false, nil
+	}
+
+	log := s.logger.WithField("volume", vol.Name)
+
+	defer func() {
+		if err != nil {
+			log.WithError(err).Errorf("Failed to refresh filesystem size on volume")
+			s.recordError("RefreshSize", vol.Name, err)
+		}
+	}()
+
+	if err := s.checkResizable(vol); err != nil {
+		return false, err
+	}
+
+	resized, err = s.growFilesystem(ctx, vol, log)
+	if err != nil {
+		return false, err
+	}
+
+	if resized {
+		s.emitEvent(EventTypeResized)
+	}
+
+	return resized, nil
+}
+
+func (s *ShareManagerServer) checkResizable(vol volume.Volume) error {
+	if state := s.manager.GetState(); state != server.StateMounted {
+		return grpcstatus.Errorf(grpccodes.FailedPrecondition, "volume %v is %v", vol.Name, state)
+	}
+
+	devicePath := vol.DevicePath(vol.IsEncrypted())
+	if !volume.CheckDeviceValid(devicePath) {
+		return newReasonError(grpccodes.FailedPrecondition, ReasonDeviceNotValid, vol.Name, "volume %v is not valid", vol.Name)
+	}
+
+	return nil
+}
+
+// checkDeviceExpanded refuses a resize while the raw volume device still has the size it had when
+// it was mounted, since the filesystem resize would silently do nothing. A device the filesystem
+// was already grown to passes, so a repeated resize succeeds.
+func (s *ShareManagerServer) checkDeviceExpanded(vol volume.Volume, log logrus.FieldLogger) error {
+	mountedSize, grownSize := s.manager.GetDeviceSizes()
+	if mountedSize == 0 {
+		return nil
+	}
+
+	devicePath := vol.DevicePath(false)
+	size, err := util.GetDeviceSize(devicePath)
+	if err != nil {
+		return grpcstatus.Error(grpccodes.Internal, errors.Wrapf(err, "failed to get size of device %v", devicePath).Error())
+	}
+
+	if !deviceExpanded(size, mountedSize, grownSize) {
+		log.Warnf("Device %v is still %v bytes as when it was mounted", devicePath, size)
+		return grpcstatus.Errorf(grpccodes.FailedPrecondition, "device of volume %v has not been expanded yet, it is still %v bytes", vol.Name, size)
+	}
+	return nil
+}
+
+// deviceExpanded reports whether the device size allows a resize. The size the device was mounted
+// with does not, unless the filesystem was already grown to it. An unknown mounted size, zero, passes.
+func deviceExpanded(size, mountedSize, grownSize int64) bool {
+	return mountedSize == 0 || size != mountedSize || size == grownSize
+}
+
+// checkEncryptedDevice verifies that the encrypted device flag of a request matches the volume,
+// so a request does not act on the raw device of an encrypted volume or the other way around
+func checkEncryptedDevice(vol volume.Volume, encryptedDevice bool) error {
+	rawDevicePath := vol.DevicePath(false)
+	if !volume.CheckDeviceValid(rawDevicePath) {
+		return newReasonError(grpccodes.FailedPrecondition, ReasonDeviceNotValid, vol.Name, "volume %v is not valid", vol.Name)
+	}
+
+	diskFormat, err := volume.GetDiskFormat(rawDevicePath)
+	if err != nil {
+		return grpcstatus.Error(grpccodes.Internal, errors.Wrapf(err, "failed to get disk format of %v", rawDevicePath).Error())
+	}
+	return checkEncryptedFormat(vol, diskFormat, encryptedDevice)
+}
+
+// checkEncryptedFormat verifies that the encrypted device flag matches the disk format of the raw device
+func checkEncryptedFormat(vol volume.Volume, diskFormat string, encryptedDevice bool) error {
+	// a device with a detached LUKS header has no signature of its own
+	isEncrypted := diskFormat == "luks" || vol.CryptoHeaderPath != ""
+	if isEncrypted && !encryptedDevice {
+		return grpcstatus.Errorf(grpccodes.InvalidArgument, "volume %v is encrypted but the request is for the unencrypted device", vol.Name)
+	}
+	if !isEncrypted && encryptedDevice {
+		return grpcstatus.Errorf(grpccodes.InvalidArgument, "volume %v is not encrypted but the request is for an encrypted device", vol.Name)
+	}
+	return nil
+}
+
+// checkMountDevice verifies that the filesystem at the mount path is on the device
+func checkMountDevice(devicePath, mountPath string) error {
+	matches, err := mountDeviceMatches(devicePath, mountPath)
+	if err != nil {
+		return grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+	if !matches {
+		return grpcstatus.Errorf(grpccodes.InvalidArgument, "the device of mount point %v is not expected", mountPath)
+	}
+	return nil
+}
+
+const (
+	// TrimDeviceCheckStrict requires the mount point to be on the volume device itself
+	TrimDeviceCheckStrict = "strict"
+	// TrimDeviceCheckRelaxed also accepts a mount point on a device layered on the volume device,
+	// e.g. multipath or device mapper. It relies on sysfs to tell the layers apart.
+	TrimDeviceCheckRelaxed = "relaxed"
+	// TrimDeviceCheckSkip does not check the device at all, so a trim may run on whatever
+	// filesystem is mounted at the mount path
+	TrimDeviceCheckSkip = "skip"
+)
+
+// ValidateTrimDeviceCheck checks the trim device check mode, empty means strict
+func ValidateTrimDeviceCheck(mode string) error {
+	switch mode {
+	case "", TrimDeviceCheckStrict, TrimDeviceCheckRelaxed, TrimDeviceCheckSkip:
+		return nil
+	}
+	return fmt.Errorf("invalid trim device check %v, must be one of %v, %v or %v", mode, TrimDeviceCheckStrict, TrimDeviceCheckRelaxed, TrimDeviceCheckSkip)
+}
+
+// checkTrimMountDevice verifies the device of the mount point before a trim as configured by TrimDeviceCheck
+func (s *ShareManagerServer) checkTrimMountDevice(devicePath, mountPath string, log logrus.FieldLogger) error {
+	switch s.options.TrimDeviceCheck {
+	case TrimDeviceCheckSkip:
+		log.Warnf("Skipping the device check of mount point %v before trimming as configured", mountPath)
+		return nil
+	case TrimDeviceCheckRelaxed:
+		matches, err := mountDeviceMatches(devicePath, mountPath)
+		if err != nil {
+			return grpcstatus.Error(grpccodes.Internal, err.Error())
+		}
+		if matches {
+			return nil
+		}
+
+		mnt, err := filesystem.GetMount(mountPath)
+		if err != nil {
+			return grpcstatus.Error(grpccodes.Internal, err.Error())
+		}
+		stacked, err := util.IsDeviceStackedOn(uint64(mnt.DeviceNumber), devicePath)
+		if err != nil {
+			return grpcstatus.Error(grpccodes.Internal, err.Error())
+		}
+		if !stacked {
+			return grpcstatus.Errorf(grpccodes.InvalidArgument, "the device of mount point %v is neither %v nor layered on it", mountPath, devicePath)
+		}
+		log.Warnf("Mount point %v is on a device layered on %v, accepting it for trimming as configured", mountPath, devicePath)
+		return nil
+	}
+	return checkMountDevice(devicePath, mountPath)
+}
+
+// mountDeviceMatches returns true if the filesystem mounted at the mount path is on the device.
+// The device path is resolved first, since the volume device is usually a symlink.
+func mountDeviceMatches(devicePath, mountPath string) (bool, error) {
+	resolvedPath, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return false, err
+	}
+
+	mnt, err := filesystem.GetMount(mountPath)
+	if err != nil {
+		return false, err
+	}
+
+	deviceNumber, err := util.GetDeviceNumber(resolvedPath)
+	if err != nil {
+		return false, err
+	}
+
+	return uint64(mnt.DeviceNumber) == uint64(deviceNumber), nil
+}
+
+// checkDeviceNotShrunk refuses a resize if the device is smaller than the mounted filesystem,
+// which points at a data engine problem. Growing would be a no-op at best and an invalid shrink at worst.
+func checkDeviceNotShrunk(devicePath, mountPath string, log logrus.FieldLogger) error {
+	deviceSize, err := util.GetDeviceSize(devicePath)
+	if err != nil {
+		return grpcstatus.Error(grpccodes.Internal, errors.Wrapf(err, "failed to get size of device %v", devicePath).Error())
+	}
+	// statfs does not count the filesystem metadata, so this is a lower bound of the filesystem size
+	filesystemSize, err := volume.GetFilesystemSize(mountPath)
+	if err != nil {
+		return grpcstatus.Error(grpccodes.Internal, errors.Wrapf(err, "failed to get size of filesystem %v", mountPath).Error())
+	}
+
+	log.Debugf("Device %v is %v bytes, filesystem mounted at %v is at least %v bytes", devicePath, deviceSize, mountPath, filesystemSize)
+	if uint64(deviceSize) < filesystemSize {
+		log.Errorf("Device %v of %v bytes is smaller than the filesystem of %v bytes mounted at %v", devicePath, deviceSize, filesystemSize, mountPath)
+		return grpcstatus.Errorf(grpccodes.FailedPrecondition, "device %v of %v bytes is smaller than the filesystem of %v bytes, refusing to resize", devicePath, deviceSize, filesystemSize)
+	}
+	return nil
+}
+
+func (s *ShareManagerServer) growFilesystem(ctx context.Context, vol volume.Volume, log logrus.FieldLogger) (bool, error) {
+	devicePath := vol.DevicePath(vol.IsEncrypted())
+	mountPath := types.GetMountPath(vol.Name)
+
+	if err := checkMountDevice(devicePath, mountPath); err != nil {
+		return false, err
+	}
+
+	if err := checkDeviceNotShrunk(devicePath, mountPath, log); err != nil {
+		return false, err
+	}
+
+	if s.options.ResizeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.options.ResizeTimeout)
+		defer cancel()
+	}
+
+	log.Infof("Resizing filesystem mounted at %v", mountPath)
+	resized, output, err := volume.ResizeVolume(ctx, devicePath, mountPath, true)
+	if output != "" {
+		log.Infof("Resize output of filesystem mounted at %v:\n%v", mountPath, output)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, toGRPCError(ctx.Err())
+		}
+		return false, grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+
+	if resized {
+		log.Infof("Finished resizing filesystem mounted at %v", mountPath)
+	} else {
+		log.Infof("Filesystem mounted at %v is already at the volume size", mountPath)
+	}
+
+	return resized, nil
+}
+
+// ListExports returns the volume exports found in the nfs server config
+func (s *ShareManagerServer) ListExports(ctx context.Context, req *emptypb.Empty) ([]nfs.Export, error) {
+	s.RLock()
+	defer s.RUnlock()
+
 	exporter, err := nfs.NewExporter(configPath, types.ExportPath)
 	if err != nil {
-		return errors.Wrap(err, "failed to create nfs exporter")
+		return nil, grpcstatus.Error(grpccodes.Internal, errors.Wrap(err, "failed to create nfs exporter").Error())
 	}
 
-	if err := exporter.DeleteExport(vol.Name); err != nil {
-		return errors.Wrap(err, "failed to delete nfs export")
+	exports, err := exporter.ListExports()
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, errors.Wrap(err, "failed to list nfs exports").Error())
 	}
 
-	if err := exporter.ReloadExport(); err != nil {
-		return errors.Wrap(err, "failed to reload nfs export")
+	return exports, nil
+}
+
+// MaxConnections is the client connection limit of the nfs server
+type MaxConnections struct {
+	// MaxConnections is the limit in the nfs server config, zero if it uses the ganesha default
+	MaxConnections int `json:"maxConnections"`
+	// PendingRestart is set if the limit differs from the one the nfs server was started with,
+	// since ganesha only applies it when it restarts
+	PendingRestart bool `json:"pendingRestart"`
+}
+
+// GetMaxConnections returns the client connection limit in the nfs server config
+func (s *ShareManagerServer) GetMaxConnections(ctx context.Context, req *emptypb.Empty) (*MaxConnections, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	exporter, err := nfs.NewExporter(configPath, types.ExportPath)
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, errors.Wrap(err, "failed to create nfs exporter").Error())
+	}
+
+	return s.getMaxConnections(exporter)
+}
+
+type SetMaxConnectionsRequest struct {
+	// MaxConnections is the client connection limit, zero uses the ganesha default
+	MaxConnections int
+}
+
+// SetMaxConnections sets the client connection limit in the nfs server config. The limit is part
+// of the core config, which ganesha only applies when it restarts, so the returned limit is
+// pending until then.
+func (s *ShareManagerServer) SetMaxConnections(ctx context.Context, req *SetMaxConnectionsRequest) (*MaxConnections, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	maxConnections := req.MaxConnections
+	if err := nfs.ValidateMaxConnections(maxConnections); err != nil {
+		return nil, grpcstatus.Error(grpccodes.InvalidArgument, err.Error())
+	}
+
+	exporter, err := nfs.NewExporter(configPath, types.ExportPath)
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, errors.Wrap(err, "failed to create nfs exporter").Error())
+	}
+
+	s.logger.Infof("Setting max client connections of the nfs server to %v, it takes effect when the nfs server restarts", maxConnections)
+	if err := exporter.SetMaxConnections(maxConnections); err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+
+	return s.getMaxConnections(exporter)
+}
+
+func (s *ShareManagerServer) getMaxConnections(exporter *nfs.Exporter) (*MaxConnections, error) {
+	maxConnections, err := exporter.GetMaxConnections()
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+
+	return &MaxConnections{
+		MaxConnections: maxConnections,
+		PendingRestart: maxConnections != s.startMaxConnections,
+	}, nil
+}
+
+func (s *ShareManagerServer) unexport(vol volume.Volume) error {
+	exporter, err := s.host.newExporter()
+	if err != nil {
+		return errors.Wrap(err, "failed to create nfs exporter")
+	}
+
+	if err := exporter.DeleteExportAndReload(vol.Name); err != nil {
+		return errors.Wrap(err, "failed to delete nfs export")
 	}
 
 	return nil
@@ -134,8 +721,7 @@ func (s *ShareManagerServer) unexport(vol volume.Volume) error {
 func (s *ShareManagerServer) unmount(vol volume.Volume) error {
 	mountPath := types.GetMountPath(vol.Name)
 
-	mounter := mount.New("")
-	isMountPoint, err := mounter.IsMountPoint(mountPath)
+	isMountPoint, err := s.host.isMountPoint(mountPath)
 	if err != nil {
 		return errors.Wrapf(err, "failed to check mount point %v", mountPath)
 	}
@@ -143,13 +729,17 @@ func (s *ShareManagerServer) unmount(vol volume.Volume) error {
 		return nil
 	}
 
-	return volume.UnmountVolume(mountPath)
+	return s.host.unmount(mountPath, s.options.UnmountTimeout)
 }
 
 func (s *ShareManagerServer) Unmount(ctx context.Context, req *emptypb.Empty) (resp *emptypb.Empty, err error) {
 	s.Lock()
 	defer s.Unlock()
 
+	if err := s.checkMaintenanceMode(); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
 	vol := s.manager.GetVolume()
 	if vol.Name == "" {
 		s.logger.Warn("Volume name is missing")
@@ -158,23 +748,53 @@ func (s *ShareManagerServer) Unmount(ctx context.Context, req *emptypb.Empty) (r
 
 	log := s.logger.WithField("volume", vol.Name)
 
-	if !nfsServerIsRunning() {
-		log.Info("NFS server is not running, skip unexporting and unmounting volume")
+	prevState := s.manager.GetState()
+	if prevState == server.StateUnmounted && !s.checkMountValid(types.GetMountPath(vol.Name)) {
+		log.Info("Volume is already unmounted")
 		return &emptypb.Empty{}, nil
 	}
 
+	if err := s.manager.TransitionState(server.StateUnmounting); err != nil {
+		return &emptypb.Empty{}, newReasonError(grpccodes.FailedPrecondition, ReasonInvalidTransition, vol.Name, err.Error())
+	}
+
+	if err := runHook(ctx, "pre-unmount", s.options.PreUnmountHook, vol, log); err != nil {
+		log.WithError(err).Error("Aborting unmount after pre-unmount hook failure")
+		s.recordError("Unmount", vol.Name, err)
+		s.manager.SettleState(prevState)
+		return nil, toGRPCError(err)
+	}
+
 	// Blindly mark the volume as unexported, even if the unmount fails.
 	// Mount() will re-export the volume and mark it as exported if needed.
+	exported := s.manager.ShareIsExported()
 	s.manager.SetShareExported(false)
 
 	defer func() {
 		if err != nil {
 			log.WithError(err).Errorf("Failed to unexport and unmount volume")
+			s.recordError("Unmount", vol.Name, err)
+			s.manager.SettleState(prevState)
+			return
 		}
+		s.manager.SettleState(server.StateUnmounted)
 	}()
 
-	log.Info("Unexporting volume")
-	err = s.unexport(vol)
+	s.syncFilesystem(ctx, vol, log)
+
+	// A volume mounted without the nfs server has no export to remove, and there is no server to reload
+	if nfsServerErr := s.host.checkNFSServerRunning(); nfsServerErr != nil && !exported {
+		log.WithError(nfsServerErr).Warn("NFS server is not running and the volume is not exported, skip unexporting volume")
+	} else {
+		log.Info("Unexporting volume")
+		err = s.unexport(vol)
+		if err != nil {
+			return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+		}
+	}
+
+	log.Info("Removing read only bind mounts")
+	err = s.removeBindMounts()
 	if err != nil {
 		return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
 	}
@@ -183,40 +803,153 @@ func (s *ShareManagerServer) Unmount(ctx context.Context, req *emptypb.Empty) (r
 	for i := 0; i < unmountRetryCount; i++ {
 		err = s.unmount(vol)
 		if err != nil && strings.Contains(err.Error(), "target is busy") {
-			time.Sleep(unmountRetryInterval)
-			continue
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-time.After(unmountRetryInterval):
+				continue
+			}
 		}
 		break
 	}
+	if err != nil && strings.Contains(err.Error(), "target is busy") {
+		return nil, newReasonError(grpccodes.Internal, ReasonTargetBusy, vol.Name, err.Error())
+	}
 	if err != nil {
-		return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+		return nil, toGRPCError(err)
 	}
 
 	log.Info("Volume is unexported and unmounted")
+	s.emitEvent(EventTypeUnexported)
 
 	return &emptypb.Empty{}, nil
 }
 
-func (s *ShareManagerServer) mount(vol volume.Volume, devicePath, mountPath string) error {
-	if err := s.manager.MountVolume(s.manager.GetVolume(), devicePath, mountPath); err != nil {
+// syncFilesystem flushes dirty data before the volume is unmounted, e.g. on failover.
+// It is best effort and gives up after syncTimeout, so a hung sync does not block the unmount.
+func (s *ShareManagerServer) syncFilesystem(ctx context.Context, vol volume.Volume, log logrus.FieldLogger) {
+	mountPath := types.GetMountPath(vol.Name)
+	if !s.checkMountValid(mountPath) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := volume.SyncFilesystem(ctx, mountPath); err != nil {
+		log.WithError(err).Warnf("Failed to sync filesystem mounted at %v after %v", mountPath, time.Since(start))
+		return
+	}
+	log.Infof("Synced filesystem mounted at %v in %v", mountPath, time.Since(start))
+}
+
+func (s *ShareManagerServer) mount(ctx context.Context, vol volume.Volume, devicePath, mountPath string) error {
+	if s.options.MountTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.options.MountTimeout)
+		defer cancel()
+	}
+
+	if err := s.manager.MountVolume(ctx, s.manager.GetVolume(), devicePath, mountPath); err != nil {
 		return errors.Wrapf(err, "failed to mount volume %v", vol.Name)
 	}
 
 	return nil
 }
 
-func (s *ShareManagerServer) export(vol volume.Volume) error {
-	exporter, err := nfs.NewExporter(configPath, types.ExportPath)
+// waitForDevice polls the device until it is valid or DeviceWaitTimeout has passed
+func (s *ShareManagerServer) waitForDevice(ctx context.Context, devicePath string) error {
+	deadline := time.Now().Add(s.options.DeviceWaitTimeout)
+	for {
+		if volume.CheckDeviceValid(devicePath) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return newReasonError(grpccodes.Unavailable, ReasonDeviceNotValid, s.manager.GetVolume().Name, "device %v is not valid after %v", devicePath, s.options.DeviceWaitTimeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return toGRPCError(ctx.Err())
+		case <-time.After(deviceWaitInterval):
+		}
+	}
+}
+
+// export creates the export of the volume, retrying with backoff for up to ExportRetryTimeout
+// while the nfs server fails transiently. A failed attempt leaves the config rolled back.
+func (s *ShareManagerServer) export(ctx context.Context, vol volume.Volume) error {
+	if s.exportIsLoaded(ctx, vol) {
+		s.logger.WithField("volume", vol.Name).Info("Volume is already exported, skipping export and reload")
+		return nil
+	}
+
+	log := s.logger.WithField("volume", vol.Name)
+	return retryTransient(ctx, s.options.ExportRetryTimeout, log, func() error {
+		return s.createExport(vol)
+	})
+}
+
+// retryTransient runs the export creation, retrying it with backoff for up to the timeout while
+// it fails with a transient nfs server error. Any other error is returned right away.
+func retryTransient(ctx context.Context, timeout time.Duration, log logrus.FieldLogger, create func() error) error {
+	deadline := time.Now().Add(timeout)
+	interval := exportRetryInitialInterval
+	for attempt := 1; ; attempt++ {
+		err := create()
+		if err == nil || !nfs.IsTransientError(err) || time.Now().Add(interval).After(deadline) {
+			return err
+		}
+
+		log.WithError(err).Warnf("Failed to create export in attempt %v, retrying in %v", attempt, interval)
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "failed to create nfs export")
+		case <-time.After(interval):
+		}
+		interval = min(2*interval, exportRetryMaxInterval)
+	}
+}
+
+// exportIsLoaded reports whether an identical export of the volume is in the config and loaded by
+// the nfs server, e.g. when the controller retries Mount while the exported flag is stale, so it
+// does not need to be created again with a disruptive reload
+func (s *ShareManagerServer) exportIsLoaded(ctx context.Context, vol volume.Volume) bool {
+	exporter, err := s.host.newExporter()
 	if err != nil {
-		return errors.Wrap(err, "failed to create nfs exporter")
+		return false
 	}
 
-	if _, err := exporter.CreateExport(vol.Name); err != nil {
-		return errors.Wrap(err, "failed to delete nfs export")
+	exists, err := exporter.HasExport(vol.Name, s.manager.GetExportOptions())
+	if err != nil || !exists {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, listExportsTimeout)
+	defer cancel()
+
+	ids, err := s.host.listRunningExportIDs(ctx)
+	return runningExportLoaded(exporter.GetExport(vol.Name), ids, err)
+}
+
+// runningExportLoaded reports whether the export id is among the ids loaded by the nfs server. The
+// config may hold an export the nfs server has not loaded, it is trusted if this cannot be checked.
+func runningExportLoaded(id uint16, running []uint16, err error) bool {
+	if errors.Is(err, nfs.ErrManagementUnavailable) {
+		return true
+	}
+	return err == nil && slices.Contains(running, id)
+}
+
+func (s *ShareManagerServer) createExport(vol volume.Volume) error {
+	exporter, err := s.host.newExporter()
+	if err != nil {
+		return errors.Wrap(err, "failed to create nfs exporter")
 	}
 
-	if err := exporter.ReloadExport(); err != nil {
-		return errors.Wrap(err, "failed to reload nfs export")
+	if _, err := exporter.CreateExportAndReload(vol.Name, s.manager.GetExportOptions()); err != nil {
+		return errors.Wrap(err, "failed to create nfs export")
 	}
 
 	return nil
@@ -226,6 +959,10 @@ func (s *ShareManagerServer) Mount(ctx context.Context, req *emptypb.Empty) (res
 	s.Lock()
 	defer s.Unlock()
 
+	if err := s.checkMaintenanceMode(); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
 	vol := s.manager.GetVolume()
 	if vol.Name == "" {
 		s.logger.Warn("Volume name is missing")
@@ -234,24 +971,45 @@ func (s *ShareManagerServer) Mount(ctx context.Context, req *emptypb.Empty) (res
 
 	log := s.logger.WithField("volume", vol.Name)
 
-	if !nfsServerIsRunning() {
-		log.Info("NFS server is not running, skip mounting and exporting volume")
+	nfsServerErr := s.host.checkNFSServerRunning()
+	nfsServerRunning := nfsServerErr == nil
+	if !nfsServerRunning && !s.options.MountWithoutNFSServer {
+		log.WithError(nfsServerErr).Warn("NFS server is not running, skip mounting and exporting volume")
 		return &emptypb.Empty{}, nil
 	}
 
-	if s.manager.ShareIsExported() {
-		return &emptypb.Empty{}, nil
+	devicePath := vol.DevicePath(false)
+	mountPath := types.GetMountPath(vol.Name)
+
+	if s.manager.GetState() == server.StateMounted {
+		if s.checkMountValid(mountPath) {
+			return s.remount(ctx, vol, nfsServerRunning, log)
+		}
+
+		// The mount can be lost underneath us, e.g. after node pressure, while the state is stale
+		log.Warnf("Volume is marked as mounted but %v is not mounted, remounting and re-exporting", mountPath)
+		s.manager.SetShareExported(false)
+		if err := s.manager.TransitionState(server.StateUnmounting); err != nil {
+			return &emptypb.Empty{}, newReasonError(grpccodes.FailedPrecondition, ReasonInvalidTransition, vol.Name, err.Error())
+		}
+		s.manager.SettleState(server.StateUnmounted)
 	}
 
-	log.Info("Mounting and exporting volume")
+	prevState := s.manager.GetState()
+	if err := s.manager.TransitionState(server.StateMounting); err != nil {
+		return &emptypb.Empty{}, newReasonError(grpccodes.FailedPrecondition, ReasonInvalidTransition, vol.Name, err.Error())
+	}
 
-	devicePath := types.GetVolumeDevicePath(vol.Name, false)
-	mountPath := types.GetMountPath(vol.Name)
+	log.Info("Mounting and exporting volume")
 
 	defer func() {
 		if err != nil {
 			log.WithError(err).Errorf("Failed to mount and export volume")
+			s.recordError("Mount", vol.Name, err)
+			s.manager.SettleState(prevState)
+			return
 		}
+		s.manager.SettleState(server.StateMounted)
 	}()
 
 	mounter := mount.New("")
@@ -260,26 +1018,118 @@ func (s *ShareManagerServer) Mount(ctx context.Context, req *emptypb.Empty) (res
 		err = errors.Wrapf(err, "failed to check mount point %v", mountPath)
 		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.Internal, err.Error())
 	}
-	if !isMountPoint {
+	if isMountPoint {
+		// something else mounted over the mount path must not be exported
+		err = checkExistingMount(vol.DevicePath(vol.IsEncrypted()), mountPath)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = s.waitForDevice(ctx, devicePath)
+		if err != nil {
+			return nil, err
+		}
+
+		// the crypto device has to be opened, or set up again after a wipe, so the filesystem is
+		// never created on the raw device of an encrypted volume
+		devicePath, err = s.manager.SetupDevice(devicePath)
+		if err != nil {
+			return nil, toGRPCError(err)
+		}
+
 		log.Info("Mounting volume")
-		err = s.mount(vol, devicePath, mountPath)
+		err = s.mount(ctx, vol, devicePath, mountPath)
 		if err != nil {
-			return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+			return nil, toGRPCError(err)
 		}
+
+		// do not leave a mount behind that this call created but could not export
+		defer undoOnError(&err, log, "unmount volume after failed export", func() error {
+			return s.unmount(vol)
+		})
 	}
 
-	log.Info("Exporting volume")
-	err = s.export(vol)
+	if !nfsServerRunning {
+		log.Info("NFS server is not running, volume is mounted but not exported")
+		return &emptypb.Empty{}, nil
+	}
+
+	err = s.exportMounted(ctx, vol, log)
 	if err != nil {
-		return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+		return nil, err
 	}
+	return &emptypb.Empty{}, nil
+}
 
-	log.Info("Volume is mounted and exported")
-	s.manager.SetShareExported(true)
+// remount handles a Mount of a mounted volume without a state transition. The volume is only
+// exported if that is still missing, e.g. since it was mounted while the nfs server was not running.
+func (s *ShareManagerServer) remount(ctx context.Context, vol volume.Volume, nfsServerRunning bool, log logrus.FieldLogger) (*emptypb.Empty, error) {
+	if s.manager.ShareIsExported() {
+		return &emptypb.Empty{}, nil
+	}
+	if !nfsServerRunning {
+		log.Info("NFS server is not running, keeping volume mounted without exporting it")
+		return &emptypb.Empty{}, nil
+	}
 
+	if err := s.exportMounted(ctx, vol, log); err != nil {
+		log.WithError(err).Error("Failed to export mounted volume")
+		s.recordError("Mount", vol.Name, err)
+		return nil, err
+	}
 	return &emptypb.Empty{}, nil
 }
 
+// exportMounted exports the mounted volume and runs the post-mount hook, the export is removed
+// again if the hook fails
+func (s *ShareManagerServer) exportMounted(ctx context.Context, vol volume.Volume, log logrus.FieldLogger) error {
+	log.Info("Exporting volume")
+	if err := s.export(ctx, vol); err != nil {
+		return toGRPCError(err)
+	}
+
+	if err := runHook(ctx, "post-mount", s.options.PostMountHook, vol, log); err != nil {
+		if unexportErr := s.unexport(vol); unexportErr != nil {
+			log.WithError(unexportErr).Warn("Failed to unexport volume after post-mount hook failure")
+		}
+		return toGRPCError(err)
+	}
+
+	s.manager.SetShareExported(true)
+
+	log.Info("Volume is mounted and exported")
+	s.emitEvent(EventTypeExported)
+	return nil
+}
+
+// undoOnError runs the undo function if the error is set once the caller returns, so a step the
+// caller completed is not left behind by a later failing one. The error of the caller is kept,
+// a failed undo is only logged.
+func undoOnError(err *error, log logrus.FieldLogger, action string, undo func() error) {
+	if *err == nil {
+		return
+	}
+	log.Infof("Trying to %v", action)
+	if undoErr := undo(); undoErr != nil {
+		log.WithError(undoErr).Warnf("Failed to %v", action)
+	}
+}
+
+// checkExistingMount verifies that the filesystem already mounted at the mount path is on the
+// volume device, so a foreign mount over the mount path is not exported
+func checkExistingMount(expectedDevicePath, mountPath string) error {
+	matches, err := mountDeviceMatches(expectedDevicePath, mountPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to check the device of mount point %v", mountPath)
+		return grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+	if !matches {
+		err = fmt.Errorf("mount point %v is not on the volume device %v", mountPath, expectedDevicePath)
+		return grpcstatus.Error(grpccodes.FailedPrecondition, err.Error())
+	}
+	return nil
+}
+
 type ShareManagerHealthCheckServer struct {
 	srv *ShareManagerServer
 }
@@ -292,6 +1142,12 @@ func NewShareManagerHealthCheckServer(srv *ShareManagerServer) *ShareManagerHeal
 
 func (s *ShareManagerHealthCheckServer) Check(context.Context, *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
 	if s.srv != nil {
+		if reason := s.srv.degradedReason(); reason != "" {
+			return &healthpb.HealthCheckResponse{
+				Status: healthpb.HealthCheckResponse_NOT_SERVING,
+			}, fmt.Errorf("share manager volume is degraded: %v", reason)
+		}
+
 		return &healthpb.HealthCheckResponse{
 			Status: healthpb.HealthCheckResponse_SERVING,
 		}, nil
@@ -304,7 +1160,7 @@ func (s *ShareManagerHealthCheckServer) Check(context.Context, *healthpb.HealthC
 
 func (s *ShareManagerHealthCheckServer) Watch(req *healthpb.HealthCheckRequest, ws healthpb.Health_WatchServer) error {
 	for {
-		if s.srv != nil {
+		if s.srv != nil && s.srv.degradedReason() == "" {
 			if err := ws.Send(&healthpb.HealthCheckResponse{
 				Status: healthpb.HealthCheckResponse_SERVING,
 			}); err != nil {
@@ -324,7 +1180,61 @@ func (s *ShareManagerHealthCheckServer) Watch(req *healthpb.HealthCheckRequest,
 	}
 }
 
+// toGRPCError maps context and known errors to their gRPC codes and any other error to Internal
+func toGRPCError(err error) error {
+	switch {
+	case errors.Is(err, nfs.ErrExportIDInUse), errors.Is(err, nfs.ErrFilesystemIDInUse), errors.Is(err, nfs.ErrExportConflict),
+		errors.Is(err, nfs.ErrPseudoPathInUse):
+		return grpcstatus.Error(grpccodes.AlreadyExists, err.Error())
+	case errors.Is(err, volume.ErrDeviceNotReady):
+		return newReasonError(grpccodes.Unavailable, ReasonDeviceNotValid, "", err.Error())
+	case errors.Is(err, volume.ErrFilesystemCorrupt):
+		return newReasonError(grpccodes.DataLoss, ReasonFilesystemCorrupt, "", err.Error())
+	case errors.Is(err, volume.ErrFingerprintMismatch):
+		return grpcstatus.Error(grpccodes.FailedPrecondition, err.Error())
+	case errors.Is(err, crypto.ErrWeakPassphrase):
+		return grpcstatus.Error(grpccodes.InvalidArgument, err.Error())
+	case errors.Is(err, nfs.ErrConfigRejected):
+		return newReasonError(grpccodes.FailedPrecondition, ReasonConfigRejected, "", err.Error())
+	case errors.Is(err, nfs.ErrManagementUnavailable):
+		return grpcstatus.Error(grpccodes.Unavailable, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return grpcstatus.Error(grpccodes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return grpcstatus.Error(grpccodes.Canceled, err.Error())
+	}
+	return grpcstatus.Error(grpccodes.Internal, err.Error())
+}
+
 func nfsServerIsRunning() bool {
-	_, err := util.FindProcessByName("ganesha.nfsd")
-	return err == nil
+	return checkNFSServerRunning() == nil
+}
+
+// checkNFSServerRunning returns nil if the nfs server process is running, otherwise an error
+// telling whether it has never been started, has exited or runs under another name
+func checkNFSServerRunning() error {
+	_, err := util.FindProcessByName(nfs.ProcessName)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, util.ErrProcessNotFound) {
+		return errors.Wrap(err, "failed to look up the nfs server process")
+	}
+
+	pid, err := nfs.ReadServerPid()
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%v is not running and has no pid file, it has not been started or has shut down", nfs.ProcessName)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "%v is not running", nfs.ProcessName)
+	}
+
+	executable, err := util.GetProcessExecutable(pid)
+	if err != nil {
+		return errors.Wrapf(err, "%v is not running", nfs.ProcessName)
+	}
+	if executable != "" {
+		return fmt.Errorf("%v is not running, pid %v of its pid file belongs to %v", nfs.ProcessName, pid, executable)
+	}
+	return fmt.Errorf("%v is not running, pid %v of its pid file has exited", nfs.ProcessName, pid)
 }
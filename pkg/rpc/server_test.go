@@ -0,0 +1,562 @@
+package rpc
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+// rootDevice returns the block device mounted at /, empty if it has no device node
+func rootDevice(t *testing.T) string {
+	t.Helper()
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// the fields after the separator are the filesystem type and the mount source
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[4] != "/" {
+			continue
+		}
+		for i, field := range fields {
+			if field == "-" && i+2 < len(fields) {
+				if info, err := os.Stat(fields[i+2]); err == nil && info.Mode()&os.ModeDevice != 0 {
+					return fields[i+2]
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func TestMountDeviceMatches(t *testing.T) {
+	dir := t.TempDir()
+	symlink := func(name, target string) string {
+		path := filepath.Join(dir, name)
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("symlink to another device", func(t *testing.T) {
+		matches, err := mountDeviceMatches(symlink("other", "/dev/null"), "/")
+		if err != nil || matches {
+			t.Fatalf("expected no match, got %v, %v", matches, err)
+		}
+	})
+
+	t.Run("broken symlink", func(t *testing.T) {
+		if _, err := mountDeviceMatches(symlink("broken", filepath.Join(dir, "missing")), "/"); err == nil {
+			t.Fatal("expected an error for a broken symlink")
+		}
+	})
+
+	t.Run("symlink to the mounted device", func(t *testing.T) {
+		device := rootDevice(t)
+		if device == "" {
+			t.Skip("the root filesystem has no device node")
+		}
+		matches, err := mountDeviceMatches(symlink("root", device), "/")
+		if err != nil || !matches {
+			t.Fatalf("expected a match for %v, got %v, %v", device, matches, err)
+		}
+	})
+}
+
+func TestUndoOnError(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	tests := []struct {
+		name    string
+		err     error
+		undoErr error
+		undone  bool
+	}{
+		{name: "success", err: nil},
+		{name: "failure", err: errors.New("export failed"), undone: true},
+		{name: "failed undo", err: errors.New("export failed"), undoErr: errors.New("unmount failed"), undone: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			undone := false
+			run := func() (err error) {
+				defer undoOnError(&err, log, "unmount volume", func() error {
+					undone = true
+					return tt.undoErr
+				})
+				return tt.err
+			}
+
+			if err := run(); err != tt.err {
+				t.Fatalf("expected the error of the caller %v, got %v", tt.err, err)
+			}
+			if undone != tt.undone {
+				t.Fatalf("expected undone %v, got %v", tt.undone, undone)
+			}
+		})
+	}
+}
+
+func TestCheckEncryptedFormat(t *testing.T) {
+	tests := []struct {
+		name            string
+		volume          volume.Volume
+		diskFormat      string
+		encryptedDevice bool
+		invalid         bool
+	}{
+		{name: "plain volume", volume: volume.Volume{Name: "pvc-1"}, diskFormat: "ext4"},
+		{name: "unformatted plain volume", volume: volume.Volume{Name: "pvc-1"}, diskFormat: ""},
+		{name: "encrypted volume", volume: volume.Volume{Name: "pvc-1"}, diskFormat: "luks", encryptedDevice: true},
+		{
+			name:            "detached header",
+			volume:          volume.Volume{Name: "pvc-1", CryptoHeaderPath: "/var/lib/longhorn/pvc-1.header"},
+			diskFormat:      "",
+			encryptedDevice: true,
+		},
+		{name: "raw device of an encrypted volume", volume: volume.Volume{Name: "pvc-1"}, diskFormat: "luks", invalid: true},
+		{name: "crypto device of a plain volume", volume: volume.Volume{Name: "pvc-1"}, diskFormat: "ext4", encryptedDevice: true, invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkEncryptedFormat(tt.volume, tt.diskFormat, tt.encryptedDevice)
+			if invalid := err != nil; invalid != tt.invalid {
+				t.Fatalf("expected invalid %v, got %v", tt.invalid, err)
+			}
+			if err != nil && grpcstatus.Code(err) != grpccodes.InvalidArgument {
+				t.Fatalf("expected code %v, got %v", grpccodes.InvalidArgument, err)
+			}
+		})
+	}
+}
+
+func TestCheckExistingMount(t *testing.T) {
+	tests := []struct {
+		name       string
+		devicePath string
+		code       grpccodes.Code
+	}{
+		{name: "foreign mount", devicePath: "/dev/null", code: grpccodes.FailedPrecondition},
+		{name: "missing device", devicePath: filepath.Join(t.TempDir(), "missing"), code: grpccodes.Internal},
+		{name: "mount on the volume device", devicePath: rootDevice(t), code: grpccodes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.devicePath == "" {
+				t.Skip("the root filesystem has no device node")
+			}
+			if code := grpcstatus.Code(checkExistingMount(tt.devicePath, "/")); code != tt.code {
+				t.Fatalf("expected code %v, got %v", tt.code, code)
+			}
+		})
+	}
+}
+
+func TestCheckTrimMountDevice(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	for _, mode := range []string{"", TrimDeviceCheckStrict, TrimDeviceCheckRelaxed, TrimDeviceCheckSkip} {
+		if err := ValidateTrimDeviceCheck(mode); err != nil {
+			t.Errorf("expected mode %q to be valid, got %v", mode, err)
+		}
+	}
+	if err := ValidateTrimDeviceCheck("none"); err == nil {
+		t.Error("expected an unknown mode to be invalid")
+	}
+
+	tests := []struct {
+		mode string
+		code grpccodes.Code
+	}{
+		{mode: "", code: grpccodes.InvalidArgument},
+		{mode: TrimDeviceCheckStrict, code: grpccodes.InvalidArgument},
+		// /dev/null is no block device, so it cannot be found in sysfs
+		{mode: TrimDeviceCheckRelaxed, code: grpccodes.Internal},
+		{mode: TrimDeviceCheckSkip, code: grpccodes.OK},
+	}
+
+	for _, tt := range tests {
+		s := &ShareManagerServer{options: ServerOptions{TrimDeviceCheck: tt.mode}}
+		if code := grpcstatus.Code(s.checkTrimMountDevice("/dev/null", "/", log)); code != tt.code {
+			t.Errorf("mode %q: expected code %v, got %v", tt.mode, tt.code, code)
+		}
+	}
+}
+
+func TestResizesCryptoDevice(t *testing.T) {
+	plain := volume.Volume{Name: "pvc-1"}
+	encrypted := volume.Volume{Name: "pvc-1", Passphrase: "secret"}
+
+	tests := []struct {
+		name     string
+		volume   volume.Volume
+		req      *FilesystemResizeRequest
+		expected bool
+	}{
+		{name: "plain volume", volume: plain, req: &FilesystemResizeRequest{}},
+		{name: "encrypted volume", volume: encrypted, req: &FilesystemResizeRequest{}, expected: true},
+		{name: "encrypted volume skipping the crypto device", volume: encrypted, req: &FilesystemResizeRequest{SkipCryptoResize: true}},
+	}
+
+	for _, tt := range tests {
+		if resizes := resizesCryptoDevice(tt.volume, tt.req); resizes != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.name, tt.expected, resizes)
+		}
+	}
+}
+
+func TestCheckDeviceNotShrunk(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	// the temp directory stands in for the mount point, a sparse file for the device
+	mountPath := t.TempDir()
+	filesystemSize, err := volume.GetFilesystemSize(mountPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		deviceSize int64
+		code       grpccodes.Code
+	}{
+		{name: "device larger than the filesystem", deviceSize: int64(filesystemSize) + 1<<20, code: grpccodes.OK},
+		{name: "device smaller than the filesystem", deviceSize: 1 << 20, code: grpccodes.FailedPrecondition},
+		{name: "missing device", deviceSize: -1, code: grpccodes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devicePath := filepath.Join(t.TempDir(), "device")
+			if tt.deviceSize >= 0 {
+				if err := os.WriteFile(devicePath, nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Truncate(devicePath, tt.deviceSize); err != nil {
+					t.Skipf("cannot create a sparse device of %v bytes: %v", tt.deviceSize, err)
+				}
+			}
+
+			if code := grpcstatus.Code(checkDeviceNotShrunk(devicePath, mountPath, log)); code != tt.code {
+				t.Fatalf("expected code %v, got %v", tt.code, code)
+			}
+		})
+	}
+}
+
+func TestStaleMountReason(t *testing.T) {
+	device := rootDevice(t)
+	if device == "" {
+		t.Skip("the root filesystem has no device node")
+	}
+
+	tests := []struct {
+		name       string
+		devicePath string
+		mountPath  string
+		stale      bool
+		invalid    bool
+	}{
+		{name: "mount of the device", devicePath: device, mountPath: "/"},
+		{name: "mount of another device", devicePath: device, mountPath: "/proc", stale: true},
+		{name: "device is gone", devicePath: filepath.Join(t.TempDir(), "missing"), mountPath: "/", stale: true},
+		{name: "missing mount point", devicePath: device, mountPath: filepath.Join(t.TempDir(), "missing"), invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, err := staleMountReason(tt.devicePath, tt.mountPath)
+			if invalid := err != nil; invalid != tt.invalid {
+				t.Fatalf("expected invalid %v, got %v", tt.invalid, err)
+			}
+			if stale := reason != ""; stale != tt.stale {
+				t.Fatalf("expected stale %v, got reason %q", tt.stale, reason)
+			}
+		})
+	}
+}
+
+func TestDeviceExpanded(t *testing.T) {
+	const gib = 1 << 30
+
+	tests := []struct {
+		name        string
+		size        int64
+		mountedSize int64
+		grownSize   int64
+		expected    bool
+	}{
+		{name: "mounted size unknown", size: gib, expected: true},
+		{name: "device not expanded yet", size: gib, mountedSize: gib},
+		{name: "device expanded", size: 2 * gib, mountedSize: gib, expected: true},
+		{name: "repeated resize", size: 2 * gib, mountedSize: gib, grownSize: 2 * gib, expected: true},
+		{name: "grown at mount", size: gib, mountedSize: gib, grownSize: gib, expected: true},
+	}
+
+	for _, tt := range tests {
+		if expanded := deviceExpanded(tt.size, tt.mountedSize, tt.grownSize); expanded != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.name, tt.expected, expanded)
+		}
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	transient := errors.Join(errors.New("process is not running"), nfs.ErrReloadFailed)
+	permanent := nfs.ErrExportIDInUse
+
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		errs     []error
+		attempts int
+		expected error
+	}{
+		{name: "success", timeout: time.Second, errs: []error{nil}, attempts: 1},
+		{name: "transient error", timeout: time.Second, errs: []error{transient, nil}, attempts: 2},
+		{name: "permanent error", timeout: time.Second, errs: []error{permanent}, attempts: 1, expected: permanent},
+		{name: "no retry without a timeout", timeout: 0, errs: []error{transient}, attempts: 1, expected: transient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := retryTransient(context.Background(), tt.timeout, log, func() error {
+				err := tt.errs[min(attempts, len(tt.errs)-1)]
+				attempts++
+				return err
+			})
+			if err != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, err)
+			}
+			if attempts != tt.attempts {
+				t.Fatalf("expected %v attempts, got %v", tt.attempts, attempts)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := retryTransient(ctx, time.Minute, log, func() error { return transient })
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected %v, got %v", context.Canceled, err)
+		}
+	})
+}
+
+func TestRunningExportLoaded(t *testing.T) {
+	tests := []struct {
+		name     string
+		running  []uint16
+		err      error
+		expected bool
+	}{
+		{name: "loaded", running: []uint16{0, 1, 2}, expected: true},
+		{name: "lost", running: []uint16{0, 2}},
+		{name: "management unavailable", err: nfs.ErrManagementUnavailable, expected: true},
+		{name: "listing failed", err: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if loaded := runningExportLoaded(1, tt.running, tt.err); loaded != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, loaded)
+			}
+		})
+	}
+}
+
+// fakeExporter is an nfs export config in memory, it counts the config writes and reloads
+type fakeExporter struct {
+	exports map[string]nfs.ExportOptions
+	writes  int
+	reloads int
+}
+
+func (e *fakeExporter) GetExport(volume string) uint16 {
+	if _, ok := e.exports[volume]; ok {
+		return 1
+	}
+	return 0
+}
+
+func (e *fakeExporter) HasExport(volume string, options nfs.ExportOptions) (bool, error) {
+	existing, ok := e.exports[volume]
+	return ok && reflect.DeepEqual(existing, options), nil
+}
+
+func (e *fakeExporter) CreateExportAndReload(volume string, options nfs.ExportOptions) (uint16, error) {
+	e.exports[volume] = options
+	e.writes++
+	e.reloads++
+	return 1, nil
+}
+
+func (e *fakeExporter) DeleteExportAndReload(volume string) error {
+	delete(e.exports, volume)
+	e.writes++
+	e.reloads++
+	return nil
+}
+
+// fakeHost is a share manager pod with the fake exporter and the listed mount points
+type fakeHost struct {
+	nfsServerErr error
+	exporter     *fakeExporter
+	mounts       map[string]bool
+}
+
+func newFakeHost(nfsServerErr error, mounts ...string) *fakeHost {
+	h := &fakeHost{
+		nfsServerErr: nfsServerErr,
+		exporter:     &fakeExporter{exports: map[string]nfs.ExportOptions{}},
+		mounts:       map[string]bool{},
+	}
+	for _, path := range mounts {
+		h.mounts[path] = true
+	}
+	return h
+}
+
+func (h *fakeHost) checkNFSServerRunning() error {
+	return h.nfsServerErr
+}
+
+func (h *fakeHost) newExporter() (volumeExporter, error) {
+	return h.exporter, nil
+}
+
+func (h *fakeHost) listRunningExportIDs(ctx context.Context) ([]uint16, error) {
+	ids := []uint16{}
+	for volume := range h.exporter.exports {
+		ids = append(ids, h.exporter.GetExport(volume))
+	}
+	return ids, nil
+}
+
+func (h *fakeHost) isMountPoint(path string) (bool, error) {
+	return h.mounts[path], nil
+}
+
+func (h *fakeHost) unmount(path string, timeout time.Duration) error {
+	delete(h.mounts, path)
+	return nil
+}
+
+// newMountedTestServer returns a server with the volume mounted on the host
+func newMountedTestServer(t *testing.T, host *fakeHost) *ShareManagerServer {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	manager, err := server.NewShareManager(log, volume.Volume{Name: "test-volume"}, nfs.ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.TransitionState(server.StateMounting); err != nil {
+		t.Fatal(err)
+	}
+	manager.SettleState(server.StateMounted)
+
+	s := NewShareManagerServer(manager, ServerOptions{})
+	s.logger = log
+	s.host = host
+	return s
+}
+
+func TestUnmount(t *testing.T) {
+	mountPath := types.GetMountPath("test-volume")
+
+	tests := []struct {
+		name         string
+		nfsServerErr error
+		exported     bool
+		writes       int
+	}{
+		{name: "exported", exported: true, writes: 1},
+		{name: "mounted without NFS server", nfsServerErr: errors.New("ganesha.nfsd is not running")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := newFakeHost(tt.nfsServerErr, mountPath)
+			s := newMountedTestServer(t, host)
+			if tt.exported {
+				host.exporter.exports["test-volume"] = s.manager.GetExportOptions()
+				s.manager.SetShareExported(true)
+			}
+
+			if _, err := s.Unmount(context.Background(), &emptypb.Empty{}); err != nil {
+				t.Fatal(err)
+			}
+			if state := s.manager.GetState(); state != server.StateUnmounted {
+				t.Fatalf("expected state %v, got %v", server.StateUnmounted, state)
+			}
+			if host.mounts[mountPath] {
+				t.Fatalf("expected %v to be unmounted", mountPath)
+			}
+			if host.exporter.writes != tt.writes {
+				t.Fatalf("expected %v config writes, got %v", tt.writes, host.exporter.writes)
+			}
+		})
+	}
+}
+
+func TestMountExportsOnce(t *testing.T) {
+	mountPath := types.GetMountPath("test-volume")
+	host := newFakeHost(nil, mountPath)
+	s := newMountedTestServer(t, host)
+
+	if _, err := s.Mount(context.Background(), &emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.manager.ShareIsExported() {
+		t.Fatal("expected the volume to be exported")
+	}
+
+	// the controller retries Mount while the exported flag is stale
+	s.manager.SetShareExported(false)
+	if _, err := s.Mount(context.Background(), &emptypb.Empty{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if host.exporter.writes != 1 || host.exporter.reloads != 1 {
+		t.Fatalf("expected 1 config write and reload, got %v writes and %v reloads", host.exporter.writes, host.exporter.reloads)
+	}
+	if state := s.manager.GetState(); state != server.StateMounted {
+		t.Fatalf("expected state %v, got %v", server.StateMounted, state)
+	}
+}
@@ -0,0 +1,88 @@
+package rpc
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+type ShrinkRequest struct {
+	// TargetBytes is the new size of the filesystem
+	TargetBytes int64
+	// Confirm has to be set, since a failed shrink can lose data
+	Confirm bool
+}
+
+// ShrinkFilesystem shrinks the ext filesystem of the unmounted volume to the target size,
+// e.g. before moving the volume to smaller storage. xfs cannot be shrunk.
+func (s *ShareManagerServer) ShrinkFilesystem(ctx context.Context, req *ShrinkRequest) (resp *emptypb.Empty, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
+	if !req.Confirm {
+		return &emptypb.Empty{}, grpcstatus.Error(grpccodes.InvalidArgument, "shrinking a filesystem requires confirmation")
+	}
+	if req.TargetBytes <= 0 {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.InvalidArgument, "invalid target size %v", req.TargetBytes)
+	}
+
+	vol := s.manager.GetVolume()
+	log := s.logger.WithField("volume", vol.Name)
+
+	mountPath := types.GetMountPath(vol.Name)
+	if state := s.manager.GetState(); state != server.StateUnmounted || volume.CheckMountValid(mountPath) {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.FailedPrecondition, "volume %v has to be unmounted to shrink its filesystem", vol.Name)
+	}
+
+	devicePath := vol.DevicePath(vol.IsEncrypted())
+	if !volume.CheckDeviceValid(devicePath) {
+		return &emptypb.Empty{}, newReasonError(grpccodes.FailedPrecondition, ReasonDeviceNotValid, vol.Name, "volume %v is not valid", vol.Name)
+	}
+
+	defer func() {
+		if err != nil {
+			log.WithError(err).Error("Failed to shrink filesystem")
+			s.recordError("ShrinkFilesystem", vol.Name, err)
+		}
+	}()
+
+	diskFormat, err := volume.GetDiskFormat(devicePath)
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+	if !strings.HasPrefix(diskFormat, "ext") {
+		return nil, grpcstatus.Errorf(grpccodes.FailedPrecondition, "shrinking filesystem %v is not supported", diskFormat)
+	}
+
+	used, total, err := volume.GetExtFilesystemUsage(ctx, devicePath)
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+	if req.TargetBytes >= total {
+		return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "target size %v is not smaller than the filesystem size %v", req.TargetBytes, total)
+	}
+	if req.TargetBytes <= used {
+		return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "target size %v does not fit the %v used bytes", req.TargetBytes, used)
+	}
+
+	log.Infof("Shrinking filesystem from %v to %v bytes, %v bytes are used", total, req.TargetBytes, used)
+	output, err := volume.ShrinkExtFilesystem(ctx, devicePath, req.TargetBytes)
+	log.Infof("Shrink output of filesystem on %v:\n%v", devicePath, output)
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	log.Infof("Shrank filesystem to %v bytes", req.TargetBytes)
+	return &emptypb.Empty{}, nil
+}
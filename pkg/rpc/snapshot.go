@@ -0,0 +1,199 @@
+package rpc
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+var snapshotNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,62}$`)
+
+type ExportSnapshotRequest struct {
+	// Snapshot is the name of the snapshot, it becomes part of the export path
+	Snapshot string
+	// DevicePath is the block device exposing the snapshot
+	DevicePath string
+	// ExportID pins the export id of the snapshot, zero allocates the lowest free id
+	ExportID uint16
+}
+
+type UnexportSnapshotRequest struct {
+	Snapshot string
+}
+
+// SnapshotExport describes a snapshot exported read only next to the volume
+type SnapshotExport struct {
+	Snapshot string `json:"snapshot"`
+	ExportID uint16 `json:"exportID"`
+	Pseudo   string `json:"pseudo"`
+}
+
+// ExportSnapshot mounts the snapshot device read only and exports it as a separate read only
+// export, e.g. for backup tooling. The export keeps the access settings of the volume and gets
+// its own export and filesystem id. UnexportSnapshot removes it again.
+func (s *ShareManagerServer) ExportSnapshot(ctx context.Context, req *ExportSnapshotRequest) (resp *SnapshotExport, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return nil, err
+	}
+
+	if !snapshotNameRegex.MatchString(req.Snapshot) {
+		return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "invalid snapshot name %q", req.Snapshot)
+	}
+	devicePath := filepath.Clean(req.DevicePath)
+	if !strings.HasPrefix(devicePath, "/dev/") {
+		return nil, grpcstatus.Errorf(grpccodes.InvalidArgument, "snapshot device %v is not below /dev", req.DevicePath)
+	}
+
+	vol := s.manager.GetVolume()
+	log := s.logger.WithField("volume", vol.Name).WithField("snapshot", req.Snapshot)
+
+	if _, ok := s.snapshotExports[req.Snapshot]; ok {
+		return nil, grpcstatus.Errorf(grpccodes.AlreadyExists, "snapshot %v is already exported", req.Snapshot)
+	}
+	if !volume.CheckDeviceValid(devicePath) {
+		return nil, newReasonError(grpccodes.FailedPrecondition, ReasonDeviceNotValid, vol.Name, "snapshot device %v is not valid", devicePath)
+	}
+
+	defer func() {
+		if err != nil {
+			log.WithError(err).Error("Failed to export snapshot")
+			s.recordError("ExportSnapshot", vol.Name, err)
+		}
+	}()
+
+	exporter, err := s.getExporter()
+	if err != nil {
+		return nil, err
+	}
+
+	name := snapshotExportName(vol.Name, req.Snapshot)
+	mountPath := types.GetMountPath(name)
+
+	log.Infof("Mounting snapshot device %v read only at %v", devicePath, mountPath)
+	if err := volume.MountReadOnly(devicePath, mountPath); err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, errors.Wrapf(err, "failed to mount snapshot %v", req.Snapshot).Error())
+	}
+	s.auxMounts.add(mountPath, auxMountSnapshot)
+
+	options := s.manager.GetExportOptions()
+	options.ReadOnly = true
+	options.ExportID = req.ExportID
+	// the filesystem id of the volume is taken by its own export
+	options.FilesystemID = ""
+
+	id, err := exporter.CreateExportAndReload(name, options)
+	if err != nil {
+		if unmountErr := volume.UnmountVolume(mountPath); unmountErr != nil {
+			log.WithError(unmountErr).Warn("Failed to unmount snapshot after export failure")
+		} else {
+			s.auxMounts.remove(mountPath)
+		}
+		return nil, toGRPCError(errors.Wrapf(err, "failed to export snapshot %v", req.Snapshot))
+	}
+
+	if s.snapshotExports == nil {
+		s.snapshotExports = map[string]uint16{}
+	}
+	s.snapshotExports[req.Snapshot] = id
+
+	log.Infof("Exported snapshot read only with export id %v", id)
+	return &SnapshotExport{
+		Snapshot: req.Snapshot,
+		ExportID: id,
+		Pseudo:   filepath.Join("/", options.PseudoRoot, name),
+	}, nil
+}
+
+// UnexportSnapshot removes the export of a snapshot created by ExportSnapshot and unmounts it
+func (s *ShareManagerServer) UnexportSnapshot(ctx context.Context, req *UnexportSnapshotRequest) (resp *emptypb.Empty, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.snapshotExports[req.Snapshot]; !ok {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.NotFound, "snapshot %v is not exported", req.Snapshot)
+	}
+
+	vol := s.manager.GetVolume()
+	log := s.logger.WithField("volume", vol.Name).WithField("snapshot", req.Snapshot)
+
+	defer func() {
+		if err != nil {
+			log.WithError(err).Error("Failed to unexport snapshot")
+			s.recordError("UnexportSnapshot", vol.Name, err)
+		}
+	}()
+
+	if err := s.removeSnapshotExport(vol.Name, req.Snapshot); err != nil {
+		return nil, toGRPCError(err)
+	}
+
+	log.Info("Unexported snapshot")
+	return &emptypb.Empty{}, nil
+}
+
+// ListSnapshotExports returns the snapshots exported next to the volume
+func (s *ShareManagerServer) ListSnapshotExports(ctx context.Context, req *emptypb.Empty) ([]SnapshotExport, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	pseudoRoot := s.manager.GetExportOptions().PseudoRoot
+	vol := s.manager.GetVolume()
+	exports := []SnapshotExport{}
+	for snapshot, id := range s.snapshotExports {
+		exports = append(exports, SnapshotExport{
+			Snapshot: snapshot,
+			ExportID: id,
+			Pseudo:   filepath.Join("/", pseudoRoot, snapshotExportName(vol.Name, snapshot)),
+		})
+	}
+	return exports, nil
+}
+
+// removeSnapshotExport deletes the export of the snapshot and unmounts it, the caller must hold the lock
+func (s *ShareManagerServer) removeSnapshotExport(volumeName, snapshot string) error {
+	name := snapshotExportName(volumeName, snapshot)
+
+	exporter, err := nfs.NewExporter(configPath, types.ExportPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to create nfs exporter")
+	}
+	if exporter.GetExport(name) != 0 {
+		if nfsServerIsRunning() {
+			err = exporter.DeleteExportAndReload(name)
+		} else {
+			err = exporter.DeleteExport(name)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to delete export of snapshot %v", snapshot)
+		}
+	}
+
+	mountPath := types.GetMountPath(name)
+	if volume.CheckMountValid(mountPath) {
+		if err := volume.UnmountVolume(mountPath); err != nil {
+			return errors.Wrapf(err, "failed to unmount snapshot %v", snapshot)
+		}
+	}
+	s.auxMounts.remove(mountPath)
+
+	delete(s.snapshotExports, snapshot)
+	return nil
+}
+
+// snapshotExportName is the name the snapshot is mounted and exported under
+func snapshotExportName(volumeName, snapshot string) string {
+	return volumeName + "-snap-" + snapshot
+}
@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	mount "k8s.io/mount-utils"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+// cleanupStaleMount removes a mount and export of the volume left behind by a previous instance,
+// so a fresh Mount starts clean. A healthy mount of the volume device is kept, the next Mount
+// reuses it. It runs before the nfs server is started, so the config is changed without a reload.
+func (s *ShareManagerServer) cleanupStaleMount() error {
+	vol := s.manager.GetVolume()
+	if vol.Name == "" {
+		return nil
+	}
+
+	log := s.logger.WithField("volume", vol.Name)
+	mountPath := types.GetMountPath(vol.Name)
+
+	mp, err := volume.GetMountPoint(mountPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to list mount points")
+	}
+
+	reusable := false
+	if mp != nil {
+		reason, err := staleMountReason(vol.DevicePath(vol.IsEncrypted()), mountPath)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			log.Infof("Keeping existing mount of %v at %v for reuse", mp.Device, mountPath)
+			reusable = true
+		} else {
+			log.Warnf("Unmounting stale mount of %v at %v: %v", mp.Device, mountPath, reason)
+			if err := volume.UnmountVolume(mountPath); err != nil {
+				return errors.Wrapf(err, "failed to unmount stale mount %v", mountPath)
+			}
+			if vol.IsEncrypted() {
+				if err := s.manager.TearDownDevice(); err != nil {
+					log.WithError(err).Warn("Failed to close crypto device after unmounting stale mount")
+				}
+			}
+		}
+	}
+
+	if reusable {
+		return nil
+	}
+
+	exporter, err := nfs.NewExporter(configPath, types.ExportPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to create nfs exporter")
+	}
+	if exporter.GetExport(vol.Name) != 0 {
+		log.Warn("Removing stale export of volume that is not mounted")
+		if err := exporter.DeleteExport(vol.Name); err != nil {
+			return errors.Wrap(err, "failed to remove stale export")
+		}
+	}
+	return nil
+}
+
+// staleMountReason returns why the mount at the mount path cannot be reused as the mount of the
+// volume device, empty if it can
+func staleMountReason(devicePath, mountPath string) (string, error) {
+	if _, err := os.Stat(mountPath); err != nil {
+		if mount.IsCorruptedMnt(err) {
+			return "mount is corrupted: " + err.Error(), nil
+		}
+		return "", errors.Wrapf(err, "failed to check mount point %v", mountPath)
+	}
+
+	if !volume.CheckDeviceValid(devicePath) {
+		return "device " + devicePath + " is not valid", nil
+	}
+
+	matches, err := mountDeviceMatches(devicePath, mountPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to check the device of mount point %v", mountPath)
+	}
+	if !matches {
+		return "mount is not on the current device " + devicePath, nil
+	}
+	return "", nil
+}
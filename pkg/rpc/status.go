@@ -0,0 +1,47 @@
+package rpc
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+)
+
+// Status describes the current state of the shared volume
+type Status struct {
+	Volume string       `json:"volume"`
+	State  server.State `json:"state"`
+	// Exported is false for a mounted volume while the nfs server is down, if mounting without it is enabled
+	Exported         bool `json:"exported"`
+	NFSServerRunning bool `json:"nfsServerRunning"`
+	// NFSServerDownReason tells why the nfs server is considered not running
+	NFSServerDownReason string `json:"nfsServerDownReason,omitempty"`
+	// DegradedReason is set when the mounted filesystem is in an unexpected state
+	DegradedReason string `json:"degradedReason,omitempty"`
+	Maintenance    bool   `json:"maintenance"`
+	// ScheduledTrimPaused is true while the background trim is paused
+	ScheduledTrimPaused bool `json:"scheduledTrimPaused"`
+	// ExportSelfHeals is the number of times a lost export was restored
+	ExportSelfHeals uint64 `json:"exportSelfHeals"`
+}
+
+// GetStatus returns the current status of the shared volume.
+// It does not take the server lock, so it can be used to observe in progress operations.
+func (s *ShareManagerServer) GetStatus(ctx context.Context, req *emptypb.Empty) (*Status, error) {
+	nfsServerDownReason := ""
+	if err := checkNFSServerRunning(); err != nil {
+		nfsServerDownReason = err.Error()
+	}
+
+	return &Status{
+		Volume:              s.manager.GetVolume().Name,
+		State:               s.manager.GetState(),
+		Exported:            s.manager.ShareIsExported(),
+		NFSServerRunning:    nfsServerDownReason == "",
+		NFSServerDownReason: nfsServerDownReason,
+		DegradedReason:      s.degradedReason(),
+		Maintenance:         s.maintenance.Load(),
+		ScheduledTrimPaused: s.trimPaused.Load(),
+		ExportSelfHeals:     s.exportSelfHeals.Load(),
+	}, nil
+}
@@ -0,0 +1,226 @@
+package rpc
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/longhorn/types/pkg/generated/smrpc"
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+	"github.com/longhorn/longhorn-share-manager/pkg/util"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+// trimLoadSampleInterval is the period over which the device utilization is measured before a trim
+const trimLoadSampleInterval = time.Second
+
+var trimmedBytesRegex = regexp.MustCompile(`\(([0-9]+) bytes\) trimmed`)
+
+// TrimResult is the outcome of trimming the filesystem of a single volume
+type TrimResult struct {
+	Volume       string `json:"volume"`
+	TrimmedBytes uint64 `json:"trimmedBytes"`
+	Error        string `json:"error,omitempty"`
+}
+
+// TrimAll trims the filesystems of all mounted volumes and returns a result per volume,
+// a failure of one volume does not abort the batch. The share manager serves a single
+// volume for now, so the batch holds at most one result.
+func (s *ShareManagerServer) TrimAll(ctx context.Context, req *emptypb.Empty) ([]TrimResult, error) {
+	volumes := []volume.Volume{s.manager.GetVolume()}
+
+	// the load is sampled before taking the lock, so other requests are not blocked meanwhile
+	loadErrs := map[string]error{}
+	for _, vol := range volumes {
+		loadErrs[vol.Name] = s.checkTrimLoad(ctx, vol, vol.IsEncrypted())
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return nil, err
+	}
+
+	results := []TrimResult{}
+	for _, vol := range volumes {
+		if vol.Name == "" || s.manager.GetState() != server.StateMounted {
+			continue
+		}
+
+		log := s.logger.WithField("volume", vol.Name)
+		result := TrimResult{Volume: vol.Name}
+		trimmedBytes, err := uint64(0), loadErrs[vol.Name]
+		if err == nil {
+			trimmedBytes, err = s.trim(ctx, vol, vol.IsEncrypted(), 0, 0, log)
+		}
+		if err != nil {
+			log.WithError(err).Warn("Failed to trim mounted filesystem on volume")
+			result.Error = err.Error()
+		}
+		result.TrimmedBytes = trimmedBytes
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// parseTrimmedBytes returns the number of trimmed bytes reported by fstrim -v, zero if not reported
+func parseTrimmedBytes(output string) uint64 {
+	match := trimmedBytesRegex.FindStringSubmatch(output)
+	if match == nil {
+		return 0
+	}
+	trimmedBytes, _ := strconv.ParseUint(match[1], 10, 64)
+	return trimmedBytes
+}
+
+// RunTrimScheduler periodically trims the mounted filesystem until the context is done.
+// It is a no-op if no trim interval is configured.
+func (s *ShareManagerServer) RunTrimScheduler(ctx context.Context) {
+	if s.options.TrimInterval <= 0 {
+		return
+	}
+
+	s.logger.Infof("Starting trim scheduler with interval %v", s.options.TrimInterval)
+	ticker := time.NewTicker(s.options.TrimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Trim scheduler is shutting down")
+			return
+		case <-ticker.C:
+			s.scheduledTrim(ctx)
+		}
+	}
+}
+
+type SetScheduledTrimPausedRequest struct {
+	Paused bool
+}
+
+// SetScheduledTrimPaused pauses or resumes the background trim at runtime. While paused,
+// the scheduler skips its ticks, manual FilesystemTrim calls keep working.
+// The paused state is kept in memory only.
+func (s *ShareManagerServer) SetScheduledTrimPaused(ctx context.Context, req *SetScheduledTrimPausedRequest) (*emptypb.Empty, error) {
+	if s.trimPaused.Swap(req.Paused) != req.Paused {
+		s.logger.Infof("Scheduled trim paused is set to %v", req.Paused)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ShareManagerServer) scheduledTrim(ctx context.Context) {
+	if window := s.options.TrimWindow; window != nil && !window.Contains(time.Now()) {
+		s.logger.Debugf("Skipping scheduled trim outside of the trim window %v", window)
+		return
+	}
+
+	if state := s.manager.GetState(); state != server.StateMounted {
+		s.logger.Debugf("Skipping scheduled trim since volume is %v", state)
+		return
+	}
+
+	if s.maintenance.Load() {
+		s.logger.Debug("Skipping scheduled trim in maintenance mode")
+		return
+	}
+
+	if s.trimPaused.Load() {
+		s.logger.Debug("Skipping scheduled trim since the trim scheduler is paused")
+		return
+	}
+
+	vol := s.manager.GetVolume()
+	if _, err := s.FilesystemTrim(ctx, &smrpc.FilesystemTrimRequest{EncryptedDevice: vol.IsEncrypted()}); err != nil {
+		if grpcstatus.Code(err) == grpccodes.ResourceExhausted {
+			s.logger.WithError(err).Info("Deferring scheduled trim to the next interval")
+			return
+		}
+		s.logger.WithError(err).Warn("Scheduled trim failed")
+	}
+}
+
+// checkTrimLoad samples the device I/O counters over trimLoadSampleInterval and refuses the trim
+// with ResourceExhausted if the device utilization is above the configured threshold. It must be
+// called without holding the server lock. A device that is not valid is left to the trim to report.
+func (s *ShareManagerServer) checkTrimLoad(ctx context.Context, vol volume.Volume, encryptedDevice bool) error {
+	if s.options.TrimMaxUtilization <= 0 || vol.Name == "" {
+		return nil
+	}
+
+	volumeName := vol.Name
+	devicePath := vol.DevicePath(encryptedDevice)
+	if !volume.CheckDeviceValid(devicePath) {
+		return nil
+	}
+
+	prev, err := util.GetDiskStats(devicePath)
+	if err != nil {
+		return grpcstatus.Errorf(grpccodes.Internal, "failed to get I/O stats of volume %v: %v", volumeName, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return grpcstatus.FromContextError(ctx.Err()).Err()
+	case <-time.After(trimLoadSampleInterval):
+	}
+
+	stats, err := util.GetDiskStats(devicePath)
+	if err != nil {
+		return grpcstatus.Errorf(grpccodes.Internal, "failed to get I/O stats of volume %v: %v", volumeName, err)
+	}
+
+	if utilization := stats.Utilization(prev); utilization > s.options.TrimMaxUtilization {
+		return newReasonError(grpccodes.ResourceExhausted, ReasonDeviceBusy, volumeName,
+			"device of volume %v is %.1f%% utilized, above the trim threshold of %.1f%%", volumeName, utilization, s.options.TrimMaxUtilization)
+	}
+	return nil
+}
+
+type TrimRangeRequest struct {
+	EncryptedDevice bool
+	// Offset and Length are the byte range of the filesystem to trim, zero length trims to the end
+	Offset uint64
+	Length uint64
+	// Force skips the device utilization check
+	Force bool
+}
+
+// FilesystemTrimRange trims a byte range of the mounted filesystem and returns the trimmed bytes,
+// so a large filesystem can be trimmed in chunks across calls. A zero range trims the whole
+// filesystem, so this is also the way to force a full trim on a busy device.
+func (s *ShareManagerServer) FilesystemTrimRange(ctx context.Context, req *TrimRangeRequest) (trimmedBytes uint64, err error) {
+	if !req.Force {
+		// the load is sampled before taking the lock, so other requests are not blocked meanwhile
+		if err := s.checkTrimLoad(ctx, s.manager.GetVolume(), req.EncryptedDevice); err != nil {
+			s.recordError("FilesystemTrimRange", s.manager.GetVolume().Name, err)
+			return 0, err
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return 0, err
+	}
+
+	vol := s.manager.GetVolume()
+	log := s.logger.WithField("volume", vol.Name)
+
+	defer func() {
+		if err != nil {
+			log.WithError(err).Errorf("Failed to trim range of mounted filesystem on volume")
+			s.recordError("FilesystemTrimRange", vol.Name, err)
+		}
+	}()
+
+	return s.trim(ctx, vol, req.EncryptedDevice, req.Offset, req.Length, log)
+}
@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+const (
+	verifyExportPath    = "/tmp/verify-export"
+	verifyExportTimeout = 30 * time.Second
+)
+
+// VerifyExport mounts the export over NFS from within the pod, writes and reads back a small file
+// and unmounts it again. It catches exports that are registered but do not serve I/O.
+// The check uses a client connection while it runs, so it has to be enabled explicitly.
+func (s *ShareManagerServer) VerifyExport(ctx context.Context, req *emptypb.Empty) (resp *emptypb.Empty, err error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if !s.options.VerifyExport {
+		return &emptypb.Empty{}, grpcstatus.Error(grpccodes.FailedPrecondition, "export verification is not enabled")
+	}
+
+	vol := s.manager.GetVolume()
+	log := s.logger.WithField("volume", vol.Name)
+
+	if state := s.manager.GetState(); state != server.StateMounted || !s.manager.ShareIsExported() {
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.FailedPrecondition, "volume %v is not exported", vol.Name)
+	}
+
+	defer func() {
+		if err != nil {
+			log.WithError(err).Error("Failed to verify export")
+			s.recordError("VerifyExport", vol.Name, err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, verifyExportTimeout)
+	defer cancel()
+
+	address := s.options.VerifyExportAddress
+	if address == "" {
+		address = "127.0.0.1"
+	}
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		address = "[" + address + "]"
+	}
+	source := address + ":" + filepath.Join("/", vol.Name)
+	targetPath := filepath.Join(verifyExportPath, vol.Name)
+
+	log.Infof("Verifying export by mounting %v at %v", source, targetPath)
+	if err := volume.MountNFSExport(ctx, source, targetPath); err != nil {
+		return nil, toGRPCError(errors.Wrap(err, "failed to mount export"))
+	}
+	s.auxMounts.add(targetPath, auxMountVerify)
+	defer func() {
+		if unmountErr := volume.UnmountVolume(targetPath); unmountErr != nil {
+			log.WithError(unmountErr).Warnf("Failed to unmount export verification mount %v", targetPath)
+			return
+		}
+		s.auxMounts.remove(targetPath)
+		if removeErr := os.Remove(targetPath); removeErr != nil {
+			log.WithError(removeErr).Warnf("Failed to remove export verification path %v", targetPath)
+		}
+	}()
+
+	if err := verifyReadWrite(targetPath); err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+
+	log.Info("Verified export")
+	return &emptypb.Empty{}, nil
+}
+
+// verifyReadWrite writes a file with random content below the path, reads it back and removes it
+func verifyReadWrite(path string) error {
+	data := make([]byte, 4096)
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(path, ".verify-export-")
+	if err != nil {
+		return errors.Wrap(err, "failed to create file on export")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write to export")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to flush write to export")
+	}
+
+	read, err := os.ReadFile(f.Name())
+	if err != nil {
+		return errors.Wrap(err, "failed to read from export")
+	}
+	if !bytes.Equal(data, read) {
+		return errors.New("data read from export does not match the written data")
+	}
+	return nil
+}
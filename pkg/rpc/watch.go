@@ -0,0 +1,93 @@
+package rpc
+
+import (
+	"reflect"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// watchKeepaliveInterval is the interval of the keepalive messages of an idle status watch
+const watchKeepaliveInterval = 30 * time.Second
+
+// StatusUpdate is a message of a status watch
+type StatusUpdate struct {
+	Status *Status `json:"status"`
+	// Keepalive is set for the periodic messages sent while the status does not change
+	Keepalive bool `json:"keepalive"`
+}
+
+// StatusStream is the server side of a status watch, as implemented by a gRPC server stream
+type StatusStream interface {
+	Send(*StatusUpdate) error
+	Context() context.Context
+}
+
+// WatchStatus sends the current status and then a new status on every state change and
+// lifecycle event of the volume, plus keepalive messages while nothing changes.
+// It returns once the stream is closed.
+func (s *ShareManagerServer) WatchStatus(req *emptypb.Empty, stream StatusStream) error {
+	ctx := stream.Context()
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	last, err := s.GetStatus(ctx, req)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&StatusUpdate{Status: last}); err != nil {
+		return err
+	}
+
+	keepalive := time.NewTicker(watchKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-keepalive.C:
+			if err := stream.Send(&StatusUpdate{Status: last, Keepalive: true}); err != nil {
+				return err
+			}
+		case _, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			status, err := s.GetStatus(ctx, req)
+			if err != nil {
+				return err
+			}
+			if reflect.DeepEqual(status, last) {
+				continue
+			}
+			last = status
+
+			if err := stream.Send(&StatusUpdate{Status: status}); err != nil {
+				return err
+			}
+			keepalive.Reset(watchKeepaliveInterval)
+		}
+	}
+}
+
+// grpcStatusStream sends the updates of a status watch on a gRPC server stream
+type grpcStatusStream struct {
+	grpc.ServerStream
+}
+
+func (s *grpcStatusStream) Send(update *StatusUpdate) error {
+	return s.ServerStream.SendMsg(update)
+}
+
+func watchStatusHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(emptypb.Empty)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(ShareManagerAPIServer).WatchStatus(req, &grpcStatusStream{stream})
+}
@@ -0,0 +1,157 @@
+package rpc
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/crypto"
+	"github.com/longhorn/longhorn-share-manager/pkg/server"
+	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+const (
+	// WipeModeCryptoErase erases the LUKS header of an encrypted volume, which makes the data
+	// unreadable almost instantly
+	WipeModeCryptoErase = "crypto-erase"
+	// WipeModeDiscard erases the LUKS header of an encrypted volume and then discards all blocks of
+	// the volume device. A discard alone does not guarantee the blocks are erased, so it requires an
+	// encrypted volume.
+	WipeModeDiscard = "discard"
+	// WipeModeZero writes zeroes over the whole volume device, which takes time proportional to its size
+	WipeModeZero = "zero"
+)
+
+type WipeRequest struct {
+	// Mode is how the data is destroyed, one of crypto-erase, discard or zero
+	Mode string
+	// Confirm has to be the volume name, since the data cannot be recovered
+	Confirm string
+	// Force wipes the volume even if nfs clients are still connected
+	Force bool
+}
+
+// Wipe unexports and unmounts the volume and destroys its data, e.g. to decommission it.
+// It refuses to run while nfs clients are connected unless forced. The volume is left
+// unmounted, the next Mount formats it again, encrypting an encrypted volume again first.
+func (s *ShareManagerServer) Wipe(ctx context.Context, req *WipeRequest) (resp *emptypb.Empty, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkMaintenanceMode(); err != nil {
+		return &emptypb.Empty{}, err
+	}
+
+	vol := s.manager.GetVolume()
+	if vol.Name == "" || req.Confirm != vol.Name {
+		return &emptypb.Empty{}, grpcstatus.Error(grpccodes.InvalidArgument, "wiping a volume requires its name as confirmation")
+	}
+
+	switch req.Mode {
+	case WipeModeCryptoErase, WipeModeDiscard:
+		if !vol.IsEncrypted() {
+			return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.InvalidArgument, "wipe mode %v requires an encrypted volume", req.Mode)
+		}
+	case WipeModeZero:
+	default:
+		return &emptypb.Empty{}, grpcstatus.Errorf(grpccodes.InvalidArgument, "invalid wipe mode %v, must be one of %v, %v or %v",
+			req.Mode, WipeModeCryptoErase, WipeModeDiscard, WipeModeZero)
+	}
+
+	log := s.logger.WithField("volume", vol.Name)
+
+	if s.manager.ShareIsExported() && nfsServerIsRunning() {
+		if err := s.checkNoClients(ctx, req.Force); err != nil {
+			return &emptypb.Empty{}, err
+		}
+	}
+
+	prevState := s.manager.GetState()
+	if err := s.manager.TransitionState(server.StateWiping); err != nil {
+		return &emptypb.Empty{}, newReasonError(grpccodes.FailedPrecondition, ReasonInvalidTransition, vol.Name, err.Error())
+	}
+
+	unmounted := false
+	defer func() {
+		if err != nil {
+			log.WithError(err).Error("Failed to wipe volume")
+			s.recordError("Wipe", vol.Name, err)
+		}
+		// once unmounted the volume stays unmounted, even if destroying the data failed
+		if err != nil && !unmounted {
+			s.manager.SettleState(prevState)
+			return
+		}
+		s.manager.SettleState(server.StateUnmounted)
+	}()
+
+	if s.manager.ShareIsExported() {
+		log.Info("Unexporting volume before wiping it")
+		s.manager.SetShareExported(false)
+		if err := s.unexport(vol); err != nil {
+			return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+		}
+		s.emitEvent(EventTypeUnexported)
+	}
+
+	if err := s.removeBindMounts(); err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, err.Error())
+	}
+	if err := s.unmount(vol); err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, errors.Wrap(err, "failed to unmount volume before wiping it").Error())
+	}
+	if err := s.manager.TearDownDevice(); err != nil {
+		return nil, grpcstatus.Error(grpccodes.Internal, errors.Wrap(err, "failed to close crypto device before wiping it").Error())
+	}
+	unmounted = true
+
+	devicePath := vol.DevicePath(false)
+	if !volume.CheckDeviceValid(devicePath) {
+		return nil, newReasonError(grpccodes.FailedPrecondition, ReasonDeviceNotValid, vol.Name, "volume %v is not valid", vol.Name)
+	}
+
+	log.Warnf("Wiping volume device %v with mode %v", devicePath, req.Mode)
+	switch req.Mode {
+	case WipeModeCryptoErase:
+		err = crypto.EraseHeader(devicePath, vol.CryptoHeaderPath, vol.CryptoTimeout)
+	case WipeModeDiscard:
+		err = crypto.EraseHeader(devicePath, vol.CryptoHeaderPath, vol.CryptoTimeout)
+		if err == nil {
+			err = volume.DiscardDevice(ctx, devicePath, false)
+		}
+	case WipeModeZero:
+		err = volume.DiscardDevice(ctx, devicePath, true)
+	}
+	if err != nil {
+		return nil, toGRPCError(errors.Wrapf(err, "failed to wipe volume %v", vol.Name))
+	}
+
+	log.Infof("Wiped volume device %v", devicePath)
+	return &emptypb.Empty{}, nil
+}
+
+// checkNoClients refuses a destructive operation while nfs clients are connected, unless forced
+func (s *ShareManagerServer) checkNoClients(ctx context.Context, force bool) error {
+	log := s.logger.WithField("volume", s.manager.GetVolume().Name)
+
+	clients, err := nfs.ListConnectedClients(ctx)
+	if err != nil {
+		if force {
+			log.WithError(err).Warn("Failed to list connected clients, continuing since forced")
+			return nil
+		}
+		return toGRPCError(errors.Wrap(err, "failed to list connected clients"))
+	}
+	if len(clients) == 0 {
+		return nil
+	}
+
+	if force {
+		log.Warnf("Continuing with connected clients %v since forced", clients)
+		return nil
+	}
+	return grpcstatus.Errorf(grpccodes.FailedPrecondition, "nfs clients %v are still connected", clients)
+}
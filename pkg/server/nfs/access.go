@@ -0,0 +1,104 @@
+package nfs
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+)
+
+const (
+	AccessTypeRW   = "RW"
+	AccessTypeRO   = "RO"
+	AccessTypeNone = "None"
+)
+
+var validAccessTypes = []string{AccessTypeRW, AccessTypeRO, AccessTypeNone}
+
+// ParseAccessRule parses an access rule in the form client=access, e.g. 10.0.0.0/24=RO
+func ParseAccessRule(rule string) (types.AccessRule, error) {
+	client, accessType, found := strings.Cut(rule, "=")
+	if !found {
+		return types.AccessRule{}, fmt.Errorf("invalid access rule %v, must be in the form client=access", rule)
+	}
+
+	r := types.AccessRule{Client: strings.TrimSpace(client), AccessType: strings.TrimSpace(accessType)}
+	return r, validateAccessRule(r)
+}
+
+// validateAccessRule checks that the client is an IP address or CIDR and the access type is supported
+func validateAccessRule(r types.AccessRule) error {
+	if _, err := canonicalClient(r.Client); err != nil {
+		return err
+	}
+	if !slices.Contains(validAccessTypes, r.AccessType) {
+		return fmt.Errorf("invalid access type %v of client %v, must be one of %v", r.AccessType, r.Client, validAccessTypes)
+	}
+	return nil
+}
+
+// validateAccessRules checks the rules and rejects rules contradicting each other for the same client
+func validateAccessRules(rules []types.AccessRule) error {
+	accessTypes := map[string]string{}
+	for _, rule := range rules {
+		if err := validateAccessRule(rule); err != nil {
+			return err
+		}
+
+		client, _ := canonicalClient(rule.Client)
+		if accessType, ok := accessTypes[client]; ok {
+			if accessType != rule.AccessType {
+				return fmt.Errorf("contradicting access types %v and %v for client %v", accessType, rule.AccessType, rule.Client)
+			}
+			return fmt.Errorf("duplicate access rule for client %v", rule.Client)
+		}
+		accessTypes[client] = rule.AccessType
+	}
+	return nil
+}
+
+// canonicalClient returns the canonical form of an IPv4 or IPv6 client address or CIDR, so the
+// same client is always written the same way, e.g. FD00:0::1 becomes fd00::1, fd00::1/64 becomes
+// fd00::/64 and an IPv4-mapped IPv6 address becomes the IPv4 address
+func canonicalClient(client string) (string, error) {
+	if strings.HasPrefix(client, "[") {
+		return "", fmt.Errorf("invalid client %v, IPv6 addresses must not be enclosed in brackets", client)
+	}
+	if strings.Contains(client, "%") {
+		return "", fmt.Errorf("invalid client %v, IPv6 zones are not supported", client)
+	}
+	if ip := net.ParseIP(client); ip != nil {
+		return ip.String(), nil
+	}
+	if _, ipNet, err := net.ParseCIDR(client); err == nil {
+		return ipNet.String(), nil
+	}
+	return "", fmt.Errorf("invalid client %v, must be an IP address or CIDR", client)
+}
+
+// readOnlyAccessRules returns a copy of the rules with read write access downgraded to read only
+func readOnlyAccessRules(rules []types.AccessRule) []types.AccessRule {
+	result := make([]types.AccessRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.AccessType == AccessTypeRW {
+			rule.AccessType = AccessTypeRO
+		}
+		result = append(result, rule)
+	}
+	return result
+}
+
+// generateAccessRuleBlocks returns a client block per access rule
+func generateAccessRuleBlocks(rules []types.AccessRule) string {
+	var blocks strings.Builder
+	for _, rule := range rules {
+		client, err := canonicalClient(rule.Client)
+		if err != nil {
+			client = rule.Client
+		}
+		blocks.WriteString("\tCLIENT {\n\t\tClients = " + client + ";\n\t\tAccess_Type = " + rule.AccessType + ";\n\t}\n")
+	}
+	return blocks.String()
+}
@@ -0,0 +1,106 @@
+package nfs
+
+import (
+	"testing"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+)
+
+func TestCanonicalClient(t *testing.T) {
+	tests := []struct {
+		name      string
+		client    string
+		canonical string
+		invalid   bool
+	}{
+		{name: "IPv4 address", client: "10.0.0.1", canonical: "10.0.0.1"},
+		{name: "IPv4 CIDR", client: "10.0.0.5/24", canonical: "10.0.0.0/24"},
+		{name: "IPv6 address", client: "FD00:0:0::1", canonical: "fd00::1"},
+		{name: "IPv6 CIDR", client: "fd00::1/64", canonical: "fd00::/64"},
+		{name: "IPv4-mapped IPv6 address", client: "::ffff:10.0.0.1", canonical: "10.0.0.1"},
+		{name: "IPv6 address in brackets", client: "[fd00::1]", invalid: true},
+		{name: "IPv6 address with zone", client: "fe80::1%eth0", invalid: true},
+		{name: "IPv6 CIDR with invalid prefix", client: "fd00::/129", invalid: true},
+		{name: "malformed IPv6 address", client: "fd00:::1", invalid: true},
+		{name: "hostname", client: "client.example.com", invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			canonical, err := canonicalClient(tt.client)
+			if invalid := err != nil; invalid != tt.invalid {
+				t.Fatalf("expected invalid %v, got %v", tt.invalid, err)
+			}
+			if canonical != tt.canonical {
+				t.Fatalf("expected %v, got %v", tt.canonical, canonical)
+			}
+		})
+	}
+}
+
+func TestValidateAccessRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []types.AccessRule
+		invalid bool
+	}{
+		{
+			name: "IPv4 and IPv6 clients",
+			rules: []types.AccessRule{
+				{Client: "10.0.0.0/24", AccessType: AccessTypeRW},
+				{Client: "fd00::/64", AccessType: AccessTypeRO},
+			},
+		},
+		{
+			name: "duplicate IPv6 client spelled differently",
+			rules: []types.AccessRule{
+				{Client: "fd00::1", AccessType: AccessTypeRW},
+				{Client: "FD00:0::1", AccessType: AccessTypeRW},
+			},
+			invalid: true,
+		},
+		{
+			name: "contradicting IPv6 CIDRs",
+			rules: []types.AccessRule{
+				{Client: "fd00::/64", AccessType: AccessTypeRW},
+				{Client: "fd00::1/64", AccessType: AccessTypeNone},
+			},
+			invalid: true,
+		},
+		{
+			name: "IPv4-mapped duplicate of an IPv4 client",
+			rules: []types.AccessRule{
+				{Client: "10.0.0.1", AccessType: AccessTypeRO},
+				{Client: "::ffff:10.0.0.1", AccessType: AccessTypeRO},
+			},
+			invalid: true,
+		},
+		{
+			name:    "unknown access type",
+			rules:   []types.AccessRule{{Client: "fd00::1", AccessType: "MDONLY"}},
+			invalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAccessRules(tt.rules)
+			if invalid := err != nil; invalid != tt.invalid {
+				t.Fatalf("expected invalid %v, got %v", tt.invalid, err)
+			}
+		})
+	}
+}
+
+func TestGenerateAccessRuleBlocks(t *testing.T) {
+	rules := []types.AccessRule{
+		{Client: "FD00:0::1/64", AccessType: AccessTypeRO},
+		{Client: "10.0.0.1", AccessType: AccessTypeRW},
+	}
+
+	expected := "\tCLIENT {\n\t\tClients = fd00::/64;\n\t\tAccess_Type = RO;\n\t}\n" +
+		"\tCLIENT {\n\t\tClients = 10.0.0.1;\n\t\tAccess_Type = RW;\n\t}\n"
+	if blocks := generateAccessRuleBlocks(rules); blocks != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, blocks)
+	}
+}
@@ -0,0 +1,101 @@
+package nfs
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// blockedClientsRegex matches the client block denying access to the blocked clients of an export
+var blockedClientsRegex = regexp.MustCompile(`\tCLIENT \{ #Blocked\n\t\tClients = ([^;]*);\n\t\tAccess_Type = None;\n\t\}\n`)
+
+// GetBlockedClients returns the clients denied access to the export of the volume
+func (e *Exporter) GetBlockedClients(volume string) ([]string, error) {
+	id := e.GetExport(volume)
+	if id == 0 {
+		return nil, fmt.Errorf("volume %v is not exported", volume)
+	}
+
+	e.fileMutex.Lock()
+	config, err := os.ReadFile(e.configPath)
+	e.fileMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	block := exportBlockRegex(volume, id).Find(config)
+	if block == nil {
+		return nil, fmt.Errorf("export block of volume %v is not found", volume)
+	}
+
+	clients := []string{}
+	if match := blockedClientsRegex.FindSubmatch(block); match != nil {
+		for _, client := range strings.Split(string(match[1]), ",") {
+			clients = append(clients, strings.TrimSpace(client))
+		}
+	}
+	return clients, nil
+}
+
+// SetBlockedClientsAndReload replaces the blocked clients of the export of the volume and
+// reloads the nfs server, rolling back the config file if the reload fails.
+// The blocked clients are part of the export block, so they are dropped with the export.
+func (e *Exporter) SetBlockedClientsAndReload(volume string, clients []string) error {
+	id := e.GetExport(volume)
+	if id == 0 {
+		return fmt.Errorf("volume %v is not exported", volume)
+	}
+
+	snapshot, err := e.snapshot()
+	if err != nil {
+		return err
+	}
+
+	if err := e.setBlockedClients(volume, id, clients); err != nil {
+		return errors.Wrapf(err, "failed to set blocked clients of volume %v", volume)
+	}
+
+	if err := e.ReloadExport(); err != nil {
+		return e.rollbackReload(snapshot, err)
+	}
+	return nil
+}
+
+func (e *Exporter) setBlockedClients(volume string, id uint16, clients []string) error {
+	e.fileMutex.Lock()
+	defer e.fileMutex.Unlock()
+
+	config, err := os.ReadFile(e.configPath)
+	if err != nil {
+		return err
+	}
+
+	blockRegex := exportBlockRegex(volume, id)
+	block := blockRegex.Find(config)
+	if block == nil {
+		return fmt.Errorf("export block of volume %v is not found", volume)
+	}
+
+	newBlock := withBlockedClients(blockedClientsRegex.ReplaceAllString(string(block), ""), clients)
+	newConfig := blockRegex.ReplaceAllLiteral(config, []byte(newBlock))
+	return e.writeConfig(newConfig)
+}
+
+// withBlockedClients adds the client block denying the blocked clients to an export block without one
+func withBlockedClients(block string, clients []string) string {
+	if len(clients) == 0 {
+		return block
+	}
+
+	// ganesha applies the first matching client block, so the blocked clients have to come
+	// before the client blocks of the access rules and the FSAL block closing the export
+	clientBlock := "\tCLIENT { #Blocked\n\t\tClients = " + strings.Join(clients, ", ") + ";\n\t\tAccess_Type = None;\n\t}\n"
+	anchor := "\tFSAL {"
+	if strings.Contains(block, "\tCLIENT {\n") {
+		anchor = "\tCLIENT {\n"
+	}
+	return strings.Replace(block, anchor, clientBlock+anchor, 1)
+}
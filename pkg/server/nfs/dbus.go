@@ -0,0 +1,199 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	dbusSendBinary  = "dbus-send"
+	dbusDestination = "org.ganesha.nfsd"
+
+	dbusAdminPath      = "/org/ganesha/nfsd/admin"
+	dbusAdminInterface = "org.ganesha.nfsd.admin"
+)
+
+// ErrManagementUnavailable is returned when the ganesha DBus interface cannot be reached
+var ErrManagementUnavailable = errors.New("nfs server management interface is unavailable")
+
+// dbusSystemBusAddressEnv is the environment variable pointing DBus clients and ganesha to the system bus
+const dbusSystemBusAddressEnv = "DBUS_SYSTEM_BUS_ADDRESS"
+
+// dbusAddressRegex matches a DBus server address in the form transport:key=value[,key=value]
+var dbusAddressRegex = regexp.MustCompile(`^(unix|tcp):[a-z]+=[^,;]+(,[a-z]+=[^,;]+)*$`)
+
+// managementEndpoint is the DBus bus ganesha is managed through, empty is the system bus
+var managementEndpoint struct {
+	sync.Mutex
+	address   string
+	reachable bool
+}
+
+// ValidateDBusAddress checks that the address is a DBus unix or tcp server address, e.g.
+// unix:path=/run/dbus/system_bus_socket
+func ValidateDBusAddress(address string) error {
+	if address != "" && !dbusAddressRegex.MatchString(address) {
+		return fmt.Errorf("invalid DBus address %q, must be in the form unix:path=<socket> or tcp:host=<host>,port=<port>", address)
+	}
+	return nil
+}
+
+// setManagementEndpoint makes the management calls use the bus at the address, empty uses the system bus
+func setManagementEndpoint(address string) {
+	managementEndpoint.Lock()
+	defer managementEndpoint.Unlock()
+	managementEndpoint.address = address
+	managementEndpoint.reachable = false
+}
+
+// getManagementEndpoint returns the address of the management bus, checking that its socket exists
+// until it was found once, so a misconfigured endpoint is reported on first use
+func getManagementEndpoint() (string, error) {
+	managementEndpoint.Lock()
+	defer managementEndpoint.Unlock()
+
+	address := managementEndpoint.address
+	if address == "" || managementEndpoint.reachable {
+		return address, nil
+	}
+
+	if socket, ok := strings.CutPrefix(address, "unix:path="); ok {
+		socket, _, _ = strings.Cut(socket, ",")
+		info, err := os.Stat(socket)
+		if err != nil {
+			return "", errors.Wrapf(ErrManagementUnavailable, "management endpoint %v is not reachable: %v", address, err)
+		}
+		if info.Mode()&os.ModeSocket == 0 {
+			return "", errors.Wrapf(ErrManagementUnavailable, "management endpoint %v is not a socket", address)
+		}
+	}
+	managementEndpoint.reachable = true
+	return address, nil
+}
+
+// callDBus invokes a method of the ganesha DBus interface and returns the printed reply
+func callDBus(ctx context.Context, path, method string, args ...string) (string, error) {
+	if _, err := exec.LookPath(dbusSendBinary); err != nil {
+		return "", errors.Wrapf(ErrManagementUnavailable, "%v is not found", dbusSendBinary)
+	}
+
+	address, err := getManagementEndpoint()
+	if err != nil {
+		return "", err
+	}
+	bus := "--system"
+	if address != "" {
+		bus = "--address=" + address
+	}
+
+	cmdArgs := append([]string{bus, "--print-reply", "--dest=" + dbusDestination, path, method}, args...)
+	out, err := exec.CommandContext(ctx, dbusSendBinary, cmdArgs...).CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("dbus call %v failed: %v, output: %s", method, err, out)
+	}
+	return string(out), nil
+}
+
+// StartGrace puts the nfs server into its grace period, so clients can reclaim their state.
+// The address is handed to ganesha as the IP taken over by this server.
+func StartGrace(ctx context.Context, ipAddress string) error {
+	_, err := callDBus(ctx, dbusAdminPath, dbusAdminInterface+".grace", "string:"+ipAddress)
+	return err
+}
+
+var dbusStringRegex = regexp.MustCompile(`string "([^"]*)"`)
+
+// ListConnectedClients returns the addresses of the clients known to the nfs server
+func ListConnectedClients(ctx context.Context) ([]string, error) {
+	out, err := callDBus(ctx, dbusClientMgrPath, dbusClientMgrInterface+".ShowClients")
+	if err != nil {
+		return nil, err
+	}
+	return parseClientAddresses(out), nil
+}
+
+// parseClientAddresses returns the client addresses of a ShowClients reply, each client is a
+// struct starting with its address, e.g. string "::ffff:10.42.0.5"
+func parseClientAddresses(reply string) []string {
+	clients := []string{}
+	for _, match := range dbusStringRegex.FindAllStringSubmatch(reply, -1) {
+		ip := net.ParseIP(match[1])
+		if ip == nil {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		if client := ip.String(); !slices.Contains(clients, client) {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// ConnectedClientAddress returns the address of the client as known to the nfs server, empty if it
+// is not connected. An IPv4 client connected over an IPv6 socket is known by its IPv4-mapped IPv6
+// address, so the address has to be looked up to address the client in ganesha.
+func ConnectedClientAddress(ctx context.Context, ip net.IP) (string, error) {
+	out, err := callDBus(ctx, dbusClientMgrPath, dbusClientMgrInterface+".ShowClients")
+	if err != nil {
+		return "", err
+	}
+	return findClientAddress(out, ip), nil
+}
+
+// findClientAddress returns the address of a ShowClients reply matching the ip, empty if there is none
+func findClientAddress(reply string, ip net.IP) string {
+	for _, match := range dbusStringRegex.FindAllStringSubmatch(reply, -1) {
+		if clientIP := net.ParseIP(match[1]); clientIP != nil && clientIP.Equal(ip) {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+var dbusExportIDRegex = regexp.MustCompile(`(?m)^\s*uint16 ([0-9]+)$`)
+
+// ListRunningExportIDs returns the ids of the exports loaded by the running nfs server,
+// which can differ from the config file if an export was lost
+func ListRunningExportIDs(ctx context.Context) ([]uint16, error) {
+	out, err := callDBus(ctx, dbusExportMgrPath, dbusExportMgrInterface+".ShowExports")
+	if err != nil {
+		return nil, err
+	}
+	return parseExportIDs(out), nil
+}
+
+// parseExportIDs returns the export ids of a ShowExports reply, each export is a struct
+// starting with its id, e.g. uint16 1
+func parseExportIDs(reply string) []uint16 {
+	ids := []uint16{}
+	for _, match := range dbusExportIDRegex.FindAllStringSubmatch(reply, -1) {
+		id, err := strconv.ParseUint(match[1], 10, 16)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint16(id))
+	}
+	return ids
+}
+
+// RemoveClient drops the client from the nfs server, which terminates its sessions and
+// releases the locks and open state it holds
+func RemoveClient(ctx context.Context, ip string) error {
+	_, err := callDBus(ctx, dbusClientMgrPath, dbusClientMgrInterface+".RemoveClient", "string:"+ip)
+	return err
+}
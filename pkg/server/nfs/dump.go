@@ -0,0 +1,80 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	dbusExportMgrPath      = "/org/ganesha/nfsd/ExportMgr"
+	dbusExportMgrInterface = "org.ganesha.nfsd.exportmgr"
+
+	dbusClientMgrPath      = "/org/ganesha/nfsd/ClientMgr"
+	dbusClientMgrInterface = "org.ganesha.nfsd.clientmgr"
+)
+
+// StateDump is a snapshot of the nfs server state for support bundles. Each section holds the
+// printed reply of the management interface, a section that could not be collected is listed
+// in Errors instead.
+type StateDump struct {
+	Config  string `json:"config"`
+	Exports string `json:"exports"`
+	Clients string `json:"clients,omitempty"`
+	Grace   string `json:"grace,omitempty"`
+	// ExportsFromConfig is set when the exports are read from the config file since the
+	// management interface is unavailable
+	ExportsFromConfig bool              `json:"exportsFromConfig"`
+	Errors            map[string]string `json:"errors,omitempty"`
+}
+
+// DumpState collects the config file and the exports, clients and grace status of the running
+// nfs server. The exports fall back to the config file if the management interface is unavailable.
+func (e *Exporter) DumpState(ctx context.Context) *StateDump {
+	dump := &StateDump{Errors: map[string]string{}}
+
+	e.fileMutex.Lock()
+	config, err := os.ReadFile(e.configPath)
+	e.fileMutex.Unlock()
+	if err != nil {
+		dump.Errors["config"] = err.Error()
+	}
+	dump.Config = string(config)
+
+	exports, err := callDBus(ctx, dbusExportMgrPath, dbusExportMgrInterface+".ShowExports")
+	if err != nil {
+		dump.Errors["exports"] = err.Error()
+		exports, err = e.formatExportsFromConfig()
+		if err != nil {
+			dump.Errors["exportsFromConfig"] = err.Error()
+		}
+		dump.ExportsFromConfig = true
+	}
+	dump.Exports = exports
+
+	dump.Clients, err = callDBus(ctx, dbusClientMgrPath, dbusClientMgrInterface+".ShowClients")
+	if err != nil {
+		dump.Errors["clients"] = err.Error()
+	}
+
+	dump.Grace, err = callDBus(ctx, dbusAdminPath, dbusAdminInterface+".get_grace")
+	if err != nil {
+		dump.Errors["grace"] = err.Error()
+	}
+
+	return dump
+}
+
+func (e *Exporter) formatExportsFromConfig() (string, error) {
+	exports, err := e.ListExports()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, export := range exports {
+		fmt.Fprintf(&b, "%v volume=%v path=%v access=%v fsid=%v\n", export.ExportID, export.Volume, export.Path, export.AccessType, export.FilesystemID)
+	}
+	return b.String(), nil
+}
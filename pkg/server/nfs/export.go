@@ -2,9 +2,11 @@ package nfs
 
 import (
 	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -12,6 +14,7 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
 	"github.com/longhorn/longhorn-share-manager/pkg/util"
 )
 
@@ -30,7 +33,197 @@ type Exporter struct {
 	fileMutex sync.Mutex
 }
 
-var exportRegex = regexp.MustCompile("Export_Id = ([0-9]+);#Volume=(.+)")
+// ExportOptions holds the per volume settings written into an export block
+type ExportOptions struct {
+	// EnableACL enables NFSv4 ACL handling for the export
+	EnableACL bool
+	// SecTypes are the security flavors of the export, empty defaults to sys
+	SecTypes []string
+	// ExportID pins the export id of the volume, zero allocates the lowest free id
+	ExportID uint16
+	// FilesystemID is the fsid in major.minor form, empty derives it from the export id
+	FilesystemID string
+	// Squash is the uid/gid mapping of the clients (none, root_squash, all_squash), empty means none
+	Squash string
+	// AnonymousUID and AnonymousGID are the ids squashed clients are mapped to, nil keeps the ganesha default.
+	// They are required for all_squash.
+	AnonymousUID *uint32
+	AnonymousGID *uint32
+	// AccessRules restrict the export to the listed clients with their access types,
+	// empty grants read write access to all clients
+	AccessRules []types.AccessRule
+	// PseudoRoot is the directory of the NFSv4 pseudo filesystem the volume appears under,
+	// e.g. /volumes, so clients can mount it and see all shares. Empty uses /<volume>.
+	PseudoRoot string
+	// ReadOnly exports the volume read only to all clients, access rules granting RW are downgraded to RO
+	ReadOnly bool
+	// MaxRead and MaxWrite are the largest read and write sizes in bytes the clients may use
+	// per operation, zero keeps the ganesha default
+	MaxRead  uint64
+	MaxWrite uint64
+	// FSALName is the ganesha FSAL backing the export, empty uses VFS
+	FSALName string
+	// FSALOptions are additional parameters of the FSAL block
+	FSALOptions []types.FSALOption
+	// Delegations are the NFSv4 delegations granted to the clients (none, read, write), empty keeps
+	// the ganesha default. Read and write delegations also require them to be enabled on the server.
+	Delegations string
+}
+
+const (
+	SquashNone = "none"
+	SquashRoot = "root_squash"
+	SquashAll  = "all_squash"
+)
+
+// squashParams are the ganesha Squash values of the supported squash modes
+var squashParams = map[string]string{
+	SquashNone: "None",
+	SquashRoot: "Root_Squash",
+	SquashAll:  "All_Squash",
+}
+
+const (
+	DelegationsNone  = "none"
+	DelegationsRead  = "read"
+	DelegationsWrite = "write"
+)
+
+// delegationParams are the ganesha Delegations values of the supported delegation modes,
+// a client holding a write delegation may also read, so write grants both
+var delegationParams = map[string]string{
+	DelegationsNone:  "None",
+	DelegationsRead:  "Read",
+	DelegationsWrite: "Readwrite",
+}
+
+var (
+	// ErrExportIDInUse is returned when a requested export id is already used by another export
+	ErrExportIDInUse = errors.New("export id is already in use")
+	// ErrFilesystemIDInUse is returned when a requested fsid is already used by another export
+	ErrFilesystemIDInUse = errors.New("filesystem id is already in use")
+	// ErrPseudoPathInUse is returned when the pseudo path of an export is or contains the one of another export
+	ErrPseudoPathInUse = errors.New("pseudo path is already in use")
+	// ErrExportConflict is returned when the volume is already exported with a different definition
+	ErrExportConflict = errors.New("volume is already exported with a different definition")
+	// ErrReloadFailed is returned when the nfs server could not be signalled to reload its config,
+	// e.g. while it is starting up or restarting
+	ErrReloadFailed = errors.New("failed to reload nfs server")
+	// ErrConfigRejected is returned when the nfs server reports errors in its config after a reload
+	ErrConfigRejected = errors.New("nfs server rejected the config")
+)
+
+// IsTransientError returns true if the error is caused by the nfs server not being ready yet,
+// so the operation may succeed when retried. Errors in the export definition are not transient.
+func IsTransientError(err error) bool {
+	return errors.Is(err, ErrReloadFailed) || errors.Is(err, ErrManagementUnavailable) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// FilesystemIDFromUID derives a stable fsid from a volume UID, so file handles stay
+// valid across nfs server restarts and migrations to other nodes
+func FilesystemIDFromUID(uid string) string {
+	h := fnv.New64a()
+	h.Write([]byte(uid))
+	sum := h.Sum64()
+	return strconv.FormatUint(sum>>32, 10) + "." + strconv.FormatUint(sum&0xffffffff, 10)
+}
+
+const (
+	// minIOSize and maxIOSize are the bounds ganesha accepts for MaxRead and MaxWrite
+	minIOSize = 512
+	maxIOSize = 64 * 1024 * 1024
+)
+
+var validSecTypes = []string{"none", "sys", "krb5", "krb5i", "krb5p"}
+
+// Validate checks that the export options are supported
+func (o ExportOptions) Validate() error {
+	for _, secType := range o.SecTypes {
+		if !slices.Contains(validSecTypes, secType) {
+			return fmt.Errorf("invalid security flavor %v, must be one of %v", secType, validSecTypes)
+		}
+	}
+	if o.FilesystemID != "" && !filesystemIDRegex.MatchString(o.FilesystemID) {
+		return fmt.Errorf("invalid filesystem id %v, must be in the form major.minor", o.FilesystemID)
+	}
+
+	if err := validateAccessRules(o.AccessRules); err != nil {
+		return err
+	}
+	if err := ValidatePseudoRoot(o.PseudoRoot); err != nil {
+		return err
+	}
+	if err := validateFSAL(o.FSALName, o.FSALOptions); err != nil {
+		return err
+	}
+	if err := validateIOSize("max read", o.MaxRead); err != nil {
+		return err
+	}
+	if err := validateIOSize("max write", o.MaxWrite); err != nil {
+		return err
+	}
+
+	if _, ok := delegationParams[o.Delegations]; o.Delegations != "" && !ok {
+		return fmt.Errorf("invalid delegations %v, must be one of %v, %v, %v", o.Delegations, DelegationsNone, DelegationsRead, DelegationsWrite)
+	}
+
+	switch o.Squash {
+	case "", SquashNone:
+		if o.AnonymousUID != nil || o.AnonymousGID != nil {
+			return fmt.Errorf("anonymous uid and gid require root_squash or all_squash")
+		}
+	case SquashRoot:
+	case SquashAll:
+		if o.AnonymousUID == nil || o.AnonymousGID == nil {
+			return fmt.Errorf("all_squash requires both the anonymous uid and gid")
+		}
+	default:
+		return fmt.Errorf("invalid squash %v, must be one of %v, %v, %v", o.Squash, SquashNone, SquashRoot, SquashAll)
+	}
+	return nil
+}
+
+// validateIOSize checks that a non zero read or write size is within the range ganesha accepts
+func validateIOSize(name string, size uint64) error {
+	if size != 0 && (size < minIOSize || size > maxIOSize) {
+		return fmt.Errorf("invalid %v %v, must be between %v and %v bytes", name, size, minIOSize, maxIOSize)
+	}
+	return nil
+}
+
+// ValidatePseudoRoot checks that the pseudo root is a clean absolute path below /, empty is allowed
+func ValidatePseudoRoot(pseudoRoot string) error {
+	if pseudoRoot == "" {
+		return nil
+	}
+	if !filepath.IsAbs(pseudoRoot) || filepath.Clean(pseudoRoot) != pseudoRoot || pseudoRoot == "/" {
+		return fmt.Errorf("invalid pseudo root %v, must be a clean absolute path below /", pseudoRoot)
+	}
+	if strings.ContainsAny(pseudoRoot, " ;#\"\n{}") {
+		return fmt.Errorf("invalid pseudo root %q", pseudoRoot)
+	}
+	return nil
+}
+
+// Export describes an export block found in the nfs server config
+type Export struct {
+	Volume       string `json:"volume"`
+	ExportID     uint16 `json:"exportID"`
+	Path         string `json:"path"`
+	Pseudo       string `json:"pseudo"`
+	AccessType   string `json:"accessType"`
+	FilesystemID string `json:"filesystemID"`
+}
+
+var (
+	exportRegex       = regexp.MustCompile("Export_Id = ([0-9]+);#Volume=(.+)")
+	exportBlocksRegex = regexp.MustCompile(`(?s)\nEXPORT\n\{\n(.*?)\n\}\n`)
+	exportParamRegex  = regexp.MustCompile(`^\t([A-Za-z_]+) = ([^;]*);`)
+	filesystemIDRegex = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+	coreParamRegex      = regexp.MustCompile(`NFS_Core_Param\n\{`)
+	maxConnectionsRegex = regexp.MustCompile(`(\n\s*RPC_Max_Connections = )([0-9]+);`)
+)
 
 func NewExporter(configPath, exportPath string) (*Exporter, error) {
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
@@ -89,13 +282,26 @@ func (e *Exporter) GetExport(volume string) uint16 {
 	return e.volumeToid[volume]
 }
 
-// claimID generates a unique export id for a volume, a volume can only be exported once
-func (e *Exporter) claimID(volume string) uint16 {
+// claimID generates a unique export id for a volume, a volume can only be exported once.
+// A non zero requested id is claimed as is, unless it is used by another volume.
+func (e *Exporter) claimID(volume string, requested uint16) (uint16, error) {
 	e.mapMutex.Lock()
 	defer e.mapMutex.Unlock()
 
 	if id, ok := e.volumeToid[volume]; ok {
-		return id
+		if requested != 0 && id != requested {
+			return 0, errors.Wrapf(ErrExportIDInUse, "volume %v is already exported with id %v", volume, id)
+		}
+		return id, nil
+	}
+
+	if requested != 0 {
+		if vol, ok := e.idToVolume[requested]; ok {
+			return 0, errors.Wrapf(ErrExportIDInUse, "export id %v is used by volume %v", requested, vol)
+		}
+		e.idToVolume[requested] = volume
+		e.volumeToid[volume] = requested
+		return requested, nil
 	}
 
 	id := uint16(1)
@@ -107,7 +313,33 @@ func (e *Exporter) claimID(volume string) uint16 {
 		}
 	}
 
-	return id
+	return id, nil
+}
+
+// previewID returns the export id the volume has or would be given by claimID, without claiming it
+func (e *Exporter) previewID(volume string, requested uint16) (uint16, error) {
+	e.mapMutex.RLock()
+	defer e.mapMutex.RUnlock()
+
+	if id, ok := e.volumeToid[volume]; ok {
+		if requested != 0 && id != requested {
+			return 0, errors.Wrapf(ErrExportIDInUse, "volume %v is already exported with id %v", volume, id)
+		}
+		return id, nil
+	}
+
+	if requested != 0 {
+		if vol, ok := e.idToVolume[requested]; ok {
+			return 0, errors.Wrapf(ErrExportIDInUse, "export id %v is used by volume %v", requested, vol)
+		}
+		return requested, nil
+	}
+
+	id := uint16(1)
+	for _, ok := e.idToVolume[id]; ok; _, ok = e.idToVolume[id] {
+		id++
+	}
+	return id, nil
 }
 
 func (e *Exporter) deleteID(id uint16) {
@@ -121,13 +353,40 @@ func (e *Exporter) deleteID(id uint16) {
 	delete(e.idToVolume, id)
 }
 
-func (e *Exporter) CreateExport(volume string) (uint16, error) {
+// CreateExport adds the export block of the volume to the config. It is idempotent, an existing
+// identical export is returned as is, while an existing export with a different definition fails
+// with ErrExportConflict.
+func (e *Exporter) CreateExport(volume string, options ExportOptions) (uint16, error) {
+	if err := options.Validate(); err != nil {
+		return 0, err
+	}
+
 	if id := e.GetExport(volume); id != 0 {
+		if options.ExportID != 0 && id != options.ExportID {
+			return 0, errors.Wrapf(ErrExportIDInUse, "volume %v is already exported with id %v", volume, id)
+		}
+		matches, err := e.exportMatches(volume, id, options)
+		if err != nil {
+			return 0, err
+		}
+		if !matches {
+			return 0, errors.Wrapf(ErrExportConflict, "export %v of volume %v", id, volume)
+		}
 		return id, nil
 	}
 
-	exportID := e.claimID(volume)
-	block := generateExportBlock(e.exportPath, volume, exportID)
+	if err := e.checkFilesystemID(volume, options.FilesystemID); err != nil {
+		return 0, err
+	}
+	if err := e.checkPseudoPath(volume, exportPseudoPath(volume, options)); err != nil {
+		return 0, err
+	}
+
+	exportID, err := e.claimID(volume, options.ExportID)
+	if err != nil {
+		return 0, err
+	}
+	block := generateExportBlock(e.exportPath, volume, exportID, options)
 
 	if err := e.addToConfig(block); err != nil {
 		e.deleteID(exportID)
@@ -136,6 +395,49 @@ func (e *Exporter) CreateExport(volume string) (uint16, error) {
 	return exportID, nil
 }
 
+// RenderExport returns the export block the volume would get in the config with the options,
+// without changing the config or the export ids. The export id of an existing export is kept.
+func (e *Exporter) RenderExport(volume string, options ExportOptions) (string, error) {
+	if err := options.Validate(); err != nil {
+		return "", err
+	}
+
+	id, err := e.previewID(volume, options.ExportID)
+	if err != nil {
+		return "", err
+	}
+	return generateExportBlock(e.exportPath, volume, id, options), nil
+}
+
+// HasExport reports whether the config holds the export of the volume with the options,
+// ignoring its blocked clients
+func (e *Exporter) HasExport(volume string, options ExportOptions) (bool, error) {
+	id := e.GetExport(volume)
+	if id == 0 || (options.ExportID != 0 && options.ExportID != id) {
+		return false, nil
+	}
+	return e.exportMatches(volume, id, options)
+}
+
+// exportMatches reports whether the export block of the volume is the one generated from the options,
+// ignoring the blocked clients
+func (e *Exporter) exportMatches(volume string, id uint16, options ExportOptions) (bool, error) {
+	e.fileMutex.Lock()
+	config, err := os.ReadFile(e.configPath)
+	e.fileMutex.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	block := exportBlockRegex(volume, id).Find(config)
+	if block == nil {
+		return false, fmt.Errorf("export block of volume %v is not found", volume)
+	}
+
+	current := blockedClientsRegex.ReplaceAllString(string(block), "")
+	return current == generateExportBlock(e.exportPath, volume, id, options), nil
+}
+
 func (e *Exporter) DeleteExport(volume string) error {
 	id, ok := e.volumeToid[volume]
 	if !ok {
@@ -144,8 +446,7 @@ func (e *Exporter) DeleteExport(volume string) error {
 
 	// TODO: write a lexer and parser for the export config
 	// 	instead of doing these string manipulations
-	block := generateExportBlock(e.exportPath, volume, id)
-	if err := e.removeFromConfig(block); err != nil {
+	if err := e.removeFromConfig(volume, id); err != nil {
 		return err
 	}
 
@@ -153,41 +454,339 @@ func (e *Exporter) DeleteExport(volume string) error {
 	return nil
 }
 
-func (e *Exporter) ReloadExport() error {
-	processName := "ganesha.nfsd"
+// CreateExportAndReload creates the export of the volume and reloads the nfs server.
+// If the reload fails, the config file and export ids are rolled back and the nfs server is
+// reloaded again, so the file does not drift from the config running in the nfs server.
+// The new config cannot be staged in a temp file and only be renamed after the reload
+// succeeded, since ganesha rereads the config path it was started with on SIGHUP, so the
+// config has to be in place before the reload. writeConfig still replaces it atomically.
+func (e *Exporter) CreateExportAndReload(volume string, options ExportOptions) (uint16, error) {
+	snapshot, err := e.snapshot()
+	if err != nil {
+		return 0, err
+	}
 
-	process, err := util.FindProcessByName(processName)
+	id, err := e.CreateExport(volume, options)
 	if err != nil {
-		return errors.Wrapf(err, "failed to find process %s", processName)
+		return 0, err
 	}
 
-	err = process.Signal(syscall.SIGHUP)
+	if err := e.ReloadExport(); err != nil {
+		return 0, e.rollbackReload(snapshot, err)
+	}
+	return id, nil
+}
+
+// DeleteExportAndReload deletes the export of the volume and reloads the nfs server,
+// rolling back the config file and export ids if the reload fails.
+func (e *Exporter) DeleteExportAndReload(volume string) error {
+	snapshot, err := e.snapshot()
+	if err != nil {
+		return err
+	}
+
+	if err := e.DeleteExport(volume); err != nil {
+		return err
+	}
+
+	if err := e.ReloadExport(); err != nil {
+		return e.rollbackReload(snapshot, err)
+	}
+	return nil
+}
+
+// GetMaxConnections returns the connection limit of the core config block, zero if unset
+func (e *Exporter) GetMaxConnections() (int, error) {
+	e.fileMutex.Lock()
+	defer e.fileMutex.Unlock()
+
+	config, err := os.ReadFile(e.configPath)
+	if err != nil {
+		return 0, err
+	}
+
+	match := maxConnectionsRegex.FindSubmatch(config)
+	if match == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(string(match[2]))
+}
+
+// SetMaxConnections writes the connection limit into the core config block, zero removes it.
+// ganesha reads the core block at startup, so the limit applies once the nfs server restarts.
+func (e *Exporter) SetMaxConnections(maxConnections int) error {
+	if err := ValidateMaxConnections(maxConnections); err != nil {
+		return err
+	}
+
+	e.fileMutex.Lock()
+	defer e.fileMutex.Unlock()
+
+	config, err := os.ReadFile(e.configPath)
 	if err != nil {
-		return fmt.Errorf("failed to send SIGHUP to process %s", processName)
+		return err
+	}
+
+	newConfig := maxConnectionsRegex.ReplaceAll(config, nil)
+	if maxConnections > 0 {
+		loc := coreParamRegex.FindIndex(newConfig)
+		if loc == nil {
+			return fmt.Errorf("NFS_Core_Param block is not found in %v", e.configPath)
+		}
+		directive := "\n    RPC_Max_Connections = " + strconv.Itoa(maxConnections) + ";"
+		newConfig = append(newConfig[:loc[1]:loc[1]], append([]byte(directive), newConfig[loc[1]:]...)...)
+	}
+
+	return e.writeConfig(newConfig)
+}
+
+type exporterSnapshot struct {
+	config    []byte
+	exportMap ExportMap
+}
+
+func (e *Exporter) snapshot() (*exporterSnapshot, error) {
+	e.fileMutex.Lock()
+	config, err := os.ReadFile(e.configPath)
+	e.fileMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &exporterSnapshot{
+		config:    config,
+		exportMap: e.GetExportMap(),
+	}, nil
+}
+
+// rollback restores the snapshot and returns the error that caused the rollback
+func (e *Exporter) rollback(snapshot *exporterSnapshot, cause error) error {
+	if err := e.restore(snapshot); err != nil {
+		return errors.Wrapf(cause, "failed to roll back nfs config after error: %v", err)
+	}
+	return errors.Wrap(cause, "rolled back nfs config")
+}
+
+// rollbackReload restores the snapshot after a failed reload and reloads the nfs server again,
+// since the failed reload may have applied part of the new config, e.g. when its check timed out.
+// It returns the error that caused the rollback.
+func (e *Exporter) rollbackReload(snapshot *exporterSnapshot, cause error) error {
+	if err := e.restore(snapshot); err != nil {
+		return errors.Wrapf(cause, "failed to roll back nfs config after error: %v", err)
 	}
 
+	// the nfs server was not signalled, it still runs the restored config
+	if errors.Is(cause, ErrReloadFailed) {
+		return errors.Wrap(cause, "rolled back nfs config")
+	}
+
+	if err := e.ReloadExport(); err != nil {
+		return errors.Wrapf(cause, "rolled back nfs config but failed to reload it: %v", err)
+	}
+	return errors.Wrap(cause, "rolled back and reloaded nfs config")
+}
+
+func (e *Exporter) restore(snapshot *exporterSnapshot) error {
+	e.fileMutex.Lock()
+	err := e.writeConfig(snapshot.config)
+	e.fileMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	e.mapMutex.Lock()
+	e.ExportMap = &snapshot.exportMap
+	e.mapMutex.Unlock()
 	return nil
 }
 
-func generateExportBlock(exportBase, volume string, id uint16) string {
-	squash := "None"
+func (e *Exporter) ReloadExport() error {
+	process, err := util.FindProcessByName(ProcessName)
+	if err != nil {
+		return errors.Wrapf(ErrReloadFailed, "failed to find process %s: %v", ProcessName, err)
+	}
+
+	logPath := e.getLogPath()
+	logOffset := getLogSize(logPath)
+
+	err = process.Signal(syscall.SIGHUP)
+	if err != nil {
+		return errors.Wrapf(ErrReloadFailed, "failed to send SIGHUP to process %s: %v", ProcessName, err)
+	}
+
+	return e.checkReload(logPath, logOffset)
+}
+
+func generateExportBlock(exportBase, volume string, id uint16, options ExportOptions) string {
+	accessType := AccessTypeRW
+	if options.ReadOnly {
+		accessType = AccessTypeRO
+	}
+	accessRules := options.AccessRules
+	if len(accessRules) > 0 {
+		// only the clients of the access rules get access
+		accessType = AccessTypeNone
+		if options.ReadOnly {
+			accessRules = readOnlyAccessRules(accessRules)
+		}
+	}
+	squash := squashParams[SquashNone]
+	if options.Squash != "" {
+		squash = squashParams[options.Squash]
+	}
 	secType := "sys"
-	pseudoPath := filepath.Join("/", volume)
+	if len(options.SecTypes) > 0 {
+		secType = strings.Join(options.SecTypes, ", ")
+	}
+	pseudoPath := exportPseudoPath(volume, options)
 	exportPath := filepath.Join(exportBase, volume)
 	exportID := strconv.FormatUint(uint64(id), 10)
 	volumeMarker := "#Volume=" + volume
+	filesystemID := exportID + "." + "0"
+	if options.FilesystemID != "" {
+		filesystemID = options.FilesystemID
+	}
 
-	return "\nEXPORT\n{\n" +
+	block := "\nEXPORT\n{\n" +
 		"\tExport_Id = " + exportID + ";" + volumeMarker + "\n" +
 		"\tPath = " + exportPath + ";\n" +
 		"\tPseudo = " + pseudoPath + ";\n" +
 		"\tProtocols = 4;\n" +
 		"\tTransports = TCP;\n" +
-		"\tAccess_Type = RW;\n" +
-		"\tSquash = " + squash + ";\n" +
+		"\tAccess_Type = " + accessType + ";\n" +
+		"\tSquash = " + squash + ";\n"
+
+	if options.Squash == SquashRoot || options.Squash == SquashAll {
+		if options.AnonymousUID != nil {
+			block += "\tAnonymous_Uid = " + strconv.FormatUint(uint64(*options.AnonymousUID), 10) + ";\n"
+		}
+		if options.AnonymousGID != nil {
+			block += "\tAnonymous_Gid = " + strconv.FormatUint(uint64(*options.AnonymousGID), 10) + ";\n"
+		}
+	}
+
+	block +=
 		"\tSecType = " + secType + ";\n" +
-		"\tFilesystem_id = " + exportID + "." + "0" + ";\n" +
-		"\tFSAL {\n\t\tName = VFS;\n\t}\n}\n"
+			"\tFilesystem_id = " + filesystemID + ";\n"
+
+	if options.MaxRead != 0 {
+		block += "\tMaxRead = " + strconv.FormatUint(options.MaxRead, 10) + ";\n"
+	}
+	if options.MaxWrite != 0 {
+		block += "\tMaxWrite = " + strconv.FormatUint(options.MaxWrite, 10) + ";\n"
+	}
+
+	if options.Delegations != "" {
+		block += "\tDelegations = " + delegationParams[options.Delegations] + ";\n"
+	}
+
+	if options.EnableACL {
+		block += "\tDisable_ACL = false;\n"
+	}
+
+	block += generateAccessRuleBlocks(accessRules)
+
+	return block + generateFSALBlock(options.FSALName, options.FSALOptions) + "}\n"
+}
+
+// exportPseudoPath returns the path of the export in the NFSv4 pseudo filesystem
+func exportPseudoPath(volume string, options ExportOptions) string {
+	return filepath.Join("/", options.PseudoRoot, volume)
+}
+
+// checkPseudoPath returns an error if the pseudo path collides with the one of another export.
+// Nested pseudo paths are rejected too, since one export would hide a part of the other.
+func (e *Exporter) checkPseudoPath(volume, pseudoPath string) error {
+	exports, err := e.ListExports()
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if export.Volume == volume || export.Pseudo == "" {
+			continue
+		}
+		if export.Pseudo == pseudoPath || strings.HasPrefix(pseudoPath, export.Pseudo+"/") || strings.HasPrefix(export.Pseudo, pseudoPath+"/") {
+			return errors.Wrapf(ErrPseudoPathInUse, "pseudo path %v collides with %v of volume %v", pseudoPath, export.Pseudo, export.Volume)
+		}
+	}
+	return nil
+}
+
+// exportBlockRegex matches the whole export block of the given volume and export id
+func exportBlockRegex(volume string, id uint16) *regexp.Regexp {
+	marker := "Export_Id = " + strconv.FormatUint(uint64(id), 10) + ";#Volume=" + volume
+	return regexp.MustCompile(`(?s)\nEXPORT\n\{\n\t` + regexp.QuoteMeta(marker) + `\n.*?\n\}\n`)
+}
+
+// checkFilesystemID returns an error if another export already uses the fsid
+func (e *Exporter) checkFilesystemID(volume, filesystemID string) error {
+	if filesystemID == "" {
+		return nil
+	}
+
+	exports, err := e.ListExports()
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if export.Volume != volume && export.FilesystemID == filesystemID {
+			return errors.Wrapf(ErrFilesystemIDInUse, "filesystem id %v is used by volume %v", filesystemID, export.Volume)
+		}
+	}
+	return nil
+}
+
+// ListExports returns the volume exports found in the config file
+func (e *Exporter) ListExports() ([]Export, error) {
+	e.fileMutex.Lock()
+	config, err := os.ReadFile(e.configPath)
+	e.fileMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	exports := []Export{}
+	for _, match := range exportBlocksRegex.FindAllStringSubmatch(string(config), -1) {
+		params := parseExportParams(match[1])
+		// the pseudo export has no volume marker
+		if params["Volume"] == "" {
+			continue
+		}
+
+		id, err := strconv.ParseUint(params["Export_Id"], 10, 16)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid export id for volume %v", params["Volume"])
+		}
+
+		exports = append(exports, Export{
+			Volume:       params["Volume"],
+			ExportID:     uint16(id),
+			Path:         params["Path"],
+			Pseudo:       params["Pseudo"],
+			AccessType:   params["Access_Type"],
+			FilesystemID: params["Filesystem_id"],
+		})
+	}
+
+	return exports, nil
+}
+
+// parseExportParams returns the top level parameters of an export block body,
+// parameters of nested blocks like FSAL are skipped
+func parseExportParams(body string) map[string]string {
+	params := map[string]string{}
+	for _, line := range strings.Split(body, "\n") {
+		if match := exportRegex.FindStringSubmatch(line); match != nil {
+			params["Volume"] = match[2]
+		}
+
+		match := exportParamRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		params[match[1]] = strings.TrimSpace(match[2])
+	}
+	return params
 }
 
 // getIDsFromConfig populates a map with existing ids found in the given config
@@ -234,7 +833,7 @@ func (e *Exporter) addToConfig(block string) error {
 	return nil
 }
 
-func (e *Exporter) removeFromConfig(block string) error {
+func (e *Exporter) removeFromConfig(volume string, id uint16) error {
 	e.fileMutex.Lock()
 	defer e.fileMutex.Unlock()
 
@@ -243,11 +842,29 @@ func (e *Exporter) removeFromConfig(block string) error {
 		return err
 	}
 
-	newConfig := strings.Replace(string(config), block, "", -1)
-	err = os.WriteFile(e.configPath, []byte(newConfig), 0)
+	newConfig := exportBlockRegex(volume, id).ReplaceAllString(string(config), "")
+	return e.writeConfig([]byte(newConfig))
+}
+
+// writeConfig atomically replaces the config file, the caller must hold the file mutex
+func (e *Exporter) writeConfig(config []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(e.configPath), filepath.Base(e.configPath)+".tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	return nil
+	if _, err := tmp.Write(config); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), e.configPath)
 }
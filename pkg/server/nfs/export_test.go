@@ -0,0 +1,363 @@
+package nfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+const testConfig = `NFS_Core_Param
+{
+    NLM_Port = 0;
+}
+`
+
+// newTestExporter returns an exporter of a config in a temp directory, no nfs server is running
+// for it, so every reload fails with ErrReloadFailed
+func newTestExporter(t *testing.T) (*Exporter, string) {
+	t.Helper()
+
+	configPath := filepath.Join(t.TempDir(), "vfs.conf")
+	if err := os.WriteFile(configPath, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	exporter, err := NewExporter(configPath, "/export")
+	if err != nil {
+		t.Fatalf("failed to create exporter: %v", err)
+	}
+	return exporter, configPath
+}
+
+func readTestConfig(t *testing.T, configPath string) string {
+	t.Helper()
+
+	config, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(config)
+}
+
+func TestCreateExportAndReloadRollback(t *testing.T) {
+	exporter, configPath := newTestExporter(t)
+
+	existingID, err := exporter.CreateExport("pvc-a", ExportOptions{})
+	if err != nil {
+		t.Fatalf("failed to create export: %v", err)
+	}
+	before := readTestConfig(t, configPath)
+
+	_, err = exporter.CreateExportAndReload("pvc-b", ExportOptions{})
+	if !errors.Is(err, ErrReloadFailed) {
+		t.Fatalf("expected %v, got %v", ErrReloadFailed, err)
+	}
+
+	if after := readTestConfig(t, configPath); after != before {
+		t.Fatalf("config is not rolled back, expected:\n%s\ngot:\n%s", before, after)
+	}
+	if id := exporter.GetExport("pvc-b"); id != 0 {
+		t.Fatalf("export id %v of the rolled back export is still claimed", id)
+	}
+	if id := exporter.GetExport("pvc-a"); id != existingID {
+		t.Fatalf("expected export id %v of the existing export, got %v", existingID, id)
+	}
+}
+
+func TestDeleteExportAndReloadRollback(t *testing.T) {
+	exporter, configPath := newTestExporter(t)
+
+	id, err := exporter.CreateExport("pvc-a", ExportOptions{})
+	if err != nil {
+		t.Fatalf("failed to create export: %v", err)
+	}
+	before := readTestConfig(t, configPath)
+
+	if err := exporter.DeleteExportAndReload("pvc-a"); !errors.Is(err, ErrReloadFailed) {
+		t.Fatalf("expected %v, got %v", ErrReloadFailed, err)
+	}
+
+	if after := readTestConfig(t, configPath); after != before {
+		t.Fatalf("config is not rolled back, expected:\n%s\ngot:\n%s", before, after)
+	}
+	if restored := exporter.GetExport("pvc-a"); restored != id {
+		t.Fatalf("expected export id %v to be restored, got %v", id, restored)
+	}
+}
+
+func TestRollbackReloadKeepsCause(t *testing.T) {
+	exporter, configPath := newTestExporter(t)
+
+	snapshot, err := exporter.snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := readTestConfig(t, configPath)
+
+	if _, err := exporter.CreateExport("pvc-a", ExportOptions{}); err != nil {
+		t.Fatalf("failed to create export: %v", err)
+	}
+
+	// the reload of the restored config fails as well, since no nfs server is running
+	err = exporter.rollbackReload(snapshot, ErrConfigRejected)
+	if !errors.Is(err, ErrConfigRejected) {
+		t.Fatalf("expected the cause %v, got %v", ErrConfigRejected, err)
+	}
+	if !strings.Contains(err.Error(), "failed to reload") {
+		t.Fatalf("expected the restored config to be reloaded, got %v", err)
+	}
+	if after := readTestConfig(t, configPath); after != before {
+		t.Fatalf("config is not rolled back, expected:\n%s\ngot:\n%s", before, after)
+	}
+}
+
+func uint32Ptr(v uint32) *uint32 {
+	return &v
+}
+
+func TestExportOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ExportOptions
+		invalid bool
+	}{
+		{
+			name:    "defaults",
+			options: ExportOptions{},
+		},
+		{
+			name:    "root squash",
+			options: ExportOptions{Squash: SquashRoot},
+		},
+		{
+			name:    "root squash with anonymous ids",
+			options: ExportOptions{Squash: SquashRoot, AnonymousUID: uint32Ptr(1000), AnonymousGID: uint32Ptr(1000)},
+		},
+		{
+			name:    "all squash with anonymous ids",
+			options: ExportOptions{Squash: SquashAll, AnonymousUID: uint32Ptr(0), AnonymousGID: uint32Ptr(65534)},
+		},
+		{
+			name:    "all squash without anonymous gid",
+			options: ExportOptions{Squash: SquashAll, AnonymousUID: uint32Ptr(1000)},
+			invalid: true,
+		},
+		{
+			name:    "anonymous ids without squash",
+			options: ExportOptions{AnonymousUID: uint32Ptr(1000), AnonymousGID: uint32Ptr(1000)},
+			invalid: true,
+		},
+		{
+			name:    "unknown squash",
+			options: ExportOptions{Squash: "no_root_squash"},
+			invalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.Validate()
+			if invalid := err != nil; invalid != tt.invalid {
+				t.Fatalf("expected invalid %v, got %v", tt.invalid, err)
+			}
+		})
+	}
+}
+
+func TestGenerateExportBlock(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  ExportOptions
+		contains []string
+		excludes []string
+	}{
+		{
+			name:    "defaults",
+			options: ExportOptions{},
+			contains: []string{
+				"\tExport_Id = 1;#Volume=pvc-1\n",
+				"\tPath = /export/pvc-1;\n",
+				"\tPseudo = /pvc-1;\n",
+				"\tAccess_Type = RW;\n",
+				"\tSquash = None;\n",
+				"\tSecType = sys;\n",
+				"\tFilesystem_id = 1.0;\n",
+			},
+			excludes: []string{"Disable_ACL"},
+		},
+		{
+			name:     "acl",
+			options:  ExportOptions{EnableACL: true},
+			contains: []string{"\tDisable_ACL = false;\n"},
+		},
+		{
+			name:    "all squash",
+			options: ExportOptions{Squash: SquashAll, AnonymousUID: uint32Ptr(1000), AnonymousGID: uint32Ptr(2000)},
+			contains: []string{
+				"\tSquash = All_Squash;\n",
+				"\tAnonymous_Uid = 1000;\n",
+				"\tAnonymous_Gid = 2000;\n",
+			},
+		},
+		{
+			name:     "root squash keeps the default anonymous ids",
+			options:  ExportOptions{Squash: SquashRoot},
+			contains: []string{"\tSquash = Root_Squash;\n"},
+			excludes: []string{"Anonymous_Uid", "Anonymous_Gid"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := generateExportBlock("/export", "pvc-1", 1, tt.options)
+			for _, line := range tt.contains {
+				if !strings.Contains(block, line) {
+					t.Errorf("expected %q in block:\n%s", line, block)
+				}
+			}
+			for _, line := range tt.excludes {
+				if strings.Contains(block, line) {
+					t.Errorf("unexpected %q in block:\n%s", line, block)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateExportIdempotent(t *testing.T) {
+	exporter, configPath := newTestExporter(t)
+
+	options := ExportOptions{Squash: SquashRoot}
+	id, err := exporter.CreateExport("pvc-a", options)
+	if err != nil {
+		t.Fatalf("failed to create export: %v", err)
+	}
+	if err := exporter.setBlockedClients("pvc-a", id, []string{"10.0.0.1"}); err != nil {
+		t.Fatalf("failed to block client: %v", err)
+	}
+	before := readTestConfig(t, configPath)
+
+	// a retry with the same options finds the export, whatever clients were blocked since
+	retryID, err := exporter.CreateExport("pvc-a", options)
+	if err != nil {
+		t.Fatalf("expected the identical export to be kept, got %v", err)
+	}
+	if retryID != id {
+		t.Fatalf("expected export id %v, got %v", id, retryID)
+	}
+	if after := readTestConfig(t, configPath); after != before {
+		t.Fatalf("config changed on retry, expected:\n%s\ngot:\n%s", before, after)
+	}
+
+	if _, err := exporter.CreateExport("pvc-a", ExportOptions{Squash: SquashNone}); !errors.Is(err, ErrExportConflict) {
+		t.Fatalf("expected %v for a different definition, got %v", ErrExportConflict, err)
+	}
+	if _, err := exporter.CreateExport("pvc-a", ExportOptions{Squash: SquashRoot, ExportID: id + 1}); !errors.Is(err, ErrExportIDInUse) {
+		t.Fatalf("expected %v for a different export id, got %v", ErrExportIDInUse, err)
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{name: "reload failed", err: fmt.Errorf("failed to create export: %w", ErrReloadFailed), transient: true},
+		{name: "management unavailable", err: ErrManagementUnavailable, transient: true},
+		{name: "connection refused", err: fmt.Errorf("failed to connect: %w", syscall.ECONNREFUSED), transient: true},
+		{name: "export conflict", err: ErrExportConflict},
+		{name: "config rejected", err: ErrConfigRejected},
+		{name: "no error", err: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if transient := IsTransientError(tt.err); transient != tt.transient {
+				t.Fatalf("expected transient %v, got %v for %v", tt.transient, transient, tt.err)
+			}
+		})
+	}
+}
+
+func TestRenderExport(t *testing.T) {
+	exporter, configPath := newTestExporter(t)
+
+	id, err := exporter.CreateExport("pvc-a", ExportOptions{})
+	if err != nil {
+		t.Fatalf("failed to create export: %v", err)
+	}
+	before := readTestConfig(t, configPath)
+
+	block, err := exporter.RenderExport("pvc-b", ExportOptions{Squash: SquashRoot})
+	if err != nil {
+		t.Fatalf("failed to render export: %v", err)
+	}
+	if !strings.Contains(block, "#Volume=pvc-b\n") || !strings.Contains(block, "\tSquash = Root_Squash;\n") {
+		t.Fatalf("unexpected block:\n%s", block)
+	}
+	if after := readTestConfig(t, configPath); after != before {
+		t.Fatalf("config changed by rendering, expected:\n%s\ngot:\n%s", before, after)
+	}
+	if rendered := exporter.GetExport("pvc-b"); rendered != 0 {
+		t.Fatalf("export id %v of the rendered export is claimed", rendered)
+	}
+
+	// the existing export is rendered with its id
+	block, err = exporter.RenderExport("pvc-a", ExportOptions{})
+	if err != nil {
+		t.Fatalf("failed to render export: %v", err)
+	}
+	if expected := fmt.Sprintf("\tExport_Id = %v;#Volume=pvc-a\n", id); !strings.Contains(block, expected) {
+		t.Fatalf("expected %q in block:\n%s", expected, block)
+	}
+
+	if _, err := exporter.RenderExport("pvc-a", ExportOptions{ExportID: id + 1}); !errors.Is(err, ErrExportIDInUse) {
+		t.Fatalf("expected %v for a different export id, got %v", ErrExportIDInUse, err)
+	}
+	if _, err := exporter.RenderExport("pvc-b", ExportOptions{Squash: "no_root_squash"}); err == nil {
+		t.Fatal("expected invalid options to be rejected")
+	}
+}
+
+func TestHasExport(t *testing.T) {
+	exporter, _ := newTestExporter(t)
+
+	options := ExportOptions{Squash: SquashRoot}
+	id, err := exporter.CreateExport("pvc-a", options)
+	if err != nil {
+		t.Fatalf("failed to create export: %v", err)
+	}
+	if err := exporter.setBlockedClients("pvc-a", id, []string{"fd00::1"}); err != nil {
+		t.Fatalf("failed to block client: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		volume   string
+		options  ExportOptions
+		expected bool
+	}{
+		{name: "not exported", volume: "pvc-b", options: options},
+		{name: "same options with blocked clients", volume: "pvc-a", options: options, expected: true},
+		{name: "same options with export id", volume: "pvc-a", options: ExportOptions{Squash: SquashRoot, ExportID: id}, expected: true},
+		{name: "different options", volume: "pvc-a", options: ExportOptions{Squash: SquashNone}},
+		{name: "different export id", volume: "pvc-a", options: ExportOptions{Squash: SquashRoot, ExportID: id + 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := exporter.HasExport(tt.volume, tt.options)
+			if err != nil {
+				t.Fatalf("failed to check export: %v", err)
+			}
+			if found != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, found)
+			}
+		})
+	}
+}
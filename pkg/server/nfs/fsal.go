@@ -0,0 +1,77 @@
+package nfs
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+)
+
+// FSALVFS is the FSAL used by default, it exports the local filesystem of the mounted volume
+const FSALVFS = "VFS"
+
+// validFSALNames are the ganesha FSALs an export can be backed by, the PSEUDO FSAL is
+// reserved for the pseudo filesystem root
+var validFSALNames = []string{FSALVFS, "XFS", "GLUSTER", "CEPH", "GPFS", "LUSTRE", "RGW", "PROXY_V4", "PROXY_V3", "MEM"}
+
+var fsalOptionKeyRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// ParseFSALOption parses an FSAL option in the form key=value, e.g. volume=gv0
+func ParseFSALOption(option string) (types.FSALOption, error) {
+	key, value, found := strings.Cut(option, "=")
+	if !found {
+		return types.FSALOption{}, fmt.Errorf("invalid fsal option %v, must be in the form key=value", option)
+	}
+
+	o := types.FSALOption{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value)}
+	return o, validateFSALOption(o)
+}
+
+// validateFSALOption checks that the option can be written into the config without changing its structure
+func validateFSALOption(o types.FSALOption) error {
+	if !fsalOptionKeyRegex.MatchString(o.Key) {
+		return fmt.Errorf("invalid fsal option key %q", o.Key)
+	}
+	if strings.EqualFold(o.Key, "Name") {
+		return fmt.Errorf("fsal option key %v is reserved, the fsal name is set separately", o.Key)
+	}
+	if o.Value == "" || strings.ContainsAny(o.Value, ";#{}\"\n") {
+		return fmt.Errorf("invalid value %q of fsal option %v", o.Value, o.Key)
+	}
+	return nil
+}
+
+// validateFSAL checks the fsal name and rejects invalid or duplicate options
+func validateFSAL(name string, options []types.FSALOption) error {
+	if name != "" && !slices.Contains(validFSALNames, name) {
+		return fmt.Errorf("invalid fsal %v, must be one of %v", name, validFSALNames)
+	}
+
+	keys := map[string]bool{}
+	for _, option := range options {
+		if err := validateFSALOption(option); err != nil {
+			return err
+		}
+		key := strings.ToLower(option.Key)
+		if keys[key] {
+			return fmt.Errorf("duplicate fsal option %v", option.Key)
+		}
+		keys[key] = true
+	}
+	return nil
+}
+
+// generateFSALBlock returns the FSAL block of an export, VFS if no fsal name is set
+func generateFSALBlock(name string, options []types.FSALOption) string {
+	if name == "" {
+		name = FSALVFS
+	}
+
+	block := "\tFSAL {\n\t\tName = " + name + ";\n"
+	for _, option := range options {
+		block += "\t\t" + option.Key + " = " + option.Value + ";\n"
+	}
+	return block + "\t}\n"
+}
@@ -0,0 +1,115 @@
+package nfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const keytabVersion2 = 0x0502
+
+// validateKeytab checks that the keytab is readable and contains the principal.
+// The principal can either be a full principal or the service name used by ganesha.
+func validateKeytab(keytabPath, principal string) error {
+	data, err := os.ReadFile(keytabPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read keytab %v", keytabPath)
+	}
+
+	principals, err := parseKeytabPrincipals(data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse keytab %v", keytabPath)
+	}
+
+	for _, p := range principals {
+		if p == principal || strings.SplitN(p, "/", 2)[0] == principal {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("principal %v is not found in keytab %v", principal, keytabPath)
+}
+
+// parseKeytabPrincipals returns the principals of the entries of a version 2 keytab
+func parseKeytabPrincipals(data []byte) ([]string, error) {
+	r := bytes.NewReader(data)
+
+	var version uint16
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != keytabVersion2 {
+		return nil, fmt.Errorf("unsupported keytab version %#x", version)
+	}
+
+	principals := []string{}
+	for {
+		var size int32
+		if err := binary.Read(r, binary.BigEndian, &size); err == io.EOF {
+			return principals, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		entry := make([]byte, abs(size))
+		if _, err := io.ReadFull(r, entry); err != nil {
+			return nil, err
+		}
+		// a negative size marks a deleted entry
+		if size < 0 {
+			continue
+		}
+
+		principal, err := parseKeytabPrincipal(bytes.NewReader(entry))
+		if err != nil {
+			return nil, err
+		}
+		principals = append(principals, principal)
+	}
+}
+
+func parseKeytabPrincipal(r io.Reader) (string, error) {
+	var count uint16
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return "", err
+	}
+
+	realm, err := readCountedString(r)
+	if err != nil {
+		return "", err
+	}
+
+	components := make([]string, count)
+	for i := range components {
+		if components[i], err = readCountedString(r); err != nil {
+			return "", err
+		}
+	}
+
+	return strings.Join(components, "/") + "@" + realm, nil
+}
+
+func readCountedString(r io.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+
+	value := make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func abs(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
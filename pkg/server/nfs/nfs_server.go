@@ -4,30 +4,72 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/util"
 )
 
 const (
+	// ProcessName is the executable name of the nfs server
+	ProcessName    = "ganesha.nfsd"
 	defaultPidFile = "/var/run/ganesha.pid"
+	defaultLogPath = "/tmp/ganesha.log"
+	// logMirrorInterval is how often the log file is copied to the container output
+	logMirrorInterval = 500 * time.Millisecond
+
+	defaultLeaseLifetime = 60
+	defaultGracePeriod   = 90
+	// maxLeaseLifetime, maxGracePeriod and MaxConnectionsLimit are the upper bounds accepted by ganesha
+	maxLeaseLifetime    = 180
+	maxGracePeriod      = 180
+	MaxConnectionsLimit = 10000
+	// maxDispatchRequests and maxDispatchRequestsPerConnection are the upper bounds ganesha
+	// accepts for Dispatch_Max_Reqs and Dispatch_Max_Reqs_Xprt
+	maxDispatchRequests              = 10000
+	maxDispatchRequestsPerConnection = 2048
+
+	// defaultMinorVersionFloor rejects NFSv4.0 clients, maxMinorVersion is the newest supported minor version
+	defaultMinorVersionFloor = 1
+	maxMinorVersion          = 2
+
+	// nfsPort is the fixed port of the nfs service, the auxiliary services must not use it
+	nfsPort = 2049
 )
 
 var defaultConfig = []byte(`
 NFS_Core_Param
 {
-    NLM_Port = 0;
-    MNT_Port = 0;
-    RQUOTA_Port = 0;
+    NLM_Port = {{.NLMPort}};
+    MNT_Port = {{.MountPort}};
+    RQUOTA_Port = {{.RquotaPort}};
     Enable_NLM = false;
     Enable_RQUOTA = false;
     Enable_UDP = false;
     fsid_device = false;
     Protocols = 4;
+{{- if .MaxConnections}}
+    RPC_Max_Connections = {{.MaxConnections}};
+{{- end}}
+{{- if .MaxRequests}}
+    Dispatch_Max_Reqs = {{.MaxRequests}};
+{{- end}}
+{{- if .MaxRequestsPerConnection}}
+    Dispatch_Max_Reqs_Xprt = {{.MaxRequestsPerConnection}};
+{{- end}}
+{{- if .BindAddress}}
+    Bind_Addr = {{.BindAddress}};
+{{- end}}
 }
 
 LOG {
@@ -35,6 +77,14 @@ LOG {
 
 # 	uncomment to enable debug logging
 #	COMPONENTS { NFS_V4 = FULL_DEBUG; }
+{{- if .LogComponents}}
+
+	COMPONENTS {
+{{- range $component, $level := .LogComponents}}
+		{{$component}} = {{$level}};
+{{- end}}
+	}
+{{- end}}
 
 	Facility {
 		name = FILE;
@@ -45,13 +95,46 @@ LOG {
 
 NFSV4
 {
-    Lease_Lifetime = 60;
-    Grace_Period = 90;
-    Minor_Versions = 1, 2;
-    RecoveryBackend = longhorn;
+    Lease_Lifetime = {{.LeaseLifetime}};
+    Grace_Period = {{.GracePeriod}};
+    Minor_Versions = {{.MinorVersions}};
+    RecoveryBackend = {{.RecoveryBackend}};
+{{- if .Delegations}}
+    Delegations = true;
+{{- end}}
     Only_Numeric_Owners = true;
 }
 
+{{- if .Rados}}
+
+RADOS_KV
+{
+    pool = "{{.Rados.Pool}}";
+{{- if .Rados.Namespace}}
+    namespace = "{{.Rados.Namespace}}";
+{{- end}}
+{{- if .Rados.UserID}}
+    userid = "{{.Rados.UserID}}";
+{{- end}}
+{{- if .Rados.CephConf}}
+    ceph_conf = "{{.Rados.CephConf}}";
+{{- end}}
+{{- if .Rados.NodeID}}
+    nodeid = "{{.Rados.NodeID}}";
+{{- end}}
+}
+{{- end}}
+
+{{- if .KerberosKeytab}}
+
+NFS_KRB5
+{
+    PrincipalName = {{.KerberosPrincipal}};
+    KeytabPath = {{.KerberosKeytab}};
+    Active_krb5 = true;
+}
+{{- end}}
+
 Export_defaults
 {
     Protocols = 4;
@@ -61,6 +144,23 @@ Export_defaults
     Squash = None;
 }
 
+{{- if .PseudoRoot}}
+
+# Pseudo root export, clients mount it to see all volumes under {{.PseudoRoot}}
+EXPORT
+{
+    Export_Id = 0;
+    Path = /;
+    Pseudo = /;
+    Protocols = 4;
+    Transports = TCP;
+    Access_Type = RO;
+    SecType = sys;
+    Squash = None;
+    FSAL { Name = PSEUDO; }
+}
+{{- else}}
+
 # Pseudo export, ganesha will automatically create one
 # if one is not present
 #EXPORT
@@ -75,22 +175,315 @@ Export_defaults
 #    Pseudo = /;
 #    FSAL { Name = VFS; }
 #}
+{{- end}}
 `)
 
+const (
+	RecoveryBackendLonghorn     = "longhorn"
+	RecoveryBackendRadosKV      = "rados_kv"
+	RecoveryBackendRadosNG      = "rados_ng"
+	RecoveryBackendRadosCluster = "rados_cluster"
+)
+
+var recoveryBackends = []string{RecoveryBackendLonghorn, RecoveryBackendRadosKV, RecoveryBackendRadosNG, RecoveryBackendRadosCluster}
+
+var (
+	// logComponents are the ganesha log components covering the NFS operations and exports
+	logComponents = []string{"ALL", "DISPATCH", "EXPORT", "FSAL", "NFSPROTO", "NFS_V4", "NFS_V4_LOCK",
+		"NFS4_ACL", "NFS_READDIR", "STATE", "CLIENTID", "SESSIONS", "RW_LOCK", "XPRT"}
+	logLevels = []string{"NULL", "FATAL", "MAJ", "CRIT", "WARN", "EVENT", "INFO", "DEBUG", "MID_DEBUG", "FULL_DEBUG"}
+)
+
+// ServerOptions holds the settings written into the global blocks of the ganesha config
+type ServerOptions struct {
+	// KerberosKeytab is the keytab used for Kerberos security flavors, empty disables Kerberos
+	KerberosKeytab string
+	// KerberosPrincipal is the service principal name looked up in the keytab
+	KerberosPrincipal string
+
+	// LeaseLifetime is the NFSv4 lease lifetime in seconds, zero uses the default
+	LeaseLifetime int
+	// GracePeriod is the NFSv4 grace period in seconds, zero uses the default
+	GracePeriod int
+
+	// MaxConnections caps the concurrent client connections, zero keeps the ganesha default
+	MaxConnections int
+
+	// MaxRequests caps the requests queued or in progress in the nfs server (Dispatch_Max_Reqs),
+	// and MaxRequestsPerConnection the ones of a single client connection (Dispatch_Max_Reqs_Xprt).
+	// Ganesha has no per export limit, but the server serves a single volume, so they limit the
+	// concurrent operations on it. Zero keeps the ganesha defaults of 5000 and 512.
+	MaxRequests              int
+	MaxRequestsPerConnection int
+
+	// RecoveryBackend is the store of the NFSv4 client recovery state, empty uses the local longhorn backend
+	RecoveryBackend string
+	// Rados configures the RADOS store of the rados recovery backends
+	Rados *RadosOptions
+
+	// BindAddress restricts the nfs server to a local address, empty listens on all interfaces
+	BindAddress string
+
+	// LogComponents overrides the log level of ganesha components, e.g. NFS_V4 = FULL_DEBUG
+	LogComponents map[string]string
+
+	// PseudoRoot enables an explicit pseudo root export, so clients can mount / and see the
+	// volumes exported under the pseudo root directory. Empty keeps the default layout.
+	PseudoRoot string
+
+	// LogPath is the file ganesha logs to, empty logs to /tmp/ganesha.log. When running as pid 1,
+	// the default log file is also copied to the container output.
+	LogPath string
+
+	// MinorVersionFloor is the oldest NFSv4 minor version accepted, e.g. 1 rejects NFSv4.0 clients
+	// while allowing 4.1 and 4.2. Nil keeps the default of 1.
+	MinorVersionFloor *int
+
+	// Delegations enables granting NFSv4 delegations, which exports only get if they allow them.
+	// False keeps the ganesha default of no delegations.
+	Delegations bool
+
+	// MountPort, NLMPort and RquotaPort pin the ports of the NFSv3 auxiliary services, so they can
+	// be allowed through a firewall. Zero keeps the ganesha default of a dynamic port. The rpc.statd
+	// port is not served by ganesha and is configured on the node.
+	MountPort  int
+	NLMPort    int
+	RquotaPort int
+
+	// DBusAddress is the bus ganesha registers on and is managed through, e.g.
+	// unix:path=/run/dbus/system_bus_socket, empty uses the standard system bus
+	DBusAddress string
+
+	// ReloadTimeout bounds the wait for ganesha to apply a reload of its config. The share manager
+	// calls that change the exports are blocked while waiting. Zero uses the default of 5 seconds.
+	ReloadTimeout time.Duration
+}
+
+// RadosOptions are the settings of the RADOS_KV block used by the rados recovery backends
+type RadosOptions struct {
+	Pool      string
+	Namespace string
+	UserID    string
+	// CephConf is the path of the ceph config, empty uses the ceph default
+	CephConf string
+	// NodeID identifies this server in a rados_cluster, empty uses the hostname
+	NodeID string
+}
+
+// MinorVersions returns the NFSv4 minor versions from the floor up to the newest supported one
+func (o ServerOptions) MinorVersions() string {
+	floor := defaultMinorVersionFloor
+	if o.MinorVersionFloor != nil {
+		floor = *o.MinorVersionFloor
+	}
+
+	versions := []string{}
+	for version := floor; version <= maxMinorVersion; version++ {
+		versions = append(versions, strconv.Itoa(version))
+	}
+	return strings.Join(versions, ", ")
+}
+
+func (o ServerOptions) withDefaults() ServerOptions {
+	if o.RecoveryBackend == "" {
+		o.RecoveryBackend = RecoveryBackendLonghorn
+	}
+	if o.LeaseLifetime == 0 {
+		o.LeaseLifetime = defaultLeaseLifetime
+	}
+	if o.GracePeriod == 0 {
+		o.GracePeriod = defaultGracePeriod
+	}
+	if o.LogPath == "" {
+		o.LogPath = defaultLogPath
+	}
+	if o.ReloadTimeout == 0 {
+		o.ReloadTimeout = defaultReloadTimeout
+	}
+	return o
+}
+
+// mirrorsLog reports whether the log file is copied to the container output, which is the case
+// for the default log file when running as pid 1. ganesha logs to a regular file either way, so
+// its log can be read by the share manager.
+func (o ServerOptions) mirrorsLog() bool {
+	return o.LogPath == "" && os.Getpid() == 1
+}
+
+// GetLogPath returns the file ganesha logs to
+func (o ServerOptions) GetLogPath() string {
+	return o.withDefaults().LogPath
+}
+
+// Validate checks that the server options are consistent and usable
+func (o ServerOptions) Validate() error {
+	o = o.withDefaults()
+	if o.LeaseLifetime < 1 || o.LeaseLifetime > maxLeaseLifetime {
+		return fmt.Errorf("lease lifetime %v must be between 1 and %v seconds", o.LeaseLifetime, maxLeaseLifetime)
+	}
+	if o.GracePeriod < 0 || o.GracePeriod > maxGracePeriod {
+		return fmt.Errorf("grace period %v must be between 0 and %v seconds", o.GracePeriod, maxGracePeriod)
+	}
+	if o.GracePeriod < o.LeaseLifetime {
+		return fmt.Errorf("grace period %v must not be shorter than the lease lifetime %v", o.GracePeriod, o.LeaseLifetime)
+	}
+	if err := ValidateMaxConnections(o.MaxConnections); err != nil {
+		return err
+	}
+	if err := ValidateDBusAddress(o.DBusAddress); err != nil {
+		return err
+	}
+	if o.ReloadTimeout < 0 {
+		return fmt.Errorf("reload timeout %v must not be negative", o.ReloadTimeout)
+	}
+	if err := o.validateRecoveryBackend(); err != nil {
+		return err
+	}
+	if o.MaxRequests < 0 || o.MaxRequests > maxDispatchRequests {
+		return fmt.Errorf("max requests %v must be between 0 and %v", o.MaxRequests, maxDispatchRequests)
+	}
+	if o.MaxRequestsPerConnection < 0 || o.MaxRequestsPerConnection > maxDispatchRequestsPerConnection {
+		return fmt.Errorf("max requests per connection %v must be between 0 and %v", o.MaxRequestsPerConnection, maxDispatchRequestsPerConnection)
+	}
+	if o.MaxRequests != 0 && o.MaxRequestsPerConnection > o.MaxRequests {
+		return fmt.Errorf("max requests per connection %v must not exceed the max requests %v", o.MaxRequestsPerConnection, o.MaxRequests)
+	}
+	if o.BindAddress != "" {
+		if err := validateBindAddress(o.BindAddress); err != nil {
+			return err
+		}
+	}
+	if strings.ContainsAny(o.LogPath, "\";\n") {
+		return fmt.Errorf("invalid log path %q", o.LogPath)
+	}
+	if err := ValidatePseudoRoot(o.PseudoRoot); err != nil {
+		return err
+	}
+	if err := o.validateAuxPorts(); err != nil {
+		return err
+	}
+	if floor := o.MinorVersionFloor; floor != nil && (*floor < 0 || *floor > maxMinorVersion) {
+		return fmt.Errorf("minor version floor %v must be between 0 and %v", *floor, maxMinorVersion)
+	}
+	for component, level := range o.LogComponents {
+		if !slices.Contains(logComponents, component) {
+			return fmt.Errorf("unknown log component %v", component)
+		}
+		if !slices.Contains(logLevels, level) {
+			return fmt.Errorf("invalid log level %v of component %v", level, component)
+		}
+	}
+
+	if o.KerberosKeytab == "" {
+		if o.KerberosPrincipal != "" {
+			return fmt.Errorf("kerberos principal %v is set without a keytab", o.KerberosPrincipal)
+		}
+		return nil
+	}
+
+	if o.KerberosPrincipal == "" {
+		return fmt.Errorf("kerberos keytab %v is set without a principal", o.KerberosKeytab)
+	}
+	return validateKeytab(o.KerberosKeytab, o.KerberosPrincipal)
+}
+
+func (o ServerOptions) validateRecoveryBackend() error {
+	if !slices.Contains(recoveryBackends, o.RecoveryBackend) {
+		return fmt.Errorf("invalid recovery backend %v, must be one of %v", o.RecoveryBackend, recoveryBackends)
+	}
+
+	if o.RecoveryBackend == RecoveryBackendLonghorn {
+		if o.Rados != nil {
+			return fmt.Errorf("rados settings require a rados recovery backend")
+		}
+		return nil
+	}
+
+	if o.Rados == nil || o.Rados.Pool == "" {
+		return fmt.Errorf("recovery backend %v requires a rados pool", o.RecoveryBackend)
+	}
+	for _, value := range []string{o.Rados.Pool, o.Rados.Namespace, o.Rados.UserID, o.Rados.CephConf, o.Rados.NodeID} {
+		if strings.ContainsAny(value, "\";\n") {
+			return fmt.Errorf("invalid rados setting %q", value)
+		}
+	}
+	if o.Rados.CephConf != "" {
+		if _, err := os.Stat(o.Rados.CephConf); err != nil {
+			return errors.Wrapf(err, "invalid ceph config %v", o.Rados.CephConf)
+		}
+	}
+	return nil
+}
+
+// validateAuxPorts checks that the pinned auxiliary service ports are valid and distinct
+func (o ServerOptions) validateAuxPorts() error {
+	used := map[int]string{nfsPort: "nfs"}
+	for _, aux := range []struct {
+		name string
+		port int
+	}{{"mount", o.MountPort}, {"nlm", o.NLMPort}, {"rquota", o.RquotaPort}} {
+		if aux.port == 0 {
+			continue
+		}
+		if aux.port < 1 || aux.port > 65535 {
+			return fmt.Errorf("%v port %v must be between 1 and 65535", aux.name, aux.port)
+		}
+		if service, ok := used[aux.port]; ok {
+			return fmt.Errorf("%v port %v is already used by the %v service", aux.name, aux.port, service)
+		}
+		used[aux.port] = aux.name
+	}
+	return nil
+}
+
+// ValidateMaxConnections checks the connection limit, zero means the ganesha default
+func ValidateMaxConnections(maxConnections int) error {
+	if maxConnections < 0 || maxConnections > MaxConnectionsLimit {
+		return fmt.Errorf("max connections %v must be between 0 and %v", maxConnections, MaxConnectionsLimit)
+	}
+	return nil
+}
+
+// validateBindAddress checks that the address is assigned to an interface of the node
+func validateBindAddress(address string) error {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return fmt.Errorf("invalid bind address %v", address)
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return errors.Wrap(err, "failed to list interface addresses")
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("bind address %v is not assigned to any interface", address)
+}
+
 type Server struct {
-	logger     logrus.FieldLogger
-	configPath string
-	exportPath string
-	exporter   *Exporter
+	logger      logrus.FieldLogger
+	configPath  string
+	exportPath  string
+	dbusAddress string
+	exporter    *Exporter
+	// mirrorLogPath is the log file copied to the container output, empty if it is not
+	mirrorLogPath string
 }
 
-func NewServer(logger logrus.FieldLogger, configPath, exportPath, volume string) (*Server, error) {
+func NewServer(logger logrus.FieldLogger, configPath, exportPath, volume string, options ServerOptions) (*Server, error) {
+	if err := options.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid nfs server options")
+	}
+
 	if err := setRlimitNOFILE(logger); err != nil {
 		logger.WithError(err).Warn("Error setting RLIMIT_NOFILE, there may be 'Too many open files' errors later")
 	}
 
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		if err = os.WriteFile(configPath, getUpdatedGaneshConfig(defaultConfig), 0600); err != nil {
+		if err = os.WriteFile(configPath, getUpdatedGaneshConfig(defaultConfig, options), 0600); err != nil {
 			return nil, errors.Wrapf(err, "error writing nfs config %s", configPath)
 		}
 	}
@@ -100,22 +493,44 @@ func NewServer(logger logrus.FieldLogger, configPath, exportPath, volume string)
 		return nil, errors.Wrap(err, "failed to create nfs exporter")
 	}
 
-	return &Server{
-		logger:     logger,
-		configPath: configPath,
-		exportPath: exportPath,
-		exporter:   exporter,
-	}, nil
+	setManagementEndpoint(options.DBusAddress)
+	reloadTimeout.Store(int64(options.withDefaults().ReloadTimeout))
+
+	server := &Server{
+		logger:      logger,
+		configPath:  configPath,
+		exportPath:  exportPath,
+		dbusAddress: options.DBusAddress,
+		exporter:    exporter,
+	}
+	if options.mirrorsLog() {
+		server.mirrorLogPath = options.GetLogPath()
+	}
+	return server, nil
 }
 
-func (s *Server) CreateExport(volume string) (uint16, error) {
-	return s.exporter.CreateExport(volume)
+func (s *Server) CreateExport(volume string, options ExportOptions) (uint16, error) {
+	return s.exporter.CreateExport(volume, options)
 }
 
 func (s *Server) Run(ctx context.Context) error {
 	// Start ganesha.nfsd
 	s.logger.Info("Running NFS server!")
-	cmd := exec.CommandContext(ctx, "ganesha.nfsd", "-F", "-p", defaultPidFile, "-f", s.configPath)
+	cmd := exec.CommandContext(ctx, ProcessName, "-F", "-p", defaultPidFile, "-f", s.configPath)
+	if s.dbusAddress != "" {
+		// ganesha has to register on the bus it is managed through
+		cmd.Env = append(os.Environ(), dbusSystemBusAddressEnv+"="+s.dbusAddress)
+	}
+
+	if s.mirrorLogPath != "" {
+		mirrorCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			if err := util.FollowFile(mirrorCtx, s.mirrorLogPath, os.Stdout, logMirrorInterval); err != nil {
+				s.logger.WithError(err).Warnf("Failed to copy nfs server log %v to the container output", s.mirrorLogPath)
+			}
+		}()
+	}
 
 	if out, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("ganesha.nfsd failed with error: %v, output: %s", err, out)
@@ -145,27 +560,26 @@ func setRlimitNOFILE(logger logrus.FieldLogger) error {
 	return nil
 }
 
-func getUpdatedGaneshConfig(config []byte) []byte {
-	var (
-		tmplBuf bytes.Buffer
-		logPath string
-	)
-
-	if os.Getpid() == 1 {
-		logPath = "/proc/1/fd/1"
-	} else {
-		logPath = "/tmp/ganesha.log"
-	}
+func getUpdatedGaneshConfig(config []byte, options ServerOptions) []byte {
+	var tmplBuf bytes.Buffer
 
-	tmplVals := struct {
-		LogPath string
-	}{
-		LogPath: logPath,
-	}
-
-	if err := template.Must(template.New("Ganesha_Config").Parse(string(config))).Execute(&tmplBuf, tmplVals); err != nil {
+	if err := template.Must(template.New("Ganesha_Config").Parse(string(config))).Execute(&tmplBuf, options.withDefaults()); err != nil {
 		logrus.WithError(err).Warn("Failed to parse ganesha config")
 	}
 
 	return tmplBuf.Bytes()
 }
+
+// ReadServerPid returns the pid the nfs server recorded in its pid file. The file is missing
+// if the server has never been started or has shut down cleanly.
+func ReadServerPid() (int, error) {
+	content, err := os.ReadFile(defaultPidFile)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid pid file %v", defaultPidFile)
+	}
+	return pid, nil
+}
@@ -0,0 +1,118 @@
+package nfs
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ReconcileAction is a change applied to the config to reach the desired export state
+type ReconcileAction string
+
+const (
+	ReconcileActionCreated  = ReconcileAction("created")
+	ReconcileActionUpdated  = ReconcileAction("updated")
+	ReconcileActionDeleted  = ReconcileAction("deleted")
+	ReconcileActionRecreate = ReconcileAction("recreated")
+)
+
+// ReconcileExportAndReload makes the export of the volume match the desired state and reloads
+// the nfs server once if anything changed. The blocked clients of an updated export are kept.
+// If the reload fails, the config file and export ids are rolled back.
+func (e *Exporter) ReconcileExportAndReload(volume string, exported bool, options ExportOptions) ([]ReconcileAction, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	snapshot, err := e.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	actions, err := e.reconcileExport(volume, exported, options)
+	if err != nil {
+		return nil, e.rollback(snapshot, err)
+	}
+	if len(actions) == 0 {
+		return actions, nil
+	}
+
+	if err := e.ReloadExport(); err != nil {
+		return nil, e.rollbackReload(snapshot, err)
+	}
+	return actions, nil
+}
+
+func (e *Exporter) reconcileExport(volume string, exported bool, options ExportOptions) ([]ReconcileAction, error) {
+	id := e.GetExport(volume)
+
+	switch {
+	case !exported && id == 0:
+		return nil, nil
+	case !exported:
+		if err := e.DeleteExport(volume); err != nil {
+			return nil, err
+		}
+		return []ReconcileAction{ReconcileActionDeleted}, nil
+	case id == 0:
+		if _, err := e.CreateExport(volume, options); err != nil {
+			return nil, err
+		}
+		return []ReconcileAction{ReconcileActionCreated}, nil
+	case options.ExportID != 0 && options.ExportID != id:
+		// the export id is part of the block marker, so a new id needs a new export
+		if err := e.DeleteExport(volume); err != nil {
+			return nil, err
+		}
+		if _, err := e.CreateExport(volume, options); err != nil {
+			return nil, err
+		}
+		return []ReconcileAction{ReconcileActionRecreate}, nil
+	}
+
+	updated, err := e.updateExport(volume, id, options)
+	if err != nil || !updated {
+		return nil, err
+	}
+	return []ReconcileAction{ReconcileActionUpdated}, nil
+}
+
+// updateExport rewrites the export block of the volume if it differs from the options
+func (e *Exporter) updateExport(volume string, id uint16, options ExportOptions) (bool, error) {
+	if err := e.checkFilesystemID(volume, options.FilesystemID); err != nil {
+		return false, err
+	}
+	if err := e.checkPseudoPath(volume, exportPseudoPath(volume, options)); err != nil {
+		return false, err
+	}
+
+	blockedClients, err := e.GetBlockedClients(volume)
+	if err != nil {
+		return false, err
+	}
+
+	e.fileMutex.Lock()
+	defer e.fileMutex.Unlock()
+
+	config, err := os.ReadFile(e.configPath)
+	if err != nil {
+		return false, err
+	}
+
+	blockRegex := exportBlockRegex(volume, id)
+	current := blockRegex.Find(config)
+	if current == nil {
+		return false, fmt.Errorf("export block of volume %v is not found", volume)
+	}
+
+	desired := withBlockedClients(generateExportBlock(e.exportPath, volume, id, options), blockedClients)
+	if string(current) == desired {
+		return false, nil
+	}
+
+	if err := e.writeConfig(blockRegex.ReplaceAllLiteral(config, []byte(desired))); err != nil {
+		return false, errors.Wrapf(err, "failed to update export block of volume %v", volume)
+	}
+	return true, nil
+}
@@ -0,0 +1,238 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultReloadTimeout bounds the wait for ganesha to apply a reload if no timeout is configured
+	defaultReloadTimeout = 5 * time.Second
+	// reloadPollInterval is how often ganesha is checked while waiting for a reload to be applied
+	reloadPollInterval = 100 * time.Millisecond
+	// reloadLogTimeout bounds the wait for ganesha to log the outcome of a reload
+	reloadLogTimeout = time.Second
+	// maxReloadLogBytes bounds the log output read after a reload
+	maxReloadLogBytes = 64 * 1024
+)
+
+// reloadTimeout bounds the wait for ganesha to apply a reload, it is set from the server options
+var reloadTimeout atomic.Int64
+
+func init() {
+	reloadTimeout.Store(int64(defaultReloadTimeout))
+}
+
+var (
+	logDestinationRegex = regexp.MustCompile(`\n\s*destination = "([^"]*)";`)
+	// configErrorRegex matches the config errors ganesha logs when it rereads its config, e.g.
+	// config_errs_to_log :CONFIG :CRIT :Config File (/tmp/vfs.conf:25): Unknown parameter (Foo)
+	configErrorRegex = regexp.MustCompile(`:CONFIG :(?:CRIT|MAJ|FATAL) :(.*)$`)
+	// reloadFailedRegex matches the log lines ganesha writes if it failed to apply a reread config
+	reloadFailedRegex = regexp.MustCompile(`Error while (?:parsing|processing)`)
+	// reloadDoneRegex matches the log lines ganesha writes once it is done rereading its config
+	reloadDoneRegex = regexp.MustCompile(`(?i)reread.*(?:complete|done)|Error while (?:parsing|processing)`)
+)
+
+// checkReload waits until the exports loaded by ganesha match the exports of the config and
+// returns ErrConfigRejected if they still differ after the reload timeout. The loaded exports are
+// checked over DBus, so this works no matter where ganesha logs to. The config errors ganesha
+// logged after the reload are only added to the error, since ganesha logs the errors of the whole
+// config on every reread, including the ones it tolerates. If the management interface is not
+// available, the log is checked for a failed reread instead. The config file lock is released
+// while waiting, but Mount, Unmount and the export calls of the share manager hold the server
+// lock around the reload, so a slow reload blocks them for up to the timeout plus reloadLogTimeout.
+func (e *Exporter) checkReload(logPath string, logOffset int64) error {
+	e.fileMutex.Lock()
+	configIDs, err := getIDsFromConfig(e.configPath)
+	e.fileMutex.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "failed to read export ids of the nfs server config")
+	}
+
+	expected := []uint16{}
+	for id := range configIDs {
+		expected = append(expected, id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(reloadTimeout.Load()))
+	defer cancel()
+
+	var missing, unexpected []uint16
+	for {
+		running, err := ListRunningExportIDs(ctx)
+		switch {
+		case errors.Is(err, ErrManagementUnavailable):
+			return checkReloadLog(logPath, logOffset)
+		case err == nil:
+			missing, unexpected = diffExportIDs(expected, running)
+			if len(missing) == 0 && len(unexpected) == 0 {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if missing == nil && unexpected == nil {
+				// the loaded exports could not be listed at all, the log is all there is to go by
+				return checkReloadLog(logPath, logOffset)
+			}
+			return reloadRejectedError(missing, unexpected, logPath, logOffset)
+		case <-time.After(reloadPollInterval):
+		}
+	}
+}
+
+// diffExportIDs returns the expected export ids that are not running and the running export ids
+// that are not expected. The pseudo root export 0 is not a volume export and is ignored.
+func diffExportIDs(expected, running []uint16) (missing, unexpected []uint16) {
+	missing, unexpected = []uint16{}, []uint16{}
+	for _, id := range expected {
+		if !slices.Contains(running, id) {
+			missing = append(missing, id)
+		}
+	}
+	for _, id := range running {
+		if id != 0 && !slices.Contains(expected, id) {
+			unexpected = append(unexpected, id)
+		}
+	}
+	slices.Sort(missing)
+	slices.Sort(unexpected)
+	return missing, unexpected
+}
+
+func reloadRejectedError(missing, unexpected []uint16, logPath string, logOffset int64) error {
+	reasons := []string{}
+	if len(missing) > 0 {
+		reasons = append(reasons, fmt.Sprintf("exports %v are not loaded", missing))
+	}
+	if len(unexpected) > 0 {
+		reasons = append(reasons, fmt.Sprintf("exports %v are still loaded", unexpected))
+	}
+	if output, _, err := readLogFrom(logPath, logOffset); err == nil {
+		reasons = append(reasons, parseConfigErrors(output)...)
+	}
+	return fmt.Errorf("%w: %v", ErrConfigRejected, strings.Join(reasons, "; "))
+}
+
+// getLogPath returns the log file of the nfs server from the config, empty if it does not log
+// to a regular file, e.g. to the stdout of pid 1
+func (e *Exporter) getLogPath() string {
+	e.fileMutex.Lock()
+	config, err := os.ReadFile(e.configPath)
+	e.fileMutex.Unlock()
+	if err != nil {
+		return ""
+	}
+
+	match := logDestinationRegex.FindSubmatch(config)
+	if match == nil || strings.HasPrefix(string(match[1]), "/proc/") {
+		return ""
+	}
+	return string(match[1])
+}
+
+// getLogSize returns the current size of the log file, zero if it cannot be read
+func getLogSize(logPath string) int64 {
+	if logPath == "" {
+		return 0
+	}
+	info, err := os.Stat(logPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+// checkReloadLog waits for ganesha to log the outcome of a reload and returns ErrConfigRejected
+// with the config errors it logged if it failed to apply the reread config. Only the lines logged
+// after the offset are considered. The reload is assumed to be successful if ganesha does not log
+// to a regular file.
+func checkReloadLog(logPath string, offset int64) error {
+	if logPath == "" {
+		return nil
+	}
+
+	var output string
+	var lastSize int64 = -1
+
+	deadline := time.Now().Add(reloadLogTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(reloadPollInterval)
+
+		var size int64
+		var err error
+		output, size, err = readLogFrom(logPath, offset)
+		if err != nil {
+			return nil
+		}
+
+		// ganesha logs the whole reread in a burst, so the reload is over once the log stops growing
+		if reloadDoneRegex.MatchString(output) || (size > offset && size == lastSize) {
+			break
+		}
+		lastSize = size
+	}
+
+	if !reloadFailedRegex.MatchString(output) {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrConfigRejected, strings.Join(parseConfigErrors(output), "; "))
+}
+
+// readLogFrom returns the complete log lines written after the offset and the current size of the log
+func readLogFrom(logPath string, offset int64) (string, int64, error) {
+	if logPath == "" {
+		return "", 0, os.ErrNotExist
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	// the log was rotated or truncated since the reload, everything in it is newer
+	if info.Size() < offset {
+		offset = 0
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, maxReloadLogBytes))
+	if err != nil {
+		return "", 0, err
+	}
+
+	// a line still being written is left for the next read
+	output := string(data)
+	if i := strings.LastIndexByte(output, '\n'); i >= 0 {
+		output = output[:i+1]
+	} else {
+		output = ""
+	}
+	return output, info.Size(), nil
+}
+
+// parseConfigErrors returns the config errors of the ganesha log output
+func parseConfigErrors(output string) []string {
+	configErrors := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if match := configErrorRegex.FindStringSubmatch(line); match != nil {
+			configErrors = append(configErrors, strings.TrimSpace(match[1]))
+		}
+	}
+	return configErrors
+}
@@ -0,0 +1,132 @@
+package nfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffExportIDs(t *testing.T) {
+	tests := []struct {
+		name       string
+		expected   []uint16
+		running    []uint16
+		missing    []uint16
+		unexpected []uint16
+	}{
+		{
+			name:       "applied",
+			expected:   []uint16{1, 2},
+			running:    []uint16{0, 2, 1},
+			missing:    []uint16{},
+			unexpected: []uint16{},
+		},
+		{
+			name:       "created export not loaded",
+			expected:   []uint16{1, 3},
+			running:    []uint16{0, 1},
+			missing:    []uint16{3},
+			unexpected: []uint16{},
+		},
+		{
+			name:       "deleted export still loaded",
+			expected:   []uint16{},
+			running:    []uint16{0, 5, 4},
+			missing:    []uint16{},
+			unexpected: []uint16{4, 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			missing, unexpected := diffExportIDs(tt.expected, tt.running)
+			if !reflect.DeepEqual(missing, tt.missing) {
+				t.Errorf("missing: expected %v, got %v", tt.missing, missing)
+			}
+			if !reflect.DeepEqual(unexpected, tt.unexpected) {
+				t.Errorf("unexpected: expected %v, got %v", tt.unexpected, unexpected)
+			}
+		})
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	output := "15/10/2026 07:40:01 : epoch 1 : host : ganesha.nfsd-1[sigmgr] config_errs_to_log :CONFIG :CRIT :Config File (/tmp/vfs.conf:25): Unknown parameter (Foo)\n" +
+		"15/10/2026 07:40:01 : epoch 1 : host : ganesha.nfsd-1[sigmgr] config_errs_to_log :CONFIG :WARN :Config File (/tmp/vfs.conf:30): Deprecated parameter (Bar)\n" +
+		"15/10/2026 07:40:01 : epoch 1 : host : ganesha.nfsd-1[sigmgr] reread_config :CONFIG :MAJ :Error while processing exports\n"
+
+	expected := []string{
+		"Config File (/tmp/vfs.conf:25): Unknown parameter (Foo)",
+		"Error while processing exports",
+	}
+	if configErrors := parseConfigErrors(output); !reflect.DeepEqual(configErrors, expected) {
+		t.Fatalf("expected %v, got %v", expected, configErrors)
+	}
+}
+
+func TestCheckReloadLog(t *testing.T) {
+	const staleError = "ganesha.nfsd-1[main] config_errs_to_log :CONFIG :CRIT :Config File (/tmp/vfs.conf:3): Unknown parameter (Old)\n"
+
+	tests := []struct {
+		name     string
+		before   string
+		after    string
+		rejected bool
+	}{
+		{
+			name:   "no output",
+			before: staleError,
+		},
+		{
+			name:   "stale error before the reload",
+			before: staleError,
+			after:  "ganesha.nfsd-1[sigmgr] reread_config :CONFIG :EVENT :Reread config complete\n",
+		},
+		{
+			name:  "tolerated error during the reload",
+			after: "ganesha.nfsd-1[sigmgr] config_errs_to_log :CONFIG :CRIT :Config File (/tmp/vfs.conf:3): Unknown parameter (Old)\n",
+		},
+		{
+			name: "rejected reload",
+			after: "ganesha.nfsd-1[sigmgr] config_errs_to_log :CONFIG :CRIT :Config File (/tmp/vfs.conf:25): Unknown parameter (Foo)\n" +
+				"ganesha.nfsd-1[sigmgr] reread_config :CONFIG :CRIT :Error while parsing new configuration file /tmp/vfs.conf\n",
+			rejected: true,
+		},
+		{
+			name:  "incomplete line",
+			after: "ganesha.nfsd-1[sigmgr] reread_config :CONFIG :CRIT :Error while parsing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logPath := filepath.Join(t.TempDir(), "ganesha.log")
+			if err := os.WriteFile(logPath, []byte(tt.before), 0600); err != nil {
+				t.Fatal(err)
+			}
+			offset := getLogSize(logPath)
+
+			f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.WriteString(tt.after); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			err = checkReloadLog(logPath, offset)
+			if rejected := errors.Is(err, ErrConfigRejected); rejected != tt.rejected {
+				t.Fatalf("expected rejected %v, got %v", tt.rejected, err)
+			}
+		})
+	}
+}
+
+func TestCheckReloadLogWithoutLogFile(t *testing.T) {
+	if err := checkReloadLog("", 0); err != nil {
+		t.Fatalf("expected no error without a log file, got %v", err)
+	}
+}
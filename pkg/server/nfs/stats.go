@@ -0,0 +1,131 @@
+package nfs
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const dbusExportStatsInterface = "org.ganesha.nfsd.exportstats"
+
+// ErrStatisticsDisabled is returned when the nfs server does not count the statistics of the exports
+var ErrStatisticsDisabled = errors.New("nfs server statistics are disabled")
+
+// exportIOMethods are the methods returning the I/O statistics of an export per NFSv4 minor version
+var exportIOMethods = map[string]string{
+	"4.0": "GetNFSv40IO",
+	"4.1": "GetNFSv41IO",
+	"4.2": "GetNFSv42IO",
+}
+
+// IOStatistics are the counters of the read or write operations of an export
+type IOStatistics struct {
+	RequestedBytes   uint64 `json:"requestedBytes"`
+	TransferredBytes uint64 `json:"transferredBytes"`
+	Operations       uint64 `json:"operations"`
+	Errors           uint64 `json:"errors"`
+	// LatencyNanoseconds is the total latency of the operations, divided by the operations it is
+	// the average latency
+	LatencyNanoseconds uint64 `json:"latencyNanoseconds"`
+}
+
+func (s *IOStatistics) add(other IOStatistics) {
+	s.RequestedBytes += other.RequestedBytes
+	s.TransferredBytes += other.TransferredBytes
+	s.Operations += other.Operations
+	s.Errors += other.Errors
+	s.LatencyNanoseconds += other.LatencyNanoseconds
+}
+
+// ExportStatistics are the read and write statistics of an export since the nfs server started
+type ExportStatistics struct {
+	Read  IOStatistics `json:"read"`
+	Write IOStatistics `json:"write"`
+	// Versions holds the statistics per NFSv4 minor version with activity, e.g. 4.1
+	Versions map[string]*ExportStatistics `json:"versions,omitempty"`
+}
+
+var (
+	dbusBooleanRegex = regexp.MustCompile(`(?m)^\s*boolean (true|false)$`)
+	dbusUint64Regex  = regexp.MustCompile(`(?m)^\s*uint64 ([0-9]+)$`)
+)
+
+// GetExportStatistics returns the read and write statistics of the export summed over the
+// NFSv4 minor versions. It fails with ErrStatisticsDisabled if the nfs server does not count them.
+func GetExportStatistics(ctx context.Context, exportID uint16) (*ExportStatistics, error) {
+	stats := &ExportStatistics{Versions: map[string]*ExportStatistics{}}
+	for version, method := range exportIOMethods {
+		out, err := callDBus(ctx, dbusExportMgrPath, dbusExportStatsInterface+"."+method, "uint16:"+strconv.FormatUint(uint64(exportID), 10))
+		if err != nil {
+			return nil, err
+		}
+
+		versionStats, err := parseExportIOReply(out)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse NFSv%v statistics of export %v", version, exportID)
+		}
+		if versionStats == nil {
+			continue
+		}
+
+		stats.Read.add(versionStats.Read)
+		stats.Write.add(versionStats.Write)
+		stats.Versions[version] = versionStats
+	}
+	return stats, nil
+}
+
+// parseExportIOReply parses a GetNFSv4xIO reply, which is a status, a message, a timestamp and a
+// struct of counters each for reads and writes. It returns nil if the export had no activity.
+func parseExportIOReply(reply string) (*ExportStatistics, error) {
+	status := dbusBooleanRegex.FindStringSubmatch(reply)
+	if status == nil {
+		return nil, fmt.Errorf("missing status in reply %q", reply)
+	}
+	if status[1] == "false" {
+		message := ""
+		if match := dbusStringRegex.FindStringSubmatch(reply); match != nil {
+			message = match[1]
+		}
+		if strings.Contains(strings.ToLower(message), "disabled") {
+			return nil, errors.Wrap(ErrStatisticsDisabled, message)
+		}
+		// ganesha fails the call for an export without activity of the version
+		return nil, nil
+	}
+
+	var counters []uint64
+	for _, match := range dbusUint64Regex.FindAllStringSubmatch(reply, -1) {
+		counter, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		counters = append(counters, counter)
+	}
+	// the timestamp is two counters, reads and writes have at least five each
+	if len(counters) < 12 || (len(counters)-2)%2 != 0 {
+		return nil, fmt.Errorf("unexpected counters %v in reply", counters)
+	}
+
+	perDirection := (len(counters) - 2) / 2
+	read, write := counters[2:2+perDirection], counters[2+perDirection:]
+	return &ExportStatistics{
+		Read:  ioStatisticsFromCounters(read),
+		Write: ioStatisticsFromCounters(write),
+	}, nil
+}
+
+// ioStatisticsFromCounters maps the requested, transferred, total, errors and latency counters
+func ioStatisticsFromCounters(counters []uint64) IOStatistics {
+	return IOStatistics{
+		RequestedBytes:     counters[0],
+		TransferredBytes:   counters[1],
+		Operations:         counters[2],
+		Errors:             counters[3],
+		LatencyNanoseconds: counters[4],
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os/exec"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -14,6 +16,7 @@ import (
 	"github.com/longhorn/longhorn-share-manager/pkg/crypto"
 	"github.com/longhorn/longhorn-share-manager/pkg/server/nfs"
 	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/util"
 	"github.com/longhorn/longhorn-share-manager/pkg/volume"
 
 	commonUtils "github.com/longhorn/go-common-libs/utils"
@@ -21,6 +24,11 @@ import (
 
 const waitBetweenChecks = time.Second * 5
 const healthCheckInterval = time.Second * 10
+
+const (
+	mountRetryInitialInterval = 500 * time.Millisecond
+	mountRetryMaxInterval     = 5 * time.Second
+)
 const configPath = "/tmp/vfs.conf"
 
 const (
@@ -34,20 +42,39 @@ type ShareManager struct {
 	volume        volume.Volume
 	shareExported bool
 
+	stateLock      sync.RWMutex
+	state          State
+	stateListeners []func(State)
+	shutdownHooks  []func()
+
+	// mountedDeviceSize is the size of the raw volume device when it was mounted and grownDeviceSize
+	// the size the filesystem was last grown to, so a resize before the device grew can be detected
+	mountedDeviceSize int64
+	grownDeviceSize   int64
+
 	context  context.Context
 	shutdown context.CancelFunc
 
 	nfsServer *nfs.Server
+	// pseudoRoot is the NFSv4 pseudo filesystem directory the volume is exported under
+	pseudoRoot string
 }
 
-func NewShareManager(logger logrus.FieldLogger, volume volume.Volume) (*ShareManager, error) {
+func NewShareManager(logger logrus.FieldLogger, volume volume.Volume, nfsOptions nfs.ServerOptions) (*ShareManager, error) {
 	m := &ShareManager{
 		volume: volume,
 		logger: logger.WithField("volume", volume.Name).WithField("encrypted", volume.IsEncrypted()),
+		state:  StateUnmounted,
+
+		pseudoRoot: nfsOptions.PseudoRoot,
 	}
 	m.context, m.shutdown = context.WithCancel(context.Background())
 
-	nfsServer, err := nfs.NewServer(logger, configPath, types.ExportPath, volume.Name)
+	if err := m.GetExportOptions().Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid nfs export options")
+	}
+
+	nfsServer, err := nfs.NewServer(logger, configPath, types.ExportPath, volume.Name, nfsOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -58,13 +85,13 @@ func NewShareManager(logger logrus.FieldLogger, volume volume.Volume) (*ShareMan
 func (m *ShareManager) Run() error {
 	vol := m.volume
 	mountPath := types.GetMountPath(vol.Name)
-	devicePath := types.GetVolumeDevicePath(vol.Name, false)
+	devicePath := vol.DevicePath(false)
 
 	defer func() {
+		m.runShutdownHooks()
+
 		// if the server is exiting, try to unmount & teardown device before we terminate the container
-		if err := volume.UnmountVolume(mountPath); err != nil {
-			m.logger.WithError(err).Error("Failed to unmount volume")
-		}
+		m.unmountOnShutdown(vol, mountPath)
 
 		if err := m.tearDownDevice(vol); err != nil {
 			m.logger.WithError(err).Error("Failed to tear down volume")
@@ -84,43 +111,117 @@ func (m *ShareManager) Run() error {
 				break
 			}
 
-			devicePath, err := m.setupDevice(vol, devicePath)
-			if err != nil {
-				return err
+			// a Mount request may be in progress, the volume is exported once it settles
+			if state := m.GetState(); state == StateMounting || state == StateUnmounting || state == StateWiping {
+				m.logger.Infof("Waiting with nfs server start, volume is %v", state)
+				break
 			}
 
-			if err := m.MountVolume(vol, devicePath, mountPath); err != nil {
-				m.logger.WithError(err).Warn("Failed to mount volume")
+			if err := m.mountAndExport(vol, devicePath, mountPath); err != nil {
+				var transitionErr *InvalidStateTransitionError
+				if errors.As(err, &transitionErr) {
+					m.logger.WithError(err).Info("Waiting with nfs server start, volume state changed")
+					break
+				}
 				return err
 			}
 
-			if err := m.resizeVolume(devicePath, mountPath); err != nil {
-				m.logger.WithError(err).Warn("Failed to resize volume after mount")
-				return err
+			// This blocks until server exist
+			if err := m.nfsServer.Run(m.context); err != nil {
+				m.logger.WithError(err).Error("NFS server exited with error")
 			}
+			return nil
+		}
+	}
+}
 
-			if err := volume.SetPermissions(mountPath, 0777); err != nil {
-				m.logger.WithError(err).Error("Failed to set permissions for volume")
-				return err
-			}
+// mountAndExport sets up the device, mounts it unless a Mount request already did, and exports
+// the volume. The volume state is settled back if any step fails.
+func (m *ShareManager) mountAndExport(vol volume.Volume, devicePath, mountPath string) (err error) {
+	// a Mount request without a running nfs server has set up the device and mounted the volume
+	prevState := m.GetState()
+	if prevState == StateMounted {
+		m.logger.Infof("Volume is already mounted at %v", mountPath)
+		return m.exportOnStart(vol)
+	}
 
-			m.logger.Info("Starting nfs server, volume is ready for export")
-			go m.runHealthCheck()
+	if err := m.TransitionState(StateMounting); err != nil {
+		return err
+	}
 
-			if _, err := m.nfsServer.CreateExport(vol.Name); err != nil {
-				m.logger.WithError(err).Error("Failed to create nfs export")
-				return err
-			}
+	defer func() {
+		if err != nil {
+			m.SettleState(prevState)
+			return
+		}
+		m.SettleState(StateMounted)
+	}()
 
-			m.SetShareExported(true)
+	devicePath, err = m.setupDevice(vol, devicePath)
+	if err != nil {
+		return err
+	}
 
-			// This blocks until server exist
-			if err := m.nfsServer.Run(m.context); err != nil {
-				m.logger.WithError(err).Error("NFS server exited with error")
-			}
+	if volume.CheckMountValid(mountPath) {
+		m.logger.Infof("Volume is already mounted at %v", mountPath)
+	} else {
+		if err := m.MountVolume(m.context, vol, devicePath, mountPath); err != nil {
+			m.logger.WithError(err).Warn("Failed to mount volume")
 			return err
 		}
 	}
+
+	if err := m.resizeVolume(devicePath, mountPath); err != nil {
+		m.logger.WithError(err).Warn("Failed to resize volume after mount")
+		return err
+	}
+
+	if err := volume.SetPermissions(mountPath, 0777); err != nil {
+		m.logger.WithError(err).Error("Failed to set permissions for volume")
+		return err
+	}
+
+	return m.exportOnStart(vol)
+}
+
+// exportOnStart adds the export of the mounted volume to the config the nfs server is started with
+func (m *ShareManager) exportOnStart(vol volume.Volume) error {
+	m.logger.Info("Starting nfs server, volume is ready for export")
+	go m.runHealthCheck()
+
+	if _, err := m.nfsServer.CreateExport(vol.Name, m.GetExportOptions()); err != nil {
+		m.logger.WithError(err).Error("Failed to create nfs export")
+		return err
+	}
+
+	m.SetShareExported(true)
+	return nil
+}
+
+// unmountOnShutdown unmounts the volume when the share manager exits, the state is settled to
+// unmounted or back to the previous state if the unmount fails
+func (m *ShareManager) unmountOnShutdown(vol volume.Volume, mountPath string) {
+	prevState := m.GetState()
+	if prevState == StateUnmounted {
+		// nothing to settle, a mount left behind is still cleaned up
+		prevState = ""
+	} else if err := m.TransitionState(StateUnmounting); err != nil {
+		m.logger.WithError(err).Warn("Unmounting volume on shutdown from unexpected state")
+		prevState = ""
+	}
+
+	err := volume.UnmountVolume(mountPath)
+	if err != nil {
+		m.logger.WithError(err).Error("Failed to unmount volume")
+	}
+	if prevState == "" {
+		return
+	}
+	if err != nil {
+		m.SettleState(prevState)
+		return
+	}
+	m.SettleState(StateUnmounted)
 }
 
 // setupDevice will return a path where the device file can be found
@@ -138,17 +239,33 @@ func (m *ShareManager) setupDevice(vol volume.Volume, devicePath string) (string
 			return "", fmt.Errorf("missing passphrase for encrypted volume %v", vol.Name)
 		}
 
+		// with a detached header the device holds no signature, the header tells if it is formatted
+		needsFormat := diskFormat == ""
+		if vol.CryptoHeaderPath != "" {
+			hasHeader, err := crypto.IsLuksHeader(vol.CryptoHeaderPath)
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to check LUKS header %v", vol.CryptoHeaderPath)
+			}
+			if !hasHeader && diskFormat != "" {
+				return "", fmt.Errorf("device %v contains %v but the LUKS header %v is empty", devicePath, diskFormat, vol.CryptoHeaderPath)
+			}
+			needsFormat = !hasHeader
+		}
+
 		// initial setup of longhorn device for crypto
-		if diskFormat == "" {
+		if needsFormat {
+			if err := vol.PassphrasePolicy.Check(vol.Passphrase); err != nil {
+				return "", errors.Wrapf(err, "refusing to encrypt volume %v", vol.Name)
+			}
 			m.logger.Info("Encrypting new volume before first use")
-			if err := crypto.EncryptVolume(devicePath, vol.Passphrase, vol.CryptoKeyCipher, vol.CryptoKeyHash, vol.CryptoKeySize, vol.CryptoPBKDF); err != nil {
+			if err := crypto.EncryptVolume(devicePath, vol.Passphrase, vol.CryptoKeyCipher, vol.CryptoKeyHash, vol.CryptoKeySize, vol.CryptoPBKDF, vol.CryptoHeaderPath, vol.CryptoTimeout); err != nil {
 				return "", errors.Wrapf(err, "failed to encrypt volume %v", vol.Name)
 			}
 		}
 
-		cryptoDevice := types.GetVolumeDevicePath(vol.Name, true)
+		cryptoDevice := vol.DevicePath(true)
 		m.logger.Infof("Volume %s requires crypto device %s", vol.Name, cryptoDevice)
-		if err := crypto.OpenVolume(vol.Name, devicePath, vol.Passphrase); err != nil {
+		if err := crypto.OpenVolume(vol.CryptoMappingName(), devicePath, vol.Passphrase, vol.CryptoHeaderPath, vol.CryptoTimeout); err != nil {
 			m.logger.WithError(err).Error("Failed to open encrypted volume")
 			return "", err
 		}
@@ -160,14 +277,25 @@ func (m *ShareManager) setupDevice(vol volume.Volume, devicePath string) (string
 	return devicePath, nil
 }
 
+// SetupDevice opens the crypto device of an encrypted volume, encrypting a new volume first,
+// and returns the path of the device to mount
+func (m *ShareManager) SetupDevice(devicePath string) (string, error) {
+	return m.setupDevice(m.volume, devicePath)
+}
+
+// TearDownDevice closes the crypto device of the volume if it is open
+func (m *ShareManager) TearDownDevice() error {
+	return m.tearDownDevice(m.volume)
+}
+
 func (m *ShareManager) tearDownDevice(vol volume.Volume) error {
 	// close any matching crypto device for this volume
-	cryptoDevice := types.GetVolumeDevicePath(vol.Name, true)
+	cryptoDevice := vol.DevicePath(true)
 	if isOpen, err := crypto.IsDeviceOpen(cryptoDevice); err != nil {
 		return err
 	} else if isOpen {
 		m.logger.Infof("Volume %s has active crypto device %s", vol.Name, cryptoDevice)
-		if err := crypto.CloseVolume(vol.Name); err != nil {
+		if err := crypto.CloseVolume(vol.CryptoMappingName(), vol.CryptoTimeout); err != nil {
 			return err
 		}
 		m.logger.Infof("Volume %s closed active crypto device %s", vol.Name, cryptoDevice)
@@ -176,7 +304,83 @@ func (m *ShareManager) tearDownDevice(vol volume.Volume) error {
 	return nil
 }
 
-func (m *ShareManager) MountVolume(vol volume.Volume, devicePath, mountPath string) error {
+// MountVolume formats and mounts the device of the volume. The mount is attempted up to
+// vol.MountAttempts times with backoff while it fails since the device is not ready, e.g. while
+// udev settles after an attach, while a corrupt filesystem fails right away.
+func (m *ShareManager) MountVolume(ctx context.Context, vol volume.Volume, devicePath, mountPath string) error {
+	diskFormat, fsType, err := m.mountDeviceWithRetry(ctx, vol, devicePath, mountPath)
+	if err != nil {
+		return err
+	}
+
+	// an empty disk format means the device has just been formatted by the mount
+	if diskFormat == "" && vol.ReservedBlocksPercentage > 0 && strings.HasPrefix(fsType, "ext") {
+		if err := volume.SetReservedBlocksPercentage(ctx, devicePath, vol.ReservedBlocksPercentage); err != nil {
+			m.logger.WithError(err).Warnf("Failed to reserve %v%% of the blocks on the new filesystem", vol.ReservedBlocksPercentage)
+			return err
+		}
+		m.logger.Infof("Reserved %v%% of the blocks on the new filesystem", vol.ReservedBlocksPercentage)
+	}
+
+	if diskFormat == "" && vol.ReservedSpace > 0 {
+		if err := volume.ReserveSpace(devicePath, mountPath, vol.ReservedSpace); err != nil {
+			m.logger.WithError(err).Warnf("Failed to reserve %v bytes on the new filesystem", vol.ReservedSpace)
+			return err
+		}
+		m.logger.Infof("Reserved %v bytes on the new filesystem", vol.ReservedSpace)
+	}
+
+	m.recordMountedDeviceSize(vol)
+
+	if vol.VerifyFingerprint {
+		if err := m.verifyFingerprint(vol, mountPath); err != nil {
+			if unmountErr := volume.UnmountVolume(mountPath); unmountErr != nil {
+				m.logger.WithError(unmountErr).Warn("Failed to unmount filesystem that failed the fingerprint check")
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *ShareManager) mountDeviceWithRetry(ctx context.Context, vol volume.Volume, devicePath, mountPath string) (diskFormat, fsType string, err error) {
+	log := m.logger.WithField("volume", vol.Name)
+	err = retryMount(ctx, log, vol.MountAttempts, func() error {
+		var err error
+		diskFormat, fsType, err = m.mountDevice(ctx, vol, devicePath, mountPath)
+		return err
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return diskFormat, fsType, nil
+}
+
+// retryMount runs the mount up to the given attempts with backoff while its classified error is
+// ErrDeviceNotReady, and returns the classified error of the last attempt
+func retryMount(ctx context.Context, log logrus.FieldLogger, attempts int, mount func() error) error {
+	attempts = max(attempts, 1)
+	interval := mountRetryInitialInterval
+	for attempt := 1; ; attempt++ {
+		err := volume.ClassifyMountError(mount())
+		if err == nil || !errors.Is(err, volume.ErrDeviceNotReady) || attempt >= attempts {
+			return err
+		}
+
+		log.WithError(err).Warnf("Failed to mount in attempt %v of %v, retrying in %v", attempt, attempts, interval)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		interval = min(2*interval, mountRetryMaxInterval)
+	}
+}
+
+// mountDevice mounts the device with the filesystem it is formatted with, or formats it first,
+// and returns the disk format found before the mount and the filesystem type mounted
+func (m *ShareManager) mountDevice(ctx context.Context, vol volume.Volume, devicePath, mountPath string) (string, string, error) {
 	fsType := vol.FsType
 	mountOptions := vol.MountOptions
 
@@ -187,7 +391,7 @@ func (m *ShareManager) MountVolume(vol volume.Volume, devicePath, mountPath stri
 	diskFormat, err := volume.GetDiskFormat(devicePath)
 	if err != nil {
 		m.logger.WithError(err).Error("Failed to evaluate disk format")
-		return err
+		return "", "", err
 	}
 
 	// `unknown data, probably partitions` is used when the disk contains a partition table
@@ -196,15 +400,104 @@ func (m *ShareManager) MountVolume(vol volume.Volume, devicePath, mountPath stri
 		fsType = diskFormat
 	}
 
-	return volume.MountVolume(devicePath, mountPath, fsType, mountOptions)
+	if vol.EnableACL {
+		mountOptions = volume.ACLMountOptions(fsType, mountOptions)
+	}
+
+	if vol.Compression != "" {
+		if fsType == "btrfs" {
+			mountOptions = volume.CompressionMountOptions(vol.Compression, mountOptions)
+		} else {
+			m.logger.Warnf("Ignoring compression %v since the volume is formatted as %v", vol.Compression, fsType)
+		}
+	}
+
+	if vol.MountContext != "" {
+		mountOptions = volume.ContextMountOptions(vol.MountContext, mountOptions)
+	}
+
+	// the journal tuning is checked against the detected filesystem, which can differ from the requested one
+	mountOptions, err = volume.JournalMountOptions(fsType, vol, mountOptions)
+	if err != nil {
+		return "", "", err
+	}
+
+	if vol.Discard && !slices.Contains(mountOptions, "discard") {
+		mountOptions = append(slices.Clone(mountOptions), "discard")
+	}
+
+	var formatOptions []string
+	if diskFormat == "" {
+		if formatOptions, err = volume.FormatOptions(fsType, vol); err != nil {
+			return "", "", err
+		}
+	}
+
+	if err := volume.MountVolume(ctx, devicePath, mountPath, fsType, mountOptions, formatOptions); err != nil {
+		return "", "", err
+	}
+	return diskFormat, fsType, nil
+}
+
+// verifyFingerprint checks that the mounted filesystem was created for the volume. A filesystem
+// without a fingerprint, either just formatted or created before the check was enabled, gets one.
+func (m *ShareManager) verifyFingerprint(vol volume.Volume, mountPath string) error {
+	found, err := volume.CheckFingerprint(mountPath, vol.UID)
+	if err != nil {
+		m.logger.WithError(err).Error("Refusing to use filesystem that failed the fingerprint check")
+		return err
+	}
+	if found {
+		return nil
+	}
+
+	if err := volume.WriteFingerprint(mountPath, vol.UID); err != nil {
+		return errors.Wrap(err, "failed to write the volume fingerprint")
+	}
+	m.logger.Infof("Stored volume UID %v as the fingerprint of the filesystem", vol.UID)
+	return nil
+}
+
+func (m *ShareManager) recordMountedDeviceSize(vol volume.Volume) {
+	size, err := util.GetDeviceSize(vol.DevicePath(false))
+	if err != nil {
+		m.logger.WithError(err).Warn("Failed to get device size of mounted volume")
+	}
+
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	m.mountedDeviceSize = size
+	m.grownDeviceSize = 0
+}
+
+// RecordGrownDeviceSize records the current size of the raw volume device as the one the filesystem was grown to
+func (m *ShareManager) RecordGrownDeviceSize() {
+	size, err := util.GetDeviceSize(m.volume.DevicePath(false))
+	if err != nil {
+		m.logger.WithError(err).Warn("Failed to get device size of resized volume")
+		return
+	}
+
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	m.grownDeviceSize = size
+}
+
+// GetDeviceSizes returns the size of the raw volume device when it was mounted and the size the
+// filesystem was last grown to since, zero if unknown or not grown
+func (m *ShareManager) GetDeviceSizes() (mounted, grown int64) {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	return m.mountedDeviceSize, m.grownDeviceSize
 }
 
 func (m *ShareManager) resizeVolume(devicePath, mountPath string) error {
-	if resized, err := volume.ResizeVolume(devicePath, mountPath); err != nil {
+	if resized, _, err := volume.ResizeVolume(m.context, devicePath, mountPath, false); err != nil {
 		m.logger.WithError(err).Error("Failed to resize filesystem for volume")
 		return err
 	} else if resized {
 		m.logger.Info("Resized filesystem for volume after mount")
+		m.RecordGrownDeviceSize()
 	}
 
 	return nil
@@ -255,6 +548,34 @@ func (m *ShareManager) hasHealthyVolume() error {
 	return nil
 }
 
+// GetDegradedReason returns why the mounted filesystem is in an unexpected state, e.g. remounted
+// read only by the kernel after filesystem errors or the crypto mapping of an encrypted volume torn
+// down underneath it, and an empty string if it is healthy or not mounted. It reads /proc/mounts
+// and the crypto device status only, so it is cheap enough for frequent probing.
+func (m *ShareManager) GetDegradedReason() string {
+	if m.GetState() != StateMounted {
+		return ""
+	}
+
+	mountPath := types.GetMountPath(m.volume.Name)
+	mp, err := volume.GetMountPoint(mountPath)
+	if err != nil {
+		return fmt.Sprintf("failed to list mount points: %v", err)
+	}
+	if mp == nil {
+		return fmt.Sprintf("volume is not mounted at %v", mountPath)
+	}
+	if commonUtils.IsMountPointReadOnly(*mp) && !slices.Contains(m.volume.MountOptions, "ro") {
+		return fmt.Sprintf("volume mounted at %v is unexpectedly read only", mountPath)
+	}
+	if m.volume.IsEncrypted() {
+		if _, err := crypto.GetDeviceStatus(m.volume.CryptoMappingName()); err != nil {
+			return fmt.Sprintf("crypto mapping of volume mounted at %v is not active: %v", mountPath, err)
+		}
+	}
+	return ""
+}
+
 func (m *ShareManager) recoverReadOnlyVolume() error {
 	mountPath := types.GetMountPath(m.volume.Name)
 
@@ -270,14 +591,70 @@ func (m *ShareManager) GetVolume() volume.Volume {
 	return m.volume
 }
 
+// GetExportOptions returns the nfs export settings of the volume
+func (m *ShareManager) GetExportOptions() nfs.ExportOptions {
+	options := nfs.ExportOptions{
+		EnableACL: m.volume.EnableACL,
+		SecTypes:  m.volume.NFSSecTypes,
+		ExportID:  m.volume.NFSExportID,
+
+		Squash:       m.volume.NFSSquash,
+		AnonymousUID: m.volume.NFSAnonymousUID,
+		AnonymousGID: m.volume.NFSAnonymousGID,
+		AccessRules:  m.volume.NFSAccessRules,
+
+		PseudoRoot: m.pseudoRoot,
+
+		MaxRead:  m.volume.NFSMaxRead,
+		MaxWrite: m.volume.NFSMaxWrite,
+
+		FSALName:    m.volume.NFSFSALName,
+		FSALOptions: m.volume.NFSFSALOptions,
+
+		Delegations: m.volume.NFSDelegations,
+	}
+
+	if m.volume.UID != "" {
+		options.FilesystemID = nfs.FilesystemIDFromUID(m.volume.UID)
+	}
+
+	return options
+}
+
 func (m *ShareManager) SetShareExported(val bool) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
 	m.shareExported = val
 }
 
 func (m *ShareManager) ShareIsExported() bool {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
 	return m.shareExported
 }
 
+// Context returns the context that is cancelled when the share manager shuts down
+func (m *ShareManager) Context() context.Context {
+	return m.context
+}
+
+// OnShutdown registers a function called when the share manager exits, before the volume is unmounted
+func (m *ShareManager) OnShutdown(fn func()) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	m.shutdownHooks = append(m.shutdownHooks, fn)
+}
+
+func (m *ShareManager) runShutdownHooks() {
+	m.stateLock.RLock()
+	hooks := m.shutdownHooks
+	m.stateLock.RUnlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
 func (m *ShareManager) Shutdown() {
 	m.shutdown()
 }
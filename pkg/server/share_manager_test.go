@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/volume"
+)
+
+func TestRetryMount(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	notReady := errors.New("mount failed: exit status 32, output: special device /dev/sdb does not exist")
+	corrupt := errors.New("mount failed: exit status 32, output: Structure needs cleaning")
+
+	tests := []struct {
+		name        string
+		maxAttempts int
+		errs        []error
+		attempts    int
+		expected    error
+	}{
+		{name: "success", maxAttempts: 3, errs: []error{nil}, attempts: 1},
+		{name: "device not ready then success", maxAttempts: 3, errs: []error{notReady, nil}, attempts: 2},
+		{name: "device never ready", maxAttempts: 2, errs: []error{notReady}, attempts: 2, expected: volume.ErrDeviceNotReady},
+		{name: "corrupt filesystem", maxAttempts: 3, errs: []error{corrupt}, attempts: 1, expected: volume.ErrFilesystemCorrupt},
+		{name: "single attempt by default", maxAttempts: 0, errs: []error{notReady}, attempts: 1, expected: volume.ErrDeviceNotReady},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := retryMount(context.Background(), log, tt.maxAttempts, func() error {
+				err := tt.errs[min(attempts, len(tt.errs)-1)]
+				attempts++
+				return err
+			})
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, err)
+			}
+			if attempts != tt.attempts {
+				t.Fatalf("expected %v attempts, got %v", tt.attempts, attempts)
+			}
+		})
+	}
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := retryMount(ctx, log, 3, func() error { return notReady })
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected %v, got %v", context.Canceled, err)
+		}
+	})
+}
+
+func TestTransitionState(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	tests := []struct {
+		from    State
+		to      State
+		invalid bool
+	}{
+		{from: StateUnmounted, to: StateMounting},
+		{from: StateUnmounted, to: StateWiping},
+		{from: StateUnmounted, to: StateUnmounting, invalid: true},
+		{from: StateUnmounted, to: StateMounted, invalid: true},
+		{from: StateMounting, to: StateMounted},
+		{from: StateMounting, to: StateUnmounted},
+		{from: StateMounting, to: StateMounting, invalid: true},
+		{from: StateMounting, to: StateUnmounting, invalid: true},
+		{from: StateMounted, to: StateUnmounting},
+		{from: StateMounted, to: StateWiping},
+		{from: StateMounted, to: StateMounting, invalid: true},
+		{from: StateMounted, to: StateUnmounted, invalid: true},
+		{from: StateUnmounting, to: StateUnmounted},
+		{from: StateUnmounting, to: StateMounted},
+		{from: StateUnmounting, to: StateMounting, invalid: true},
+		{from: StateWiping, to: StateUnmounted},
+		{from: StateWiping, to: StateMounted},
+		{from: StateWiping, to: StateMounting, invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+" to "+string(tt.to), func(t *testing.T) {
+			m := &ShareManager{logger: log, state: tt.from}
+
+			var notified []State
+			m.OnStateChange(func(state State) {
+				notified = append(notified, state)
+			})
+
+			err := m.TransitionState(tt.to)
+			if invalid := err != nil; invalid != tt.invalid {
+				t.Fatalf("expected invalid %v, got %v", tt.invalid, err)
+			}
+
+			expected, expectedNotified := tt.to, []State{tt.to}
+			if tt.invalid {
+				var transitionErr *InvalidStateTransitionError
+				if !errors.As(err, &transitionErr) {
+					t.Fatalf("expected an invalid state transition error, got %v", err)
+				}
+				expected, expectedNotified = tt.from, nil
+			}
+			if state := m.GetState(); state != expected {
+				t.Fatalf("expected state %v, got %v", expected, state)
+			}
+			if !reflect.DeepEqual(notified, expectedNotified) {
+				t.Fatalf("expected notified states %v, got %v", expectedNotified, notified)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+)
+
+// State is the mount lifecycle state of the shared volume
+type State string
+
+const (
+	StateUnmounted  = State("unmounted")
+	StateMounting   = State("mounting")
+	StateMounted    = State("mounted")
+	StateUnmounting = State("unmounting")
+	StateWiping     = State("wiping")
+)
+
+// validStateTransitions lists the states reachable from each state.
+// A new operation can only start from a stable state (unmounted or mounted), and only if it
+// changes that state, so a Mount of a mounted volume or an Unmount of an unmounted one is
+// rejected. An in progress operation can only settle into a stable state.
+var validStateTransitions = map[State][]State{
+	StateUnmounted:  {StateMounting, StateWiping},
+	StateMounted:    {StateUnmounting, StateWiping},
+	StateMounting:   {StateMounted, StateUnmounted},
+	StateUnmounting: {StateUnmounted, StateMounted},
+	StateWiping:     {StateUnmounted, StateMounted},
+}
+
+// InvalidStateTransitionError is returned when a transition is not allowed from the current state
+type InvalidStateTransitionError struct {
+	From State
+	To   State
+}
+
+func (e *InvalidStateTransitionError) Error() string {
+	return fmt.Sprintf("invalid state transition from %v to %v", e.From, e.To)
+}
+
+func (m *ShareManager) GetState() State {
+	m.stateLock.RLock()
+	defer m.stateLock.RUnlock()
+	return m.state
+}
+
+// OnStateChange registers a function called with the new state after each transition
+func (m *ShareManager) OnStateChange(fn func(State)) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+	m.stateListeners = append(m.stateListeners, fn)
+}
+
+// TransitionState moves the volume into the given state if the transition is valid
+func (m *ShareManager) TransitionState(to State) error {
+	listeners, err := m.transitionState(to)
+	if err != nil {
+		return err
+	}
+
+	// the listeners are called without the lock, so they can query the state
+	for _, fn := range listeners {
+		fn(to)
+	}
+	return nil
+}
+
+func (m *ShareManager) transitionState(to State) ([]func(State), error) {
+	m.stateLock.Lock()
+	defer m.stateLock.Unlock()
+
+	for _, s := range validStateTransitions[m.state] {
+		if s == to {
+			m.logger.Debugf("Transitioning volume state from %v to %v", m.state, to)
+			m.state = to
+			return m.stateListeners, nil
+		}
+	}
+
+	return nil, &InvalidStateTransitionError{From: m.state, To: to}
+}
+
+// SettleState moves the volume out of an in progress state once an operation is done
+func (m *ShareManager) SettleState(state State) {
+	if err := m.TransitionState(state); err != nil {
+		m.logger.WithError(err).Warnf("Failed to settle volume state to %v", state)
+	}
+}
@@ -13,8 +13,22 @@ const (
 	MapperDevPath = "/dev/mapper"
 
 	ExportPath = "/export"
+
+	ReadOnlyBindMountPath = "/export-ro"
 )
 
+// AccessRule grants a client, an IP address or a CIDR, a specific access to the export
+type AccessRule struct {
+	Client     string
+	AccessType string
+}
+
+// FSALOption is a parameter written into the FSAL block of an export
+type FSALOption struct {
+	Key   string
+	Value string
+}
+
 func GetVolumeDevicePath(volumeName string, EncryptedDevice bool) string {
 	if EncryptedDevice {
 		return path.Join(MapperDevPath, volumeName)
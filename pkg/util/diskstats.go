@@ -0,0 +1,99 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	diskStatsPath = "/proc/diskstats"
+
+	diskStatsSectorSize = 512
+)
+
+// DiskStats holds the cumulative I/O counters of a block device from /proc/diskstats
+type DiskStats struct {
+	Device       string
+	ReadIOs      uint64
+	ReadBytes    uint64
+	WriteIOs     uint64
+	WriteBytes   uint64
+	IOTimeMillis uint64
+	Time         time.Time
+}
+
+// GetDiskStats returns the I/O counters of the block device at the given path.
+// The device is looked up by its device number, so it works for any device node
+// or symlink, e.g. the v1 data engine device, the v2 NVMe device or a crypto device.
+func GetDiskStats(devicePath string) (*DiskStats, error) {
+	deviceNumber, err := GetDeviceNumber(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	major := unix.Major(uint64(deviceNumber))
+	minor := unix.Minor(uint64(deviceNumber))
+
+	f, err := os.Open(diskStatsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// major minor name and at least the 11 original counters
+		if len(fields) < 14 {
+			continue
+		}
+		if fields[0] != strconv.FormatUint(uint64(major), 10) || fields[1] != strconv.FormatUint(uint64(minor), 10) {
+			continue
+		}
+		return parseDiskStats(fields)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("device %v (%v:%v) is not found in %v", devicePath, major, minor, diskStatsPath)
+}
+
+func parseDiskStats(fields []string) (*DiskStats, error) {
+	values := make([]uint64, 11)
+	for i := range values {
+		v, err := strconv.ParseUint(fields[i+3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid disk stats of device %v: %v", fields[2], err)
+		}
+		values[i] = v
+	}
+
+	return &DiskStats{
+		Device:       fields[2],
+		ReadIOs:      values[0],
+		ReadBytes:    values[2] * diskStatsSectorSize,
+		WriteIOs:     values[4],
+		WriteBytes:   values[6] * diskStatsSectorSize,
+		IOTimeMillis: values[9],
+		Time:         time.Now(),
+	}, nil
+}
+
+// Utilization returns the percentage of time the device was busy with I/O since the previous sample
+func (s *DiskStats) Utilization(prev *DiskStats) float64 {
+	elapsed := s.Time.Sub(prev.Time).Milliseconds()
+	if elapsed <= 0 || s.IOTimeMillis < prev.IOTimeMillis {
+		return 0
+	}
+	utilization := float64(s.IOTimeMillis-prev.IOTimeMillis) * 100 / float64(elapsed)
+	if utilization > 100 {
+		return 100
+	}
+	return utilization
+}
@@ -1,6 +1,11 @@
 package util
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
 	"golang.org/x/sys/unix"
 )
 
@@ -16,3 +21,71 @@ func GetDeviceNumber(path string) (DeviceNumber, error) {
 	}
 	return DeviceNumber(stat.Rdev), nil
 }
+
+// GetDeviceSize returns the size in bytes of the block device at the given path.
+func GetDeviceSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return f.Seek(0, io.SeekEnd)
+}
+
+// GetBlockDeviceName returns the kernel name of the block device at the given path, e.g. sda or nvme0n1.
+// The name is looked up by device number, so device nodes created outside of /dev are resolved too.
+func GetBlockDeviceName(path string) (string, error) {
+	deviceNumber, err := GetDeviceNumber(path)
+	if err != nil {
+		return "", err
+	}
+
+	sysPath := fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(uint64(deviceNumber)), unix.Minor(uint64(deviceNumber)))
+	target, err := os.Readlink(sysPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(target), nil
+}
+
+// IsDeviceStackedOn returns true if the block device with the device number is layered on top of the
+// block device at the given path, e.g. a device mapper or multipath device built on it. The layers
+// are followed through the slaves directories in sysfs.
+func IsDeviceStackedOn(deviceNumber uint64, lowerPath string) (bool, error) {
+	lowerName, err := GetBlockDeviceName(lowerPath)
+	if err != nil {
+		return false, err
+	}
+
+	sysPath := fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(deviceNumber), unix.Minor(deviceNumber))
+	return hasSlave("/sys/class/block", sysPath, lowerName, map[string]bool{})
+}
+
+// hasSlave returns true if the device at the sysfs path has the named device among its slaves,
+// directly or through further layers, which are looked up in the sysfs block class directory
+func hasSlave(classPath, sysPath, name string, visited map[string]bool) (bool, error) {
+	entries, err := os.ReadDir(filepath.Join(sysPath, "slaves"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, entry := range entries {
+		slave := entry.Name()
+		if slave == name {
+			return true, nil
+		}
+		if visited[slave] {
+			continue
+		}
+		visited[slave] = true
+		found, err := hasSlave(classPath, filepath.Join(classPath, slave), name, visited)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHasSlave(t *testing.T) {
+	// a fake sysfs block class: dm-1 is layered on dm-0, which is layered on sdb,
+	// and dm-2 and dm-3 are slaves of each other
+	classPath := t.TempDir()
+	for device, slaves := range map[string][]string{
+		"sda":  nil,
+		"sdb":  nil,
+		"dm-0": {"sdb"},
+		"dm-1": {"dm-0"},
+		"dm-2": {"dm-3"},
+		"dm-3": {"dm-2"},
+	} {
+		if err := os.MkdirAll(filepath.Join(classPath, device), 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, slave := range slaves {
+			if err := os.MkdirAll(filepath.Join(classPath, device, "slaves", slave), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		device  string
+		lower   string
+		stacked bool
+	}{
+		{device: "dm-0", lower: "sdb", stacked: true},
+		{device: "dm-1", lower: "sdb", stacked: true},
+		{device: "dm-1", lower: "sda"},
+		{device: "sdb", lower: "sdb"},
+		{device: "dm-2", lower: "sda"},
+	}
+
+	for _, tt := range tests {
+		stacked, err := hasSlave(classPath, filepath.Join(classPath, tt.device), tt.lower, map[string]bool{})
+		if err != nil {
+			t.Fatalf("%v on %v: %v", tt.device, tt.lower, err)
+		}
+		if stacked != tt.stacked {
+			t.Errorf("%v on %v: expected stacked %v, got %v", tt.device, tt.lower, tt.stacked, stacked)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// clockTicksPerSecond is USER_HZ, the unit of the cpu times in /proc/<pid>/stat. It is 100 on
+// all architectures supported by Linux, so it is not looked up with sysconf.
+const clockTicksPerSecond = 100
+
+// ProcessStats holds the cumulative cpu time and the memory usage of a process from /proc/<pid>/stat
+type ProcessStats struct {
+	Pid            int
+	CPUSeconds     float64
+	ResidentBytes  uint64
+	VirtualBytes   uint64
+	Threads        int64
+	StartTimeTicks uint64
+	Time           time.Time
+}
+
+// GetProcessStats returns the resource usage of the process with the given pid
+func GetProcessStats(pid int) (*ProcessStats, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	// the command name is in parentheses and may contain spaces, the fields follow the last one
+	stat := string(data)
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return nil, fmt.Errorf("invalid stat of process %v", pid)
+	}
+	// fields start with the state, the third field of the stat file
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid stat of process %v: only %v fields", pid, len(fields)+2)
+	}
+
+	parse := func(index int) (uint64, error) {
+		value, err := strconv.ParseUint(fields[index-3], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid field %v in stat of process %v: %v", index, pid, err)
+		}
+		return value, nil
+	}
+
+	// field numbers as documented in proc(5)
+	values := map[int]uint64{}
+	for _, index := range []int{14, 15, 20, 22, 23, 24} {
+		if values[index], err = parse(index); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ProcessStats{
+		Pid:            pid,
+		CPUSeconds:     float64(values[14]+values[15]) / clockTicksPerSecond,
+		Threads:        int64(values[20]),
+		StartTimeTicks: values[22],
+		VirtualBytes:   values[23],
+		ResidentBytes:  values[24] * uint64(unix.Getpagesize()),
+		Time:           time.Now(),
+	}, nil
+}
+
+// CPUUsage returns the cpu usage in percent of one core between the previous and this sample
+func (s *ProcessStats) CPUUsage(prev *ProcessStats) float64 {
+	if prev == nil || prev.Pid != s.Pid || prev.StartTimeTicks != s.StartTimeTicks || s.CPUSeconds < prev.CPUSeconds {
+		return 0
+	}
+	elapsed := s.Time.Sub(prev.Time).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return (s.CPUSeconds - prev.CPUSeconds) / elapsed * 100
+}
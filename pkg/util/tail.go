@@ -0,0 +1,113 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// TailFile returns up to the last n lines of the file, reading at most maxBytes from its end.
+// A rotated file that is shorter than expected is read from its start.
+func TailFile(path string, n int, maxBytes int64) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := info.Size() - maxBytes
+	if offset < 0 {
+		offset = 0
+	}
+	// the size is only a hint, the file may be truncated or appended to while reading
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, maxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return []string{}, nil
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	// the first line is likely cut when reading from the middle of the file
+	if offset > 0 && len(lines) > 1 {
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		result[i] = string(line)
+	}
+	return result, nil
+}
+
+// FollowFile copies the lines appended to the file to w until the context is done, checking for
+// new lines every interval. It starts at the current end of the file and starts over at the
+// beginning if the file is truncated. A missing file is waited for.
+func FollowFile(ctx context.Context, path string, w io.Writer, interval time.Duration) error {
+	var offset int64 = -1
+	var pending []byte
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if info, err := os.Stat(path); err == nil {
+			switch {
+			case offset < 0:
+				offset = info.Size()
+			case info.Size() < offset:
+				offset, pending = 0, nil
+			}
+
+			data, err := readFileFrom(path, offset)
+			if err != nil {
+				return err
+			}
+			offset += int64(len(data))
+
+			// a line still being written is held back until it is complete
+			pending = append(pending, data...)
+			if i := bytes.LastIndexByte(pending, '\n'); i >= 0 {
+				if _, err := w.Write(pending[:i+1]); err != nil {
+					return err
+				}
+				pending = append([]byte{}, pending[i+1:]...)
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		} else if offset < 0 {
+			// a file created later is read from its start
+			offset = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func readFileFrom(path string, offset int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(f)
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily time range in a given location, it may wrap around midnight
+type TimeWindow struct {
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// ParseTimeWindow parses a window in the form HH:MM-HH:MM for the given timezone
+func ParseTimeWindow(window, timezone string) (*TimeWindow, error) {
+	bounds := strings.Split(window, "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid time window %v, expected HH:MM-HH:MM", window)
+	}
+
+	start, err := parseTimeOfDay(bounds[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeOfDay(bounds[1])
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("invalid time window %v, start and end are equal", window)
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %v: %v", timezone, err)
+	}
+
+	return &TimeWindow{
+		Start:    start,
+		End:      end,
+		Location: location,
+	}, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %v, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains returns true if the time of day of t in the window location is within the window
+func (w *TimeWindow) Contains(t time.Time) bool {
+	t = t.In(w.Location)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+func (w *TimeWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d %v",
+		int(w.Start.Hours()), int(w.Start.Minutes())%60, int(w.End.Hours()), int(w.End.Minutes())%60, w.Location)
+}
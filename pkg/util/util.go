@@ -1,12 +1,17 @@
 package util
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/mitchellh/go-ps"
+	"golang.org/x/sys/unix"
 )
 
+// ErrProcessNotFound is returned when no process has the requested name
+var ErrProcessNotFound = errors.New("process is not found")
+
 // FindProcessByName finds a process by name and returns the process
 func FindProcessByName(name string) (*os.Process, error) {
 	processes, err := ps.Processes()
@@ -20,5 +25,32 @@ func FindProcessByName(name string) (*os.Process, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("process %s is not found", name)
+	return nil, fmt.Errorf("%w: %s", ErrProcessNotFound, name)
+}
+
+// GetProcessExecutable returns the executable name of the process, empty if there is no such process
+func GetProcessExecutable(pid int) (string, error) {
+	process, err := ps.FindProcess(pid)
+	if err != nil {
+		return "", fmt.Errorf("failed to find process %d: %v", pid, err)
+	}
+	if process == nil {
+		return "", nil
+	}
+	return process.Executable(), nil
+}
+
+// CheckDirWritable returns an error if the path is not an existing directory writable by the process
+func CheckDirWritable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("directory %s is not accessible: %v", path, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	if err := unix.Access(path, unix.W_OK); err != nil {
+		return fmt.Errorf("directory %s is not writable: %v", path, err)
+	}
+	return nil
 }
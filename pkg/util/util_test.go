@@ -0,0 +1,44 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckDirWritable(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	readOnlyDir := filepath.Join(dir, "read-only")
+	if err := os.Mkdir(readOnlyDir, 0500); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		invalid bool
+		// asUser is set for cases root passes, since it may write to any directory
+		asUser bool
+	}{
+		{name: "writable directory", path: dir},
+		{name: "missing directory", path: filepath.Join(dir, "missing"), invalid: true},
+		{name: "file", path: file, invalid: true},
+		{name: "read only directory", path: readOnlyDir, invalid: true, asUser: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.asUser && os.Geteuid() == 0 {
+				t.Skip("root may write to any directory")
+			}
+			err := CheckDirWritable(tt.path)
+			if invalid := err != nil; invalid != tt.invalid {
+				t.Fatalf("expected invalid %v, got %v", tt.invalid, err)
+			}
+		})
+	}
+}
@@ -1,16 +1,83 @@
 package volume
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	lhtypes "github.com/longhorn/go-common-libs/types"
+	"golang.org/x/sys/unix"
 	"k8s.io/kubernetes/pkg/volume/util/hostutil"
 	"k8s.io/mount-utils"
 	utilexec "k8s.io/utils/exec"
+
+	"github.com/longhorn/longhorn-share-manager/pkg/crypto"
+	"github.com/longhorn/longhorn-share-manager/pkg/types"
+	"github.com/longhorn/longhorn-share-manager/pkg/util"
 )
 
+// DataEngine is the longhorn data engine backing the volume device
+type DataEngine string
+
+const (
+	// DataEngineV1 volumes are exposed as iSCSI block devices
+	DataEngineV1 = DataEngine("v1")
+	// DataEngineV2 volumes are exposed as NVMe over TCP block devices
+	DataEngineV2 = DataEngine("v2")
+)
+
+// ExpectedDeviceNamePrefix returns the kernel name prefix of the block device exposed by the data engine
+func (e DataEngine) ExpectedDeviceNamePrefix() string {
+	if e == DataEngineV2 {
+		return "nvme"
+	}
+	return "sd"
+}
+
+const maxReservedBlocksPercentage = 50
+
+// ReservedSpaceFile is the file holding the space reserved on first mount
+const ReservedSpaceFile = ".longhorn-reserved-space"
+
+// FingerprintFile is the file holding the UID of the volume the filesystem was created for
+const FingerprintFile = ".longhorn-volume-uid"
+
+// selinuxContextRegex matches an SELinux context in the form user:role:type with an optional
+// MLS/MCS level, e.g. system_u:object_r:container_file_t:s0:c1,c2
+var selinuxContextRegex = regexp.MustCompile(`^[A-Za-z0-9_.]+:[A-Za-z0-9_.]+:[A-Za-z0-9_.]+(:s[0-9]+(-s[0-9]+)?(:c[0-9]+([.,]c[0-9]+)*)?)?$`)
+
+// ErrFingerprintMismatch is returned when the mounted filesystem belongs to another volume
+var ErrFingerprintMismatch = errors.New("filesystem belongs to another volume")
+
+var (
+	// ErrDeviceNotReady is returned when a mount fails since the device is not usable yet, e.g.
+	// while udev settles after an attach, so mounting it again may succeed
+	ErrDeviceNotReady = errors.New("device is not ready")
+	// ErrFilesystemCorrupt is returned when a mount fails since the filesystem has errors fsck
+	// cannot correct, so mounting it again cannot succeed
+	ErrFilesystemCorrupt = errors.New("filesystem is corrupt")
+)
+
+// deviceNotReadyMessages are the mount and blkid outputs of a device that is not usable yet.
+// An I/O error or an unreadable superblock are not among them, since they are just as likely a
+// damaged filesystem or a failing disk, which retrying the mount cannot fix.
+var deviceNotReadyMessages = []string{
+	"No such device",
+	"does not exist",
+	"No medium found",
+}
+
 type Volume struct {
 	Name            string
+	UID             string
 	Passphrase      string
 	CryptoKeyCipher string
 	CryptoKeyHash   string
@@ -18,12 +85,360 @@ type Volume struct {
 	CryptoPBKDF     string
 	FsType          string
 	MountOptions    []string
+	EnableACL       bool
+	NFSSecTypes     []string
+	NFSExportID     uint16
+	Discard         bool
+	// MountContext is the SELinux context the filesystem is mounted with, empty uses the policy default
+	MountContext    string
+	ReservedSpace   int64
+	Compression     string
+	DataEngine      DataEngine
+	NFSSquash       string
+	NFSAnonymousUID *uint32
+	NFSAnonymousGID *uint32
+	NFSAccessRules  []types.AccessRule
+
+	// NFSMaxRead and NFSMaxWrite are the largest read and write sizes in bytes per operation, zero keeps the default
+	NFSMaxRead  uint64
+	NFSMaxWrite uint64
+
+	// NFSFSALName is the ganesha FSAL backing the export, empty uses VFS
+	NFSFSALName string
+	// NFSFSALOptions are additional parameters of the FSAL block of the export
+	NFSFSALOptions []types.FSALOption
+	// NFSDelegations are the NFSv4 delegations granted to the clients of the export, empty keeps the default
+	NFSDelegations string
+
+	// CryptoHeaderPath is a file on the host holding the detached LUKS header, empty keeps the header on the device
+	CryptoHeaderPath string
+	// CryptoNameWithUID appends the volume UID to the crypto mapping name, so the device mapper
+	// names of volumes sharing a name do not collide
+	CryptoNameWithUID bool
+	// PassphrasePolicy is checked before the device of a new encrypted volume is formatted
+	PassphrasePolicy crypto.PassphrasePolicy
+	// CryptoTimeout bounds the cryptsetup calls formatting, opening, closing and resizing the
+	// crypto device, zero uses the default
+	CryptoTimeout time.Duration
+
+	// VerifyFingerprint checks the volume UID stored on the filesystem on every mount and refuses
+	// to export a filesystem of another volume. It requires the volume UID.
+	VerifyFingerprint bool
+
+	// ReservedBlocksPercentage is the share of ext filesystem blocks reserved for root, set when formatting
+	ReservedBlocksPercentage int
+
+	// MountAttempts is how often mounting is attempted while the device is not ready, zero attempts once
+	MountAttempts int
+
+	// CommitInterval is how often the ext3/ext4 journal is committed, a longer interval batches
+	// journal writes at the cost of a larger window of lost writes on a crash. Zero keeps the default.
+	CommitInterval time.Duration
+	// XFSLogBuffers is the number of in-memory xfs log buffers, zero keeps the default
+	XFSLogBuffers int
+	// XFSLogBufferSize is the size in bytes of each in-memory xfs log buffer, zero keeps the default
+	XFSLogBufferSize int64
+
+	// FormatBlockSize, FormatInodeSize and FormatBytesPerInode are passed to mkfs when the volume is
+	// formatted on first mount, zero keeps the mkfs default. The bytes per inode only apply to ext.
+	FormatBlockSize     int
+	FormatInodeSize     int
+	FormatBytesPerInode int
 }
 
 func (v Volume) IsEncrypted() bool {
 	return len(v.Passphrase) > 0
 }
 
+// CryptoMappingName returns the device mapper name of the crypto device of the volume
+func (v Volume) CryptoMappingName() string {
+	if v.CryptoNameWithUID && v.UID != "" {
+		return v.Name + "-" + v.UID
+	}
+	return v.Name
+}
+
+// DevicePath returns the path of the longhorn device of the volume, or of its crypto device
+func (v Volume) DevicePath(encryptedDevice bool) string {
+	if encryptedDevice {
+		return types.GetVolumeDevicePath(v.CryptoMappingName(), true)
+	}
+	return types.GetVolumeDevicePath(v.Name, false)
+}
+
+// Validate checks that the volume settings can be combined
+func (v Volume) Validate() error {
+	if v.EnableACL {
+		if !SupportsACL(v.FsType) {
+			return fmt.Errorf("NFSv4 ACL is not supported for filesystem %v", v.FsType)
+		}
+		if slices.Contains(v.MountOptions, "noacl") {
+			return fmt.Errorf("NFSv4 ACL cannot be enabled with mount option noacl")
+		}
+	}
+
+	if err := v.PassphrasePolicy.Validate(); err != nil {
+		return err
+	}
+
+	if v.CryptoHeaderPath != "" {
+		if !v.IsEncrypted() {
+			return fmt.Errorf("detached LUKS header %v requires an encrypted volume", v.CryptoHeaderPath)
+		}
+		if !filepath.IsAbs(v.CryptoHeaderPath) {
+			return fmt.Errorf("detached LUKS header %v must be an absolute path", v.CryptoHeaderPath)
+		}
+		if err := crypto.CheckHeaderFile(v.CryptoHeaderPath); err != nil {
+			return err
+		}
+	}
+
+	if v.CryptoNameWithUID && v.UID == "" {
+		return fmt.Errorf("crypto mapping name with UID requires the volume UID")
+	}
+
+	if v.MountContext != "" && !selinuxContextRegex.MatchString(v.MountContext) {
+		return fmt.Errorf("invalid SELinux mount context %q, must be in the form user:role:type[:level]", v.MountContext)
+	}
+
+	if v.VerifyFingerprint && v.UID == "" {
+		return fmt.Errorf("fingerprint verification requires the volume UID")
+	}
+
+	if v.Discard && !SupportsOnlineDiscard(v.FsType) {
+		return fmt.Errorf("online discard is not supported for filesystem %v", v.FsType)
+	}
+
+	if v.Compression != "" {
+		if v.FsType != "btrfs" {
+			return fmt.Errorf("compression is not supported for filesystem %v", v.FsType)
+		}
+		if err := ValidateCompression(v.Compression); err != nil {
+			return err
+		}
+	}
+
+	if v.DataEngine != "" && v.DataEngine != DataEngineV1 && v.DataEngine != DataEngineV2 {
+		return fmt.Errorf("invalid data engine %v", v.DataEngine)
+	}
+
+	if v.ReservedBlocksPercentage != 0 {
+		if !strings.HasPrefix(v.FsType, "ext") {
+			return fmt.Errorf("reserved blocks percentage is not supported for filesystem %v", v.FsType)
+		}
+		if v.ReservedBlocksPercentage < 0 || v.ReservedBlocksPercentage > maxReservedBlocksPercentage {
+			return fmt.Errorf("reserved blocks percentage %v must be between 0 and %v", v.ReservedBlocksPercentage, maxReservedBlocksPercentage)
+		}
+	}
+
+	if v.MountAttempts < 0 {
+		return fmt.Errorf("invalid mount attempts %v", v.MountAttempts)
+	}
+
+	if v.ReservedSpace < 0 {
+		return fmt.Errorf("invalid reserved space %v", v.ReservedSpace)
+	}
+
+	if _, err := JournalMountOptions(v.FsType, v, nil); err != nil {
+		return err
+	}
+
+	if _, err := FormatOptions(v.FsType, v); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SupportsOnlineDiscard returns true if the filesystem can be mounted with the discard option
+func SupportsOnlineDiscard(fsType string) bool {
+	switch fsType {
+	case "ext4", "xfs", "btrfs":
+		return true
+	}
+	return false
+}
+
+// compressionLevels are the maximum levels of the btrfs compression algorithms, zero if the level is not configurable
+var compressionLevels = map[string]int{
+	"zlib": 9,
+	"lzo":  0,
+	"zstd": 15,
+}
+
+// ValidateCompression checks a btrfs compression setting in the form algorithm[:level]
+func ValidateCompression(compression string) error {
+	algorithm, level, hasLevel := strings.Cut(compression, ":")
+	maxLevel, ok := compressionLevels[algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported compression algorithm %v", algorithm)
+	}
+	if !hasLevel {
+		return nil
+	}
+	if maxLevel == 0 {
+		return fmt.Errorf("compression algorithm %v does not support a level", algorithm)
+	}
+	if l, err := strconv.Atoi(level); err != nil || l < 1 || l > maxLevel {
+		return fmt.Errorf("invalid compression level %v, must be between 1 and %v for %v", level, maxLevel, algorithm)
+	}
+	return nil
+}
+
+// CompressionMountOptions returns the mount options with the btrfs compression enabled
+func CompressionMountOptions(compression string, mountOptions []string) []string {
+	return append(slices.Clone(mountOptions), "compress="+compression)
+}
+
+// ContextMountOptions returns the mount options with the SELinux context option added. The context
+// is quoted, since the commas of a category set would otherwise split it into several options.
+// Validate only lets contexts through that have no quotes or escapes to break the quoting.
+func ContextMountOptions(mountContext string, mountOptions []string) []string {
+	return append(slices.Clone(mountOptions), `context="`+mountContext+`"`)
+}
+
+const (
+	maxCommitInterval   = 300 * time.Second
+	minXFSLogBuffers    = 2
+	maxXFSLogBuffers    = 8
+	minXFSLogBufferSize = 16 * 1024
+	maxXFSLogBufferSize = 256 * 1024
+)
+
+// JournalMountOptions returns the mount options with the journal tuning of the volume added.
+// The commit interval maps to the ext3/ext4 commit option and the log buffers to the xfs
+// logbufs and logbsize options, so it fails if a setting does not apply to the filesystem.
+func JournalMountOptions(fsType string, v Volume, mountOptions []string) ([]string, error) {
+	if v.CommitInterval == 0 && v.XFSLogBuffers == 0 && v.XFSLogBufferSize == 0 {
+		return mountOptions, nil
+	}
+
+	options := slices.Clone(mountOptions)
+
+	if v.CommitInterval != 0 {
+		if fsType != "ext3" && fsType != "ext4" {
+			return nil, fmt.Errorf("journal commit interval is not supported for filesystem %v", fsType)
+		}
+		if v.CommitInterval < time.Second || v.CommitInterval > maxCommitInterval || v.CommitInterval%time.Second != 0 {
+			return nil, fmt.Errorf("journal commit interval %v must be whole seconds between 1s and %v", v.CommitInterval, maxCommitInterval)
+		}
+		options = append(options, fmt.Sprintf("commit=%d", int(v.CommitInterval.Seconds())))
+	}
+
+	if v.XFSLogBuffers != 0 {
+		if fsType != "xfs" {
+			return nil, fmt.Errorf("log buffers are not supported for filesystem %v", fsType)
+		}
+		if v.XFSLogBuffers < minXFSLogBuffers || v.XFSLogBuffers > maxXFSLogBuffers {
+			return nil, fmt.Errorf("log buffers %v must be between %v and %v", v.XFSLogBuffers, minXFSLogBuffers, maxXFSLogBuffers)
+		}
+		options = append(options, fmt.Sprintf("logbufs=%d", v.XFSLogBuffers))
+	}
+
+	if v.XFSLogBufferSize != 0 {
+		if fsType != "xfs" {
+			return nil, fmt.Errorf("log buffer size is not supported for filesystem %v", fsType)
+		}
+		size := v.XFSLogBufferSize
+		if size < minXFSLogBufferSize || size > maxXFSLogBufferSize || size&(size-1) != 0 {
+			return nil, fmt.Errorf("log buffer size %v must be a power of two between %v and %v bytes", size, minXFSLogBufferSize, maxXFSLogBufferSize)
+		}
+		options = append(options, fmt.Sprintf("logbsize=%dk", size/1024))
+	}
+
+	return options, nil
+}
+
+const (
+	// the block size cannot exceed the page size, otherwise the filesystem cannot be mounted
+	minFormatBlockSize     = 1024
+	maxFormatBlockSize     = 4096
+	minExtInodeSize        = 128
+	minXFSInodeSize        = 512
+	maxXFSInodeSize        = 2048
+	minFormatBytesPerInode = 1024
+	maxFormatBytesPerInode = 64 * 1024 * 1024
+)
+
+// FormatOptions returns the mkfs options for the block size, inode size and bytes per inode of
+// the volume. It fails if a size is out of range or does not apply to the filesystem.
+func FormatOptions(fsType string, v Volume) ([]string, error) {
+	if v.FormatBlockSize == 0 && v.FormatInodeSize == 0 && v.FormatBytesPerInode == 0 {
+		return nil, nil
+	}
+
+	isExt := strings.HasPrefix(fsType, "ext")
+	if !isExt && fsType != "xfs" {
+		return nil, fmt.Errorf("format block and inode sizes are not supported for filesystem %v", fsType)
+	}
+
+	options := []string{}
+
+	if v.FormatBlockSize != 0 {
+		if !isPowerOfTwo(v.FormatBlockSize) || v.FormatBlockSize < minFormatBlockSize || v.FormatBlockSize > maxFormatBlockSize {
+			return nil, fmt.Errorf("format block size %v must be a power of two between %v and %v", v.FormatBlockSize, minFormatBlockSize, maxFormatBlockSize)
+		}
+		if isExt {
+			options = append(options, "-b", strconv.Itoa(v.FormatBlockSize))
+		} else {
+			options = append(options, "-b", "size="+strconv.Itoa(v.FormatBlockSize))
+		}
+	}
+
+	if v.FormatInodeSize != 0 {
+		minSize, maxSize := minExtInodeSize, maxFormatBlockSize
+		if !isExt {
+			minSize, maxSize = minXFSInodeSize, maxXFSInodeSize
+		}
+		if v.FormatBlockSize != 0 && isExt {
+			maxSize = v.FormatBlockSize
+		}
+		if !isPowerOfTwo(v.FormatInodeSize) || v.FormatInodeSize < minSize || v.FormatInodeSize > maxSize {
+			return nil, fmt.Errorf("format inode size %v must be a power of two between %v and %v for filesystem %v", v.FormatInodeSize, minSize, maxSize, fsType)
+		}
+		if isExt {
+			options = append(options, "-I", strconv.Itoa(v.FormatInodeSize))
+		} else {
+			options = append(options, "-i", "size="+strconv.Itoa(v.FormatInodeSize))
+		}
+	}
+
+	if v.FormatBytesPerInode != 0 {
+		if !isExt {
+			return nil, fmt.Errorf("format bytes per inode is not supported for filesystem %v", fsType)
+		}
+		minRatio := max(minFormatBytesPerInode, v.FormatBlockSize)
+		if v.FormatBytesPerInode < minRatio || v.FormatBytesPerInode > maxFormatBytesPerInode {
+			return nil, fmt.Errorf("format bytes per inode %v must be between %v and %v", v.FormatBytesPerInode, minRatio, maxFormatBytesPerInode)
+		}
+		options = append(options, "-i", strconv.Itoa(v.FormatBytesPerInode))
+	}
+
+	return options, nil
+}
+
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// SupportsACL returns true if the filesystem can store POSIX/NFSv4 ACLs
+func SupportsACL(fsType string) bool {
+	switch fsType {
+	case "ext2", "ext3", "ext4", "xfs", "btrfs":
+		return true
+	}
+	return false
+}
+
+// ACLMountOptions returns the mount options with ACL support enabled for the filesystem.
+// xfs and btrfs always have ACL support, so only the ext family needs the acl option.
+func ACLMountOptions(fsType string, mountOptions []string) []string {
+	if !strings.HasPrefix(fsType, "ext") || slices.Contains(mountOptions, "acl") {
+		return mountOptions
+	}
+	return append(slices.Clone(mountOptions), "acl")
+}
+
 func GetDiskFormat(devicePath string) (string, error) {
 	mounter := &mount.SafeFormatAndMount{Interface: mount.New(""), Exec: utilexec.New()}
 	return mounter.GetDiskFormat(devicePath)
@@ -39,16 +454,37 @@ func CheckMountValid(mountPath string) bool {
 	return err == nil && isMountPoint
 }
 
-func MountVolume(devicePath, mountPath, fsType string, mountOptions []string) error {
+// GetMountPoint returns the /proc/mounts entry of the mount path, nil if it is not mounted
+func GetMountPoint(mountPath string) (*mount.MountPoint, error) {
+	mountPoints, err := mount.New("").List()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range mountPoints {
+		if mountPoints[i].Path == mountPath {
+			return &mountPoints[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// MountVolume formats the device with the format options if needed and mounts it at the mount path.
+// The format and mount commands are killed once the context is done, and the
+// context error is returned without waiting for a command stuck on the device.
+func MountVolume(ctx context.Context, devicePath, mountPath, fsType string, mountOptions, formatOptions []string) error {
 	if !CheckDeviceValid(devicePath) {
-		return fmt.Errorf("cannot mount device %v to %v invalid device", devicePath, mountPath)
+		return fmt.Errorf("cannot mount device %v to %v invalid device: %w", devicePath, mountPath, ErrDeviceNotReady)
 	}
 
 	if CheckMountValid(mountPath) {
 		return nil
 	}
 
-	mounter := &mount.SafeFormatAndMount{Interface: mount.New(""), Exec: utilexec.New()}
+	mounter := &mount.SafeFormatAndMount{
+		Interface: &contextMounter{Interface: mount.New(""), ctx: ctx},
+		Exec:      &contextExec{Interface: utilexec.New(), ctx: ctx},
+	}
 
 	if exists, err := hostutil.NewHostUtil().PathExists(mountPath); !exists || err != nil {
 		if err != nil {
@@ -60,24 +496,236 @@ func MountVolume(devicePath, mountPath, fsType string, mountOptions []string) er
 		}
 	}
 
-	return mounter.FormatAndMount(devicePath, mountPath, fsType, mountOptions)
+	done := make(chan error, 1)
+	go func() {
+		done <- mounter.FormatAndMountSensitiveWithFormatOptions(devicePath, mountPath, fsType, mountOptions, nil, formatOptions)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+}
+
+// ClassifyMountError wraps a mount error with ErrDeviceNotReady if mounting again may succeed, or
+// with ErrFilesystemCorrupt if fsck found errors it could not correct
+func ClassifyMountError(err error) error {
+	if err == nil || errors.Is(err, ErrDeviceNotReady) || errors.Is(err, ErrFilesystemCorrupt) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	var mountErr mount.MountError
+	if errors.As(err, &mountErr) {
+		switch mountErr.Type {
+		case mount.HasFilesystemErrors:
+			return fmt.Errorf("%w: %v", ErrFilesystemCorrupt, err)
+		case mount.GetDiskFormatFailed:
+			return fmt.Errorf("%w: %v", ErrDeviceNotReady, err)
+		}
+	}
+
+	message := err.Error()
+	if strings.Contains(message, "Structure needs cleaning") {
+		return fmt.Errorf("%w: %v", ErrFilesystemCorrupt, err)
+	}
+	for _, notReady := range deviceNotReadyMessages {
+		if strings.Contains(message, notReady) {
+			return fmt.Errorf("%w: %v", ErrDeviceNotReady, err)
+		}
+	}
+	return err
+}
+
+// contextExec binds the commands run by the mounter to a context
+type contextExec struct {
+	utilexec.Interface
+	ctx context.Context
+}
+
+func (e *contextExec) Command(cmd string, args ...string) utilexec.Cmd {
+	return e.Interface.CommandContext(e.ctx, cmd, args...)
+}
+
+// contextMounter binds the mount command to a context
+type contextMounter struct {
+	mount.Interface
+	ctx context.Context
 }
 
-func ResizeVolume(devicePath, mountPath string) (bool, error) {
+func (m *contextMounter) Mount(source string, target string, fstype string, options []string) error {
+	return m.MountSensitive(source, target, fstype, options, nil)
+}
+
+func (m *contextMounter) MountSensitive(source string, target string, fstype string, options []string, sensitiveOptions []string) error {
+	args, argsLog := mount.MakeMountArgsSensitive(source, target, fstype, options, sensitiveOptions)
+	if out, err := exec.CommandContext(m.ctx, "mount", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("mount failed: %v, arguments: %s, output: %s", err, argsLog, out)
+	}
+	return nil
+}
+
+// ResizeVolume grows the filesystem mounted at the mount path to the device size if needed.
+// When verbose is set, the commands run by the resize and their output are returned for diagnostics.
+func ResizeVolume(ctx context.Context, devicePath, mountPath string, verbose bool) (bool, string, error) {
 	// check if we need to resize the fs
 	// this is important since cloned volumes of bigger size don't trigger NodeExpandVolume
 	// therefore NodeExpandVolume is kind of redundant since we have to do this anyway
 	// some refs below for more details
 	// https://github.com/kubernetes/kubernetes/issues/94929
 	// https://github.com/kubernetes-sigs/aws-ebs-csi-driver/pull/753
-	resizer := mount.NewResizeFs(utilexec.New())
-	if needsResize, err := resizer.NeedResize(devicePath, mountPath); err != nil {
+	var executor utilexec.Interface = &contextExec{Interface: utilexec.New(), ctx: ctx}
+	resizer := mount.NewResizeFs(executor)
+	needsResize, err := resizer.NeedResize(devicePath, mountPath)
+	if err != nil || !needsResize {
+		return false, "", err
+	}
+
+	if !verbose {
+		resized, err := resizer.Resize(devicePath, mountPath)
+		return resized, "", err
+	}
+
+	recorder := &recordingExec{Interface: executor}
+	resized, err := mount.NewResizeFs(recorder).Resize(devicePath, mountPath)
+	return resized, recorder.output.String(), err
+}
+
+// recordingExec records the commands run through it and their combined output
+type recordingExec struct {
+	utilexec.Interface
+	output strings.Builder
+}
+
+func (e *recordingExec) Command(cmd string, args ...string) utilexec.Cmd {
+	return &recordingCmd{Cmd: e.Interface.Command(cmd, args...), exec: e, command: strings.Join(append([]string{cmd}, args...), " ")}
+}
+
+type recordingCmd struct {
+	utilexec.Cmd
+	exec    *recordingExec
+	command string
+}
+
+func (c *recordingCmd) CombinedOutput() ([]byte, error) {
+	out, err := c.Cmd.CombinedOutput()
+	fmt.Fprintf(&c.exec.output, "$ %v\n%s", c.command, out)
+	if err != nil {
+		fmt.Fprintf(&c.exec.output, "error: %v\n", err)
+	}
+	return out, err
+}
+
+// ReserveSpace preallocates a file of the given size at the root of the filesystem,
+// so the space is backed by the device before clients start writing.
+func ReserveSpace(devicePath, mountPath string, size int64) error {
+	deviceSize, err := util.GetDeviceSize(devicePath)
+	if err != nil {
+		return err
+	}
+	if size >= deviceSize {
+		return fmt.Errorf("reserved space %v exceeds the size %v of device %v", size, deviceSize, devicePath)
+	}
+
+	f, err := os.OpenFile(filepath.Join(mountPath, ReservedSpaceFile), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return unix.Fallocate(int(f.Fd()), 0, 0, size)
+}
+
+// CheckFingerprint compares the volume UID stored at the root of the mounted filesystem with the
+// given one. It returns false if no fingerprint is stored and ErrFingerprintMismatch if it differs.
+func CheckFingerprint(mountPath, uid string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(mountPath, FingerprintFile))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
 		return false, err
-	} else if needsResize {
-		return resizer.Resize(devicePath, mountPath)
 	}
 
-	return false, nil
+	if stored := strings.TrimSpace(string(data)); stored != uid {
+		return true, fmt.Errorf("%w: filesystem at %v was created for volume UID %v, expected %v", ErrFingerprintMismatch, mountPath, stored, uid)
+	}
+	return true, nil
+}
+
+// WriteFingerprint stores the volume UID at the root of the mounted filesystem
+func WriteFingerprint(mountPath, uid string) error {
+	path := filepath.Join(mountPath, FingerprintFile)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(uid+"\n"), 0444); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// SetReservedBlocksPercentage sets the share of blocks reserved for root on an ext filesystem.
+// The mounter formats ext filesystems with -m0 after any extra format options, so the
+// percentage is applied with tune2fs once the filesystem exists.
+func SetReservedBlocksPercentage(ctx context.Context, devicePath string, percentage int) error {
+	out, err := exec.CommandContext(ctx, "tune2fs", "-m", strconv.Itoa(percentage), devicePath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("tune2fs failed: %v, output: %s", err, out)
+	}
+	return nil
+}
+
+// GetExtFilesystemUsage returns the used and total bytes of the unmounted ext filesystem on the device
+func GetExtFilesystemUsage(ctx context.Context, devicePath string) (int64, int64, error) {
+	out, err := exec.CommandContext(ctx, "dumpe2fs", "-h", devicePath).CombinedOutput()
+	if err != nil {
+		return 0, 0, fmt.Errorf("dumpe2fs failed: %v, output: %s", err, out)
+	}
+
+	fields := map[string]int64{}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		switch key = strings.TrimSpace(key); key {
+		case "Block count", "Free blocks", "Block size":
+			n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid %v %v in dumpe2fs output", key, value)
+			}
+			fields[key] = n
+		}
+	}
+
+	blockSize := fields["Block size"]
+	if blockSize == 0 || fields["Block count"] == 0 {
+		return 0, 0, fmt.Errorf("failed to find block count and size in dumpe2fs output of %v", devicePath)
+	}
+	return (fields["Block count"] - fields["Free blocks"]) * blockSize, fields["Block count"] * blockSize, nil
+}
+
+// ShrinkExtFilesystem checks the unmounted ext filesystem on the device and shrinks it to the target size
+func ShrinkExtFilesystem(ctx context.Context, devicePath string, targetBytes int64) (string, error) {
+	// e2fsck exits with 1 when it corrected errors, which is fine for a resize
+	fsckOut, err := exec.CommandContext(ctx, "e2fsck", "-f", "-y", devicePath).CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			return string(fsckOut), fmt.Errorf("e2fsck failed: %v, output: %s", err, fsckOut)
+		}
+	}
+
+	resizeOut, err := exec.CommandContext(ctx, "resize2fs", devicePath, strconv.FormatInt(targetBytes/1024, 10)+"K").CombinedOutput()
+	output := string(fsckOut) + string(resizeOut)
+	if err != nil {
+		return output, fmt.Errorf("resize2fs failed: %v, output: %s", err, resizeOut)
+	}
+	return output, nil
 }
 
 func SetPermissions(mountPath string, mode os.FileMode) error {
@@ -88,11 +736,194 @@ func SetPermissions(mountPath string, mode os.FileMode) error {
 	return os.Chmod(mountPath, mode)
 }
 
+// BindMountReadOnly bind mounts the source path read only at the target path
+func BindMountReadOnly(sourcePath, targetPath string) error {
+	if CheckMountValid(targetPath) {
+		return nil
+	}
+
+	if err := makeDir(targetPath); err != nil {
+		return err
+	}
+
+	return mount.New("").Mount(sourcePath, targetPath, "", []string{"bind", "ro"})
+}
+
+// MountReadOnly mounts the existing filesystem on the device read only at the mount path without
+// replaying its journal, e.g. for a snapshot of a volume. Unlike MountVolume it never formats the device.
+func MountReadOnly(devicePath, mountPath string) error {
+	if CheckMountValid(mountPath) {
+		return nil
+	}
+
+	fsType, err := GetDiskFormat(devicePath)
+	if err != nil {
+		return err
+	}
+
+	options := []string{"ro"}
+	switch {
+	case fsType == "":
+		return fmt.Errorf("device %v has no filesystem", devicePath)
+	case strings.HasPrefix(fsType, "ext"):
+		options = append(options, "noload")
+	case fsType == "xfs":
+		// the snapshot has the UUID of the filesystem of the live volume
+		options = append(options, "norecovery", "nouuid")
+	}
+
+	if err := makeDir(mountPath); err != nil {
+		return err
+	}
+	return mount.New("").Mount(devicePath, mountPath, fsType, options)
+}
+
+// TrimFilesystem runs fstrim on the filesystem mounted at the mount path and returns its verbose output.
+// A non zero offset or length restricts the trim to that byte range of the filesystem.
+// fstrim is killed once the context is done.
+func TrimFilesystem(ctx context.Context, mountPath string, offset, length uint64) (string, error) {
+	args := []string{"-v"}
+	if offset > 0 {
+		args = append(args, "-o", strconv.FormatUint(offset, 10))
+	}
+	if length > 0 {
+		args = append(args, "-l", strconv.FormatUint(length, 10))
+	}
+	args = append(args, mountPath)
+
+	out, err := exec.CommandContext(ctx, lhtypes.BinaryFstrim, args...).CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("fstrim failed: %v, output: %s", err, out)
+	}
+	return string(out), nil
+}
+
+// DiscardDevice discards all blocks of the device, or writes zeroes over them if zero is set.
+// A discard does not guarantee the data is erased, the device may keep and return the old blocks.
+// The device must not be in use.
+func DiscardDevice(ctx context.Context, devicePath string, zero bool) error {
+	args := []string{}
+	if zero {
+		args = append(args, "--zeroout")
+	}
+	args = append(args, devicePath)
+
+	out, err := exec.CommandContext(ctx, "blkdiscard", args...).CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("blkdiscard failed: %v, output: %s", err, out)
+	}
+	return nil
+}
+
+// GetFilesystemSize returns the size in bytes of the filesystem mounted at the mount path
+func GetFilesystemSize(mountPath string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(mountPath, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Blocks * uint64(stat.Bsize), nil
+}
+
+// FilesystemStats is the space and inode usage of a mounted filesystem
+type FilesystemStats struct {
+	TotalBytes     uint64 `json:"totalBytes"`
+	UsedBytes      uint64 `json:"usedBytes"`
+	AvailableBytes uint64 `json:"availableBytes"`
+	TotalInodes    uint64 `json:"totalInodes"`
+	UsedInodes     uint64 `json:"usedInodes"`
+	FreeInodes     uint64 `json:"freeInodes"`
+}
+
+// InodeUsagePercentage returns the share of used inodes, zero for a filesystem without an inode limit
+func (s FilesystemStats) InodeUsagePercentage() float64 {
+	if s.TotalInodes == 0 {
+		return 0
+	}
+	return float64(s.UsedInodes) * 100 / float64(s.TotalInodes)
+}
+
+// GetFilesystemStats returns the space and inode usage of the filesystem mounted at the mount path
+func GetFilesystemStats(mountPath string) (*FilesystemStats, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(mountPath, &stat); err != nil {
+		return nil, err
+	}
+
+	return &FilesystemStats{
+		TotalBytes:     stat.Blocks * uint64(stat.Bsize),
+		UsedBytes:      (stat.Blocks - stat.Bfree) * uint64(stat.Bsize),
+		AvailableBytes: stat.Bavail * uint64(stat.Bsize),
+		TotalInodes:    stat.Files,
+		UsedInodes:     stat.Files - stat.Ffree,
+		FreeInodes:     stat.Ffree,
+	}, nil
+}
+
+// SyncFilesystem flushes the dirty data of the filesystem mounted at the mount path.
+// It stops waiting once the context is done, while the sync keeps running in the background.
+func SyncFilesystem(ctx context.Context, mountPath string) error {
+	done := make(chan error, 1)
+	go func() {
+		f, err := os.Open(mountPath)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer f.Close()
+		done <- unix.Syncfs(int(f.Fd()))
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		return err
+	}
+}
+
+// MountNFSExport mounts the NFSv4 export at the target path with a soft mount, so I/O to a
+// broken export fails instead of hanging. The mount command is killed once the context is done.
+func MountNFSExport(ctx context.Context, source, targetPath string) error {
+	if err := makeDir(targetPath); err != nil {
+		return err
+	}
+
+	out, err := exec.CommandContext(ctx, "mount", "-t", "nfs4", "-o", "soft,timeo=50,retrans=1", source, targetPath).CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("mount failed: %v, source: %v, output: %s", err, source, out)
+	}
+	return nil
+}
+
 func UnmountVolume(mountPath string) error {
 	mounter := mount.New("")
 	return mounter.Unmount(mountPath)
 }
 
+// UnmountVolumeWithTimeout unmounts the mount path and gives up if umount does not return within the timeout
+func UnmountVolumeWithTimeout(mountPath string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "umount", mountPath).CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("unmount of %v did not finish within %v: %w", mountPath, timeout, ctx.Err())
+	}
+	if err != nil {
+		return fmt.Errorf("unmount failed: %v\nUnmounting arguments: %s\nOutput: %s", err, mountPath, output)
+	}
+	return nil
+}
+
 // makeDir creates a new directory.
 // If pathname already exists as a directory, no error is returned.
 // If pathname already exists as a file, an error is returned.
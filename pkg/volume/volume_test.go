@@ -0,0 +1,328 @@
+package volume
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	mount "k8s.io/mount-utils"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		volume  Volume
+		invalid bool
+	}{
+		{
+			name:   "defaults",
+			volume: Volume{Name: "pvc-1", FsType: "ext4"},
+		},
+		{
+			name:   "acl on ext4",
+			volume: Volume{Name: "pvc-1", FsType: "ext4", EnableACL: true},
+		},
+		{
+			name:   "acl on xfs",
+			volume: Volume{Name: "pvc-1", FsType: "xfs", EnableACL: true},
+		},
+		{
+			name:    "acl on unsupported filesystem",
+			volume:  Volume{Name: "pvc-1", FsType: "vfat", EnableACL: true},
+			invalid: true,
+		},
+		{
+			name:    "acl with noacl mount option",
+			volume:  Volume{Name: "pvc-1", FsType: "ext4", EnableACL: true, MountOptions: []string{"noacl"}},
+			invalid: true,
+		},
+		{
+			name:   "crypto mapping name with uid",
+			volume: Volume{Name: "pvc-1", UID: "4f1c", FsType: "ext4", CryptoNameWithUID: true},
+		},
+		{
+			name:    "crypto mapping name without uid",
+			volume:  Volume{Name: "pvc-1", FsType: "ext4", CryptoNameWithUID: true},
+			invalid: true,
+		},
+		{
+			name:   "selinux context",
+			volume: Volume{Name: "pvc-1", FsType: "ext4", MountContext: "system_u:object_r:container_file_t:s0"},
+		},
+		{
+			name:   "selinux context with categories",
+			volume: Volume{Name: "pvc-1", FsType: "ext4", MountContext: "system_u:object_r:container_file_t:s0:c1,c2"},
+		},
+		{
+			name:    "selinux context without type",
+			volume:  Volume{Name: "pvc-1", FsType: "ext4", MountContext: "system_u:object_r"},
+			invalid: true,
+		},
+		{
+			name:    "selinux context with mount option syntax",
+			volume:  Volume{Name: "pvc-1", FsType: "ext4", MountContext: "system_u:object_r:container_file_t:s0,nosuid"},
+			invalid: true,
+		},
+		{
+			name:    "selinux context with quotes",
+			volume:  Volume{Name: "pvc-1", FsType: "ext4", MountContext: `system_u:object_r:container_file_t:s0\",nosuid`},
+			invalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.volume.Validate()
+			if invalid := err != nil; invalid != tt.invalid {
+				t.Fatalf("expected invalid %v, got %v", tt.invalid, err)
+			}
+		})
+	}
+}
+
+func TestDevicePath(t *testing.T) {
+	tests := []struct {
+		name          string
+		volume        Volume
+		mappingName   string
+		devicePath    string
+		encryptedPath string
+	}{
+		{
+			name:          "volume name",
+			volume:        Volume{Name: "pvc-1", UID: "4f1c"},
+			mappingName:   "pvc-1",
+			devicePath:    "/dev/longhorn/pvc-1",
+			encryptedPath: "/dev/mapper/pvc-1",
+		},
+		{
+			name:          "volume name with uid",
+			volume:        Volume{Name: "pvc-1", UID: "4f1c", CryptoNameWithUID: true},
+			mappingName:   "pvc-1-4f1c",
+			devicePath:    "/dev/longhorn/pvc-1",
+			encryptedPath: "/dev/mapper/pvc-1-4f1c",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if name := tt.volume.CryptoMappingName(); name != tt.mappingName {
+				t.Errorf("expected mapping name %v, got %v", tt.mappingName, name)
+			}
+			if path := tt.volume.DevicePath(false); path != tt.devicePath {
+				t.Errorf("expected device path %v, got %v", tt.devicePath, path)
+			}
+			if path := tt.volume.DevicePath(true); path != tt.encryptedPath {
+				t.Errorf("expected encrypted device path %v, got %v", tt.encryptedPath, path)
+			}
+		})
+	}
+}
+
+func TestContextMountOptions(t *testing.T) {
+	tests := []struct {
+		context  string
+		expected string
+	}{
+		{context: "system_u:object_r:container_file_t:s0", expected: `context="system_u:object_r:container_file_t:s0"`},
+		{context: "system_u:object_r:container_file_t:s0:c1,c2", expected: `context="system_u:object_r:container_file_t:s0:c1,c2"`},
+		{context: "system_u:object_r:container_file_t:s0:c1.c3,c7", expected: `context="system_u:object_r:container_file_t:s0:c1.c3,c7"`},
+	}
+
+	for _, tt := range tests {
+		mountOptions := []string{"noatime"}
+		options := ContextMountOptions(tt.context, mountOptions)
+
+		expected := []string{"noatime", tt.expected}
+		if !reflect.DeepEqual(options, expected) {
+			t.Fatalf("expected %v, got %v", expected, options)
+		}
+		if len(mountOptions) != 1 {
+			t.Fatalf("mount options were modified: %v", mountOptions)
+		}
+	}
+}
+
+func TestACLMountOptions(t *testing.T) {
+	tests := []struct {
+		fsType       string
+		mountOptions []string
+		expected     []string
+	}{
+		{fsType: "ext4", mountOptions: nil, expected: []string{"acl"}},
+		{fsType: "ext4", mountOptions: []string{"noatime"}, expected: []string{"noatime", "acl"}},
+		{fsType: "ext4", mountOptions: []string{"acl"}, expected: []string{"acl"}},
+		{fsType: "xfs", mountOptions: []string{"noatime"}, expected: []string{"noatime"}},
+		{fsType: "btrfs", mountOptions: nil, expected: nil},
+	}
+
+	for _, tt := range tests {
+		mountOptions := append([]string{}, tt.mountOptions...)
+		if options := ACLMountOptions(tt.fsType, tt.mountOptions); !reflect.DeepEqual(options, tt.expected) {
+			t.Errorf("%v %v: expected %v, got %v", tt.fsType, tt.mountOptions, tt.expected, options)
+		}
+		if len(tt.mountOptions) > 0 && !reflect.DeepEqual(tt.mountOptions, mountOptions) {
+			t.Errorf("%v %v: mount options were modified", tt.fsType, mountOptions)
+		}
+	}
+}
+
+func TestClassifyMountError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{
+			name:     "fsck failed",
+			err:      mount.NewMountError(mount.HasFilesystemErrors, "'fsck' found errors on device /dev/longhorn/pvc-1 but could not correct them"),
+			expected: ErrFilesystemCorrupt,
+		},
+		{
+			name:     "disk format not detected",
+			err:      mount.NewMountError(mount.GetDiskFormatFailed, "failed to get disk format of disk /dev/longhorn/pvc-1"),
+			expected: ErrDeviceNotReady,
+		},
+		{
+			name:     "device missing",
+			err:      errors.New("mount failed: exit status 32, output: special device /dev/longhorn/pvc-1 does not exist"),
+			expected: ErrDeviceNotReady,
+		},
+		{
+			name:     "structure needs cleaning",
+			err:      errors.New("mount failed: exit status 32, output: mount(2) system call failed: Structure needs cleaning"),
+			expected: ErrFilesystemCorrupt,
+		},
+		{
+			name:     "already classified",
+			err:      fmt.Errorf("cannot mount device: %w", ErrDeviceNotReady),
+			expected: ErrDeviceNotReady,
+		},
+		{
+			name:     "context error",
+			err:      context.DeadlineExceeded,
+			expected: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ClassifyMountError(tt.err)
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	// an unreadable superblock or an I/O error can also be a damaged filesystem or a failing disk,
+	// which mounting again does not fix
+	for _, unknown := range []error{
+		errors.New("mount failed: exit status 32, output: permission denied"),
+		errors.New("mount failed: exit status 32, output: wrong fs type, bad option, can't read superblock on /dev/sdb"),
+		errors.New("mount failed: exit status 32, output: mount(2) system call failed: Input/output error"),
+	} {
+		if err := ClassifyMountError(unknown); err != unknown {
+			t.Fatalf("expected the unclassified error %v, got %v", unknown, err)
+		}
+	}
+	if err := ClassifyMountError(nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestCompressionMountOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression string
+		invalid     bool
+	}{
+		{name: "zstd", compression: "zstd"},
+		{name: "zstd with level", compression: "zstd:3"},
+		{name: "zlib with maximum level", compression: "zlib:9"},
+		{name: "lzo", compression: "lzo"},
+		{name: "lzo with level", compression: "lzo:1", invalid: true},
+		{name: "level out of range", compression: "zstd:16", invalid: true},
+		{name: "level zero", compression: "zlib:0", invalid: true},
+		{name: "level not a number", compression: "zstd:fast", invalid: true},
+		{name: "unknown algorithm", compression: "lz4", invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var options []string
+			err := ValidateCompression(tt.compression)
+			if err == nil {
+				options = CompressionMountOptions(tt.compression, []string{"noatime"})
+			}
+			checkOptions(t, options, err, []string{"noatime", "compress=" + tt.compression}, tt.invalid)
+		})
+	}
+}
+
+func TestJournalMountOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		volume   Volume
+		expected []string
+		invalid  bool
+	}{
+		{name: "no tuning", volume: Volume{FsType: "xfs"}, expected: []string{"noatime"}},
+		{name: "ext4 commit interval", volume: Volume{FsType: "ext4", CommitInterval: 30 * time.Second}, expected: []string{"noatime", "commit=30"}},
+		{name: "xfs log buffers", volume: Volume{FsType: "xfs", XFSLogBuffers: 8, XFSLogBufferSize: 256 * 1024}, expected: []string{"noatime", "logbufs=8", "logbsize=256k"}},
+		{name: "commit interval on xfs", volume: Volume{FsType: "xfs", CommitInterval: 30 * time.Second}, invalid: true},
+		{name: "commit interval not in whole seconds", volume: Volume{FsType: "ext4", CommitInterval: 1500 * time.Millisecond}, invalid: true},
+		{name: "commit interval too long", volume: Volume{FsType: "ext4", CommitInterval: 301 * time.Second}, invalid: true},
+		{name: "log buffers on ext4", volume: Volume{FsType: "ext4", XFSLogBuffers: 4}, invalid: true},
+		{name: "too many log buffers", volume: Volume{FsType: "xfs", XFSLogBuffers: 9}, invalid: true},
+		{name: "log buffer size not a power of two", volume: Volume{FsType: "xfs", XFSLogBufferSize: 48 * 1024}, invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := JournalMountOptions(tt.volume.FsType, tt.volume, []string{"noatime"})
+			checkOptions(t, options, err, tt.expected, tt.invalid)
+		})
+	}
+}
+
+func TestFormatOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		volume   Volume
+		expected []string
+		invalid  bool
+	}{
+		{name: "defaults", volume: Volume{FsType: "ext4"}},
+		{name: "ext4 sizes", volume: Volume{FsType: "ext4", FormatBlockSize: 4096, FormatInodeSize: 256, FormatBytesPerInode: 65536}, expected: []string{"-b", "4096", "-I", "256", "-i", "65536"}},
+		{name: "xfs sizes", volume: Volume{FsType: "xfs", FormatBlockSize: 4096, FormatInodeSize: 1024}, expected: []string{"-b", "size=4096", "-i", "size=1024"}},
+		{name: "unsupported filesystem", volume: Volume{FsType: "btrfs", FormatBlockSize: 4096}, invalid: true},
+		{name: "block size larger than a page", volume: Volume{FsType: "ext4", FormatBlockSize: 8192}, invalid: true},
+		{name: "block size not a power of two", volume: Volume{FsType: "ext4", FormatBlockSize: 3000}, invalid: true},
+		{name: "ext4 inode size larger than the block size", volume: Volume{FsType: "ext4", FormatBlockSize: 1024, FormatInodeSize: 2048}, invalid: true},
+		{name: "xfs inode size too small", volume: Volume{FsType: "xfs", FormatInodeSize: 256}, invalid: true},
+		{name: "bytes per inode on xfs", volume: Volume{FsType: "xfs", FormatBytesPerInode: 65536}, invalid: true},
+		{name: "bytes per inode smaller than the block size", volume: Volume{FsType: "ext4", FormatBlockSize: 4096, FormatBytesPerInode: 2048}, invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := FormatOptions(tt.volume.FsType, tt.volume)
+			checkOptions(t, options, err, tt.expected, tt.invalid)
+		})
+	}
+}
+
+// checkOptions checks that an invalid case is rejected and a valid one results in the expected options
+func checkOptions(t *testing.T, options []string, err error, expected []string, invalid bool) {
+	t.Helper()
+
+	if (err != nil) != invalid {
+		t.Fatalf("expected invalid %v, got %v", invalid, err)
+	}
+	if !invalid && !reflect.DeepEqual(options, expected) {
+		t.Fatalf("expected %v, got %v", expected, options)
+	}
+}